@@ -2,6 +2,7 @@ package pragmash
 
 import (
 	"errors"
+	"strconv"
 )
 
 // These are the types of breakout which pragmash currently supports.
@@ -10,10 +11,33 @@ const (
 	BreakoutTypeBreak     = iota
 	BreakoutTypeContinue  = iota
 	BreakoutTypeReturn    = iota
+	BreakoutTypeAbort     = iota
 )
 
+// An AbortError is an error which stops a script entirely.
+// Unlike other errors, it cannot be caught by a try block.
+type AbortError struct {
+	Err error
+}
+
+// Error returns the message of the underlying error.
+func (a *AbortError) Error() string {
+	return a.Err.Error()
+}
+
+// An ExitError is returned by the exit command, wrapped in an AbortError, so
+// that the script stops and whatever is running it can exit with the code.
+type ExitError struct {
+	Code int
+}
+
+// Error returns a message with the exit code.
+func (e *ExitError) Error() string {
+	return "exit status " + strconv.Itoa(e.Code)
+}
+
 // A Breakout is used to jump out of some scope in pragmash.
-// Breakouts are used for exceptions, loop control, and return values.
+// Breakouts are used for exceptions, loop control, return values, and aborts.
 type Breakout struct {
 	typeNum int
 	context string
@@ -22,7 +46,11 @@ type Breakout struct {
 }
 
 // NewBreakoutException creates a new exception.
+// If err is an *AbortError, this creates an abort breakout instead.
 func NewBreakoutException(context string, err error) *Breakout {
+	if _, ok := err.(*AbortError); ok {
+		return &Breakout{BreakoutTypeAbort, context, err, nil}
+	}
 	return &Breakout{BreakoutTypeException, context, err, nil}
 }
 
@@ -54,6 +82,20 @@ func (b *Breakout) Error() error {
 	return b.err
 }
 
+// ExitCode returns the exit code if the breakout was caused by the exit
+// command.
+func (b *Breakout) ExitCode() (int, bool) {
+	if b.typeNum != BreakoutTypeAbort {
+		return 0, false
+	}
+	if abort, ok := b.err.(*AbortError); ok {
+		if exit, ok := abort.Err.(*ExitError); ok {
+			return exit.Code, true
+		}
+	}
+	return 0, false
+}
+
 // Type returns the type of the breakout.
 func (b *Breakout) Type() int {
 	return b.typeNum
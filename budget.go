@@ -0,0 +1,183 @@
+package pragmash
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrCommandLimit is the error wrapped by the AbortError which a BudgetRunner
+// returns once a script has run too many commands.
+var ErrCommandLimit = errors.New("command limit exceeded")
+
+// A BudgetRunner limits the resources which a script may use.
+//
+// Every command fails with an *AbortError once the context is done or once
+// the maximum number of commands has been run. Each iteration of a loop counts
+// as a command, so that a loop with an empty body is stopped as well. Commands
+// like sleep, cmd, and http_get stop as soon as the context is done.
+type BudgetRunner struct {
+	wrappedRunner
+	budget *commandBudget
+	ctx    context.Context
+}
+
+// NewBudgetRunner wraps a Runner with a context and an optional limit on the
+// number of commands. If maxCommands is 0, the number of commands is not
+// limited.
+func NewBudgetRunner(ctx context.Context, r Runner,
+	maxCommands int) *BudgetRunner {
+	res := &BudgetRunner{wrappedRunner{r}, &commandBudget{max: maxCommands},
+		ctx}
+	res.SetOuter(res)
+	return res
+}
+
+// Commands returns the number of commands and loop iterations which have been
+// run.
+func (b *BudgetRunner) Commands() int {
+	return b.budget.used
+}
+
+// Context returns the context of the runner.
+func (b *BudgetRunner) Context() context.Context {
+	return b.ctx
+}
+
+// RunCommand runs a command with the wrapped Runner if the budget allows it.
+func (b *BudgetRunner) RunCommand(name string, args []*Value) (*Value,
+	error) {
+	if err := b.step(); err != nil {
+		return nil, err
+	}
+	res, err := b.inner.RunCommand(name, args)
+	if err != nil {
+		if ctxErr := b.ctx.Err(); ctxErr != nil {
+			// The command probably failed because it was interrupted.
+			return nil, &AbortError{ctxErr}
+		}
+	}
+	return res, err
+}
+
+// inherit wraps a Runner which will run a separate script on behalf of the
+// script being run by b. Both scripts share the same context and budget.
+func (b *BudgetRunner) inherit(r Runner) Runner {
+	res := &BudgetRunner{wrappedRunner{r}, b.budget, b.ctx}
+	res.SetOuter(res)
+	return res
+}
+
+// step counts a command or a loop iteration against the budget. It returns an
+// *AbortError once the context is done or the budget is spent.
+func (b *BudgetRunner) step() error {
+	if err := b.ctx.Err(); err != nil {
+		return &AbortError{err}
+	}
+	if b.budget.max > 0 && b.budget.used >= b.budget.max {
+		return &AbortError{ErrCommandLimit}
+	}
+	b.budget.used++
+	return nil
+}
+
+// stepBudgets counts a loop iteration against every BudgetRunner in a chain of
+// WrapperRunners.
+func stepBudgets(r Runner) error {
+	for r != nil {
+		if b, ok := r.(*BudgetRunner); ok {
+			if err := b.step(); err != nil {
+				return err
+			}
+		}
+		w, ok := r.(WrapperRunner)
+		if !ok {
+			break
+		}
+		r = w.Unwrap()
+	}
+	return nil
+}
+
+// A commandBudget counts the commands run by one or more BudgetRunners.
+type commandBudget struct {
+	max  int
+	used int
+}
+
+// wrappedRunner implements the parts of a WrapperRunner which simply forward
+// to the wrapped Runner.
+type wrappedRunner struct {
+	inner Runner
+}
+
+// SetOuter forwards the outer Runner to the wrapped Runner.
+func (w wrappedRunner) SetOuter(r Runner) {
+	if o, ok := w.inner.(OuterRunner); ok {
+		o.SetOuter(r)
+	}
+}
+
+// Unwrap returns the wrapped Runner.
+func (w wrappedRunner) Unwrap() Runner {
+	return w.inner
+}
+
+// forwardingRunner is a wrappedRunner which runs and resolves every command
+// with the wrapped Runner, so that wrapping a Resolver still allows scripts to
+// be compiled against it.
+type forwardingRunner struct {
+	wrappedRunner
+}
+
+// RunCommand runs a command with the wrapped Runner.
+func (f forwardingRunner) RunCommand(name string, args []*Value) (*Value,
+	error) {
+	return f.inner.RunCommand(name, args)
+}
+
+// ResolveCommand resolves a command with the wrapped Runner. It returns nil if
+// the wrapped Runner is not a Resolver.
+func (f forwardingRunner) ResolveCommand(name string) (CommandFunc, bool) {
+	if r, ok := f.inner.(Resolver); ok {
+		return r.ResolveCommand(name)
+	}
+	return nil, false
+}
+
+// Variable returns a variable slot from the wrapped Runner, or nil if the
+// wrapped Runner is not a Resolver.
+func (f forwardingRunner) Variable(name string) *Variable {
+	if r, ok := f.inner.(Resolver); ok {
+		return r.Variable(name)
+	}
+	return nil
+}
+
+// An inheritingRunner is a wrapper which places limits on a script. The same
+// limits are placed on the scripts it starts with the pragmash command.
+type inheritingRunner interface {
+	inherit(r Runner) Runner
+}
+
+// inheritRunner wraps a Runner for a script which is started from a script
+// running in parent, so that the limits placed on the parent also apply.
+func inheritRunner(parent Runner, r Runner) Runner {
+	var limits []inheritingRunner
+	for parent != nil {
+		if x, ok := parent.(inheritingRunner); ok {
+			limits = append(limits, x)
+		}
+		w, ok := parent.(WrapperRunner)
+		if !ok {
+			break
+		}
+		parent = w.Unwrap()
+	}
+
+	// Apply the innermost limit first so the wrappers end up in the same
+	// order as they are around the parent.
+	for i := len(limits) - 1; i >= 0; i-- {
+		r = limits[i].inherit(r)
+	}
+	return r
+}
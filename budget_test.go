@@ -0,0 +1,85 @@
+package pragmash
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBudgetTimeout(t *testing.T) {
+	script := "try {\nwhile {\n}\n} catch e {\n}\n"
+	for _, mode := range runModes {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			time.Millisecond*10)
+		runner := NewBudgetRunner(ctx, NewStdRunner(nil), 0)
+		bo, err := runSource(script, runner, mode)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		} else if bo == nil || bo.Type() != BreakoutTypeAbort {
+			t.Fatalf("expected %s abort breakout", modeNames[mode])
+		} else if bo.Error().Error() != context.DeadlineExceeded.Error() {
+			t.Error("unexpected error:", bo.Error())
+		}
+	}
+}
+
+func TestBudgetCommandLimit(t *testing.T) {
+	script := "set x 0\ntry {\nwhile {\nset x (+ $x 1)\n}\n} catch e {\n}\n"
+	for _, mode := range runModes {
+		runner := NewBudgetRunner(context.Background(), NewStdRunner(nil), 30)
+		bo, err := runSource(script, runner, mode)
+		if err != nil {
+			t.Fatal(err)
+		} else if bo == nil || bo.Type() != BreakoutTypeAbort {
+			t.Fatalf("expected %s abort breakout", modeNames[mode])
+		} else if bo.Error().Error() != ErrCommandLimit.Error() {
+			t.Error("unexpected error:", bo.Error())
+		}
+		if runner.Commands() != 30 {
+			t.Error("unexpected command count:", runner.Commands())
+		}
+	}
+}
+
+func TestBudgetEmptyLoop(t *testing.T) {
+	// Loops which run no commands still count toward the limit.
+	scripts := []string{"try {\nwhile {\n}\n} catch e {\n}\n",
+		"for (range 1000) {\n}\n"}
+	for _, script := range scripts {
+		for _, mode := range runModes {
+			ctx, cancel := context.WithTimeout(context.Background(),
+				time.Second*5)
+			runner := NewBudgetRunner(ctx, NewStdRunner(nil), 100)
+			bo, err := runSource(script, runner, mode)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			} else if bo == nil || bo.Type() != BreakoutTypeAbort {
+				t.Fatalf("expected %s abort breakout", modeNames[mode])
+			} else if bo.Error().Error() != ErrCommandLimit.Error() {
+				t.Errorf("%q (%s): unexpected error: %s", script,
+					modeNames[mode], bo.Error())
+			}
+		}
+	}
+}
+
+func TestBudgetSleep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := NewBudgetRunner(ctx, NewStdRunner(nil), 0)
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		cancel()
+	}()
+	start := time.Now()
+	bo, err := runSource("eval \"sleep 10\"", runner, runTree)
+	if err != nil {
+		t.Fatal(err)
+	} else if bo == nil || bo.Type() != BreakoutTypeAbort {
+		t.Fatal("expected abort breakout")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("sleep was not interrupted")
+	}
+}
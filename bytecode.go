@@ -0,0 +1,498 @@
+package pragmash
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// bytecodeMagic is written at the start of every serialized Program.
+const bytecodeMagic = "PRAGMASH-BYTECODE 1\n"
+
+// An Opcode identifies the operation performed by an Instruction.
+type Opcode uint8
+
+// These are the instructions understood by the VM.
+//
+// Every Runnable compiles to a sequence of instructions which leaves exactly
+// one value on the stack.
+const (
+	// OpPush pushes constant A.
+	OpPush Opcode = iota
+
+	// OpEmpty pushes an empty value.
+	OpEmpty
+
+	// OpTrue pushes a true value.
+	OpTrue
+
+	// OpPop discards the top of the stack.
+	OpPop
+
+	// OpCall pops B arguments and runs the command named by constant A.
+	OpCall
+
+	// OpCallDynamic pops B arguments and then the command name.
+	OpCallDynamic
+
+	// OpJump jumps to instruction A.
+	OpJump
+
+	// OpJumpFalse pops a value and jumps to instruction A if it is false.
+	OpJumpFalse
+
+	// OpCondEq pops a value and compares it to the top of the stack. If they
+	// differ, the top of the stack is popped as well and execution jumps to
+	// instruction A.
+	OpCondEq
+
+	// OpNot replaces the top of the stack with its boolean negation.
+	OpNot
+
+	// OpReturn pops B values and returns them joined by spaces.
+	OpReturn
+
+	// OpBreakout stops execution with a breakout of type A.
+	OpBreakout
+
+	// OpTry registers an exception handler at instruction A.
+	OpTry
+
+	// OpEndTry removes the most recent exception handler.
+	OpEndTry
+
+	// OpCatch pops a variable name and an exception message and assigns the
+	// message to the variable.
+	OpCatch
+
+	// OpIterate starts a for loop. If bit 1 of B is set, it pops an index
+	// variable name. If bit 0 of B is set, it pops a variable name. Finally, it
+	// pops the array to iterate over.
+	OpIterate
+
+	// OpNext assigns the loop variables for the next element of the current
+	// for loop, or ends the loop and jumps to instruction A if there are no
+	// elements left.
+	OpNext
+
+	// OpEndIterate ends the current for loop.
+	OpEndIterate
+
+	// OpEnter marks the start of a command, before its name and arguments are
+	// evaluated. It only has an effect if the Runner is an Observer.
+	OpEnter
+)
+
+// These are the flags used by OpIterate.
+const (
+	iterateVariable = 1 << iota
+	iterateIndex
+)
+
+// An Instruction is a single step in a Program.
+type Instruction struct {
+	Op      Opcode
+	A       int
+	B       int
+	Context int
+}
+
+// A Program is the bytecode representation of a Runnable.
+type Program struct {
+	Code      []Instruction
+	Constants []string
+	Contexts  []string
+
+	values []*Value
+}
+
+// DecodeProgram reads a Program which was written by Encode.
+func DecodeProgram(r io.Reader) (*Program, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(bytecodeMagic))
+	if _, err := io.ReadFull(br, magic); err != nil ||
+		string(magic) != bytecodeMagic {
+		return nil, errors.New("not a pragmash bytecode file")
+	}
+	var p Program
+	if err := gob.NewDecoder(br).Decode(&p); err != nil {
+		return nil, err
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// IsBytecode returns true if some data starts like an encoded Program.
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(bytecodeMagic))
+}
+
+// Encode writes the Program in a form which can be read by DecodeProgram.
+func (p *Program) Encode(w io.Writer) error {
+	if _, err := io.WriteString(w, bytecodeMagic); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(p)
+}
+
+// validate makes sure that every constant, context, and jump target in the
+// program exists so that a corrupt file cannot crash the VM.
+func (p *Program) validate() error {
+	for i, inst := range p.Code {
+		pos := "instruction " + strconv.Itoa(i)
+		if inst.Context < 0 || inst.Context >= len(p.Contexts) {
+			return errors.New("invalid context at " + pos)
+		}
+		switch inst.Op {
+		case OpPush, OpCall:
+			if inst.A < 0 || inst.A >= len(p.Constants) {
+				return errors.New("invalid constant at " + pos)
+			}
+		case OpJump, OpJumpFalse, OpCondEq, OpTry, OpNext:
+			if inst.A < 0 || inst.A > len(p.Code) {
+				return errors.New("invalid jump target at " + pos)
+			}
+		case OpBreakout:
+			if inst.A != BreakoutTypeBreak &&
+				inst.A != BreakoutTypeContinue {
+				return errors.New("invalid breakout at " + pos)
+			}
+		default:
+			if inst.Op > OpEnter {
+				return errors.New("invalid opcode at " + pos)
+			}
+		}
+		if inst.B < 0 {
+			return errors.New("invalid operand at " + pos)
+		}
+	}
+	return nil
+}
+
+// CompileBytecode converts a Runnable from ScanAll into a Program.
+// This fails if the Runnable contains a type which has no bytecode equivalent.
+func CompileBytecode(r Runnable) (*Program, error) {
+	c := &bytecodeCompiler{program: &Program{Contexts: []string{""}},
+		constants: map[string]int{}, contexts: map[string]int{"": 0}}
+	if err := c.runnable(r); err != nil {
+		return nil, err
+	}
+	return c.program, nil
+}
+
+// A bytecodeLoop tracks the jumps which still need to be patched once the end
+// of a loop is known.
+type bytecodeLoop struct {
+	breaks    []int
+	continues []int
+	tries     int
+	tests     int
+}
+
+type bytecodeCompiler struct {
+	program   *Program
+	constants map[string]int
+	contexts  map[string]int
+	loops     []*bytecodeLoop
+	tries     int
+	tests     int
+}
+
+func (c *bytecodeCompiler) breakout(typeNum int, context string) error {
+	if c.tests > 0 && (len(c.loops) == 0 ||
+		c.loops[len(c.loops)-1].tests < c.tests) {
+		return errors.New("cannot compile a break or continue out of a " +
+			"test to bytecode")
+	} else if len(c.loops) == 0 {
+		c.emit(OpBreakout, typeNum, 0, context)
+		return nil
+	}
+	loop := c.loops[len(c.loops)-1]
+	for i := loop.tries; i < c.tries; i++ {
+		c.emit(OpEndTry, 0, 0, context)
+	}
+	jump := c.emit(OpJump, 0, 0, context)
+	if typeNum == BreakoutTypeBreak {
+		loop.breaks = append(loop.breaks, jump)
+	} else {
+		loop.continues = append(loop.continues, jump)
+	}
+	return nil
+}
+
+func (c *bytecodeCompiler) command(x CommandRunnable) error {
+	c.emit(OpEnter, 0, 0, x.Context)
+	name, constName := x.Name.(*Value)
+	if !constName {
+		if err := c.runnable(x.Name); err != nil {
+			return err
+		}
+	}
+	if err := c.runnables(x.Arguments); err != nil {
+		return err
+	}
+	if constName {
+		nameIdx := c.constant(name.String())
+		c.emit(OpCall, nameIdx, len(x.Arguments), x.Context)
+	} else {
+		c.emit(OpCallDynamic, 0, len(x.Arguments), x.Context)
+	}
+	return nil
+}
+
+func (c *bytecodeCompiler) condition(x Condition) error {
+	if len(x) == 0 {
+		c.emit(OpTrue, 0, 0, "")
+		return nil
+	} else if len(x) == 1 {
+		return c.runnable(x[0])
+	}
+	if err := c.runnable(x[0]); err != nil {
+		return err
+	}
+	failures := make([]int, 0, len(x)-1)
+	for i := 1; i < len(x); i++ {
+		if err := c.runnable(x[i]); err != nil {
+			return err
+		}
+		failures = append(failures, c.emit(OpCondEq, 0, 0, ""))
+	}
+	c.emit(OpPop, 0, 0, "")
+	c.emit(OpTrue, 0, 0, "")
+	end := c.emit(OpJump, 0, 0, "")
+	c.patch(failures...)
+	c.emit(OpEmpty, 0, 0, "")
+	c.patch(end)
+	return nil
+}
+
+func (c *bytecodeCompiler) constant(s string) int {
+	if idx, ok := c.constants[s]; ok {
+		return idx
+	}
+	idx := len(c.program.Constants)
+	c.program.Constants = append(c.program.Constants, s)
+	c.constants[s] = idx
+	return idx
+}
+
+// emit adds an instruction and returns its index.
+func (c *bytecodeCompiler) emit(op Opcode, a, b int, context string) int {
+	ctx, ok := c.contexts[context]
+	if !ok {
+		ctx = len(c.program.Contexts)
+		c.program.Contexts = append(c.program.Contexts, context)
+		c.contexts[context] = ctx
+	}
+	c.program.Code = append(c.program.Code, Instruction{op, a, b, ctx})
+	return len(c.program.Code) - 1
+}
+
+func (c *bytecodeCompiler) forLoop(x For) error {
+	if err := c.runnable(x.Expression); err != nil {
+		return err
+	}
+	flags := 0
+	if x.Variable != nil {
+		if err := c.runnable(x.Variable); err != nil {
+			return err
+		}
+		flags |= iterateVariable
+	}
+	if x.Index != nil {
+		if err := c.runnable(x.Index); err != nil {
+			return err
+		}
+		flags |= iterateIndex
+	}
+	c.emit(OpIterate, 0, flags, x.Context)
+	top := len(c.program.Code)
+	exit := c.emit(OpNext, 0, 0, x.Context)
+	loop, err := c.loopBody(x.Body)
+	if err != nil {
+		return err
+	}
+	c.emit(OpJump, top, 0, x.Context)
+	c.patch(loop.breaks...)
+	c.emit(OpEndIterate, 0, 0, "")
+	c.patch(exit)
+	c.emit(OpEmpty, 0, 0, "")
+	c.patchTo(top, loop.continues...)
+	return nil
+}
+
+func (c *bytecodeCompiler) ifBlock(x If) error {
+	ends := make([]int, 0, len(x.Conditions))
+	for i, cond := range x.Conditions {
+		if err := c.runnable(cond); err != nil {
+			return err
+		}
+		next := c.emit(OpJumpFalse, 0, 0, "")
+		if err := c.runnable(x.Branches[i]); err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(OpJump, 0, 0, ""))
+		c.patch(next)
+	}
+	c.emit(OpEmpty, 0, 0, "")
+	c.patch(ends...)
+	return nil
+}
+
+// loopBody compiles the body of a loop and discards its value. The returned
+// loop contains the unpatched break and continue jumps.
+func (c *bytecodeCompiler) loopBody(body Runnable) (*bytecodeLoop, error) {
+	loop := &bytecodeLoop{tries: c.tries, tests: c.tests}
+	c.loops = append(c.loops, loop)
+	err := c.runnable(body)
+	c.loops = c.loops[:len(c.loops)-1]
+	if err != nil {
+		return nil, err
+	}
+	c.emit(OpPop, 0, 0, "")
+	return loop, nil
+}
+
+// patch sets the targets of jump instructions to the next instruction.
+func (c *bytecodeCompiler) patch(jumps ...int) {
+	c.patchTo(len(c.program.Code), jumps...)
+}
+
+func (c *bytecodeCompiler) patchTo(target int, jumps ...int) {
+	for _, j := range jumps {
+		c.program.Code[j].A = target
+	}
+}
+
+func (c *bytecodeCompiler) runnable(r Runnable) error {
+	switch x := r.(type) {
+	case *Value:
+		c.emit(OpPush, c.constant(x.String()), 0, "")
+	case BreakRunner:
+		return c.breakout(BreakoutTypeBreak, x.Context)
+	case CommandRunnable:
+		return c.command(x)
+	case Condition:
+		return c.condition(x)
+	case ContinueRunner:
+		return c.breakout(BreakoutTypeContinue, x.Context)
+	case For:
+		return c.forLoop(x)
+	case If:
+		return c.ifBlock(x)
+	case NotCondition:
+		if err := c.condition(Condition(x)); err != nil {
+			return err
+		}
+		c.emit(OpNot, 0, 0, "")
+	case ReturnRunner:
+		if c.tests > 0 {
+			return errors.New("cannot compile return in a test to bytecode")
+		}
+		if err := c.runnables(x.Arguments); err != nil {
+			return err
+		}
+		c.emit(OpReturn, 0, len(x.Arguments), x.Context)
+	case RunnableList:
+		if len(x) == 0 {
+			c.emit(OpEmpty, 0, 0, "")
+		}
+		for i, item := range x {
+			if i != 0 {
+				c.emit(OpPop, 0, 0, "")
+			}
+			if err := c.runnable(item); err != nil {
+				return err
+			}
+		}
+	case TestBlock:
+		return c.testBlock(x)
+	case Try:
+		return c.tryBlock(x)
+	case While:
+		return c.whileLoop(x)
+	default:
+		return errors.New("cannot compile runnable to bytecode")
+	}
+	return nil
+}
+
+func (c *bytecodeCompiler) runnables(list []Runnable) error {
+	for _, x := range list {
+		if err := c.runnable(x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// testBlock compiles a test to run its body like any other block, since
+// programs are never run as tests.
+func (c *bytecodeCompiler) testBlock(x TestBlock) error {
+	if err := c.runnable(x.Name); err != nil {
+		return err
+	}
+	c.emit(OpPop, 0, 0, "")
+	c.tests++
+	err := c.runnable(x.Body)
+	c.tests--
+	if err != nil {
+		return err
+	}
+	c.emit(OpPop, 0, 0, "")
+	c.emit(OpEmpty, 0, 0, "")
+	return nil
+}
+
+func (c *bytecodeCompiler) tryBlock(x Try) error {
+	handler := c.emit(OpTry, 0, 0, "")
+	c.tries++
+	err := c.runnable(x.Try)
+	c.tries--
+	if err != nil {
+		return err
+	}
+	c.emit(OpPop, 0, 0, "")
+	c.emit(OpEndTry, 0, 0, "")
+	c.emit(OpEmpty, 0, 0, "")
+	end := c.emit(OpJump, 0, 0, "")
+
+	// The handler starts with the exception message on the stack.
+	c.patch(handler)
+	if x.Variable != nil {
+		if err := c.runnable(x.Variable); err != nil {
+			return err
+		}
+		c.emit(OpCatch, 0, 0, x.CatchContext)
+	} else {
+		c.emit(OpPop, 0, 0, "")
+	}
+	if err := c.runnable(x.Catch); err != nil {
+		return err
+	}
+	c.patch(end)
+	return nil
+}
+
+func (c *bytecodeCompiler) whileLoop(x While) error {
+	top := len(c.program.Code)
+	if err := c.runnable(x.Condition); err != nil {
+		return err
+	}
+	exit := c.emit(OpJumpFalse, 0, 0, "")
+	loop, err := c.loopBody(x.Body)
+	if err != nil {
+		return err
+	}
+	c.emit(OpJump, top, 0, x.Context)
+	c.patch(exit)
+	c.patch(loop.breaks...)
+	c.emit(OpEmpty, 0, 0, "")
+	c.patchTo(top, loop.continues...)
+	return nil
+}
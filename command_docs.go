@@ -0,0 +1,1387 @@
+package pragmash
+
+// commandCategories lists the sections of the command reference in order.
+var commandCategories = []commandCategory{
+	{"Operators", "api-operators", "Unlike most other programming " +
+		"languages, pragmash does not include built-in operators. In " +
+		"their place, it provides symbolically-named commands. Here's the " +
+		"list of these commands."},
+	{"I/O", "api-io", ""},
+	{"Language essentials", "api-language", ""},
+	{"Strings", "api-strings", ""},
+	{"Arrays", "api-arrays", ""},
+	{"JSON", "api-json", "These commands read and build JSON documents. " +
+		"A path selects a value inside a document. It lists object keys " +
+		"and array indices separated by dots, such as \"users.0.name\". " +
+		"An empty path selects the whole document."},
+	{"CSV", "api-csv", "These commands read and write CSV documents. " +
+		"Since an array cannot hold other arrays, a document is handled " +
+		"as an array of rows, and each row is a line of CSV which " +
+		"csv_fields splits into its fields. The first row is the header."},
+	{"Filesystem", "api-filesystem", ""},
+	{"Math", "api-math", ""},
+	{"Time", "api-time", ""},
+	{"Testing", "api-testing", "These commands are meant for test " +
+		"scripts which are run by `pragmash test`."},
+}
+
+// commandDocs documents every command in the standard library, in the order
+// of the command reference. The tests check it against the methods of StdAll
+// and run its examples, and COMMANDS.md is generated from it.
+var commandDocs = []*CommandDoc{
+	// Operators
+	{Name: "+", Category: "Operators",
+		Usage: "+ [number...]",
+		Pure:  true,
+		Doc: "This takes 0 or more arguments and returns their sum. " +
+			"If no numbers are provided, \"0\" is returned. The numbers " +
+			"can be floating-points or big integers.",
+		Examples: []CommandExample{
+			{Code: "+", Result: "0"},
+			{Code: "+ 1 2 3", Result: "6"},
+			{Code: "+ 3 -2 1", Result: "2"},
+			{Code: "+ 1.5 2.5", Result: "4"},
+		},
+	},
+	{Name: "*", Category: "Operators",
+		Usage: "* [number...]",
+		Pure:  true,
+		Doc: "This takes 0 or more arguments and returns their " +
+			"product. If no numbers are provided, \"1\" is returned. The " +
+			"numbers can be floating-points or big integers.",
+		Examples: []CommandExample{
+			{Code: "* 3 5", Result: "15"},
+			{Code: "* 1 2 3 4", Result: "24"},
+			{Code: "* 1.5 2.5", Result: "3.75"},
+		},
+	},
+	{Name: "/", Category: "Operators",
+		Usage: "/ <numerator> <denominator>",
+		Pure:  true,
+		Doc: "This takes exactly two arguments and returns the first " +
+			"divided by the second. If both numbers are big integers, this " +
+			"may return a big integer if the quotient is a whole number. " +
+			"If the denominator is 0, this throws an exception.",
+		Examples: []CommandExample{
+			{Code: "/ 2 3", Result: "0.6666666666666666"},
+			{Code: "/ 9 3", Result: "3"},
+			{Code: "/ 1 0", Throws: true},
+			{Code: "/ 1000000000000000000000000000000000000000000000010 10",
+				Result: "100000000000000000000000000000000000000000000001"},
+		},
+	},
+	{Name: "-", Category: "Operators",
+		Usage: "- <number> <number>",
+		Pure:  true,
+		Doc: "This takes exactly two arguments and returns the first " +
+			"minus the second.",
+		Examples: []CommandExample{
+			{Code: "- 3 2", Result: "1"},
+			{Code: "- 2 3", Result: "-1"},
+			{Code: "- 2 1.5", Result: "0.5"},
+		},
+	},
+	{Name: "%", Category: "Operators",
+		Usage: "% <number> <modulus>",
+		Pure:  true,
+		Doc: "This takes two arguments and returns the first modulo " +
+			"the second. If either argument is not an integer, this " +
+			"computes `a - b*floor(a/b)` where *a* is the first argument " +
+			"and *b* is the second.",
+		Examples: []CommandExample{
+			{Code: "% 3 2", Result: "1"},
+			{Code: "% -10 3", Result: "2"},
+			{Code: "% 30.5 10", Result: "0.5"},
+		},
+	},
+	{Name: "**", Category: "Operators",
+		Usage: "** <number> <exponent>",
+		Doc: "This raises the first argument to the power of the " +
+			"second. If both arguments are integers, the result is an " +
+			"exact big integer.",
+		Examples: []CommandExample{
+			{Code: "** 2 10", Result: "1024"},
+			{Code: "** 4 0.5", Result: "2"},
+			{Code: "** 10 30", Result: "1000000000000000000000000000000"},
+		},
+	},
+	{Name: "[]", Category: "Operators",
+		Usage: "[] <array> <index>",
+		Pure:  true,
+		Doc: "This is used to access an element in a list which is " +
+			"delimited by newlines. The first argument is the list, the " +
+			"second is the index.",
+		Examples: []CommandExample{
+			{Code: "[] \"hey\\nthere\" 0", Result: "hey"},
+			{Code: "[] \"hey\\nthere\" 1", Result: "there"},
+			{Code: "[] \"hey\\nthere\" 2", Throws: true},
+		},
+	},
+	{Name: "<=", Category: "Operators",
+		Usage: "<= <number> <number>",
+		Pure:  true,
+		Doc: "This takes two numerical arguments and checks if the " +
+			"first is less than or equal to the second. It returns " +
+			"\"true\" in such a case, and \"\" otherwise.",
+	},
+	{Name: ">=", Category: "Operators",
+		Usage: ">= <number> <number>",
+		Pure:  true,
+		Doc: "This takes two numerical arguments and checks if the " +
+			"first is greater than or equal to the second. It returns " +
+			"\"true\" in such a case, and \"\" otherwise.",
+	},
+	{Name: "<", Category: "Operators",
+		Usage: "< <number> <number>",
+		Pure:  true,
+		Doc: "This takes two numerical arguments and checks if the " +
+			"first is less than the second. It returns \"true\" in such a " +
+			"case, and \"\" otherwise.",
+	},
+	{Name: ">", Category: "Operators",
+		Usage: "> <number> <number>",
+		Pure:  true,
+		Doc: "This takes two numerical arguments and checks if the " +
+			"first is greater than the second. It returns \"true\" in such " +
+			"a case, and \"\" otherwise.",
+	},
+	{Name: "=", Category: "Operators",
+		Usage: "= <string> <string>",
+		Pure:  true,
+		Doc: "This takes zero or more arguments and returns \"true\" " +
+			"if and only if all its arguments are equal when compared as " +
+			"strings. Otherwise, this returns \"\".",
+	},
+	{Name: "&&", Category: "Operators",
+		Usage: "&& [bool...]",
+		Pure:  true,
+		Doc: "This takes zero or more arguments and returns \"true\" " +
+			"if none of the arguments are empty. Otherwise, this returns " +
+			"\"\".",
+	},
+	{Name: "||", Category: "Operators",
+		Usage: "|| [string...]",
+		Pure:  true,
+		Doc: "This takes zero or more arguments and returns its first " +
+			"non-empty argument. If all arguments are empty or no " +
+			"arguments were supplied, this returns \"\".",
+	},
+	// I/O
+	{Name: "gets", Category: "I/O",
+		Usage: "gets",
+		Doc: "This reads a line from the console and returns it. A " +
+			"newline character is not included in the resulting string.",
+	},
+	{Name: "print", Category: "I/O",
+		Usage: "print [string...]",
+		Doc: "This prints all of its arguments to the console " +
+			"separated by spaces. It does not print a newline, but it does " +
+			"flush the output.",
+	},
+	{Name: "puts", Category: "I/O",
+		Usage: "puts [string...]",
+		Doc: "This prints all of its arguments to the console " +
+			"separated by spaces. It follows this output with a newline " +
+			"character.",
+	},
+	{Name: "http_get", Category: "I/O",
+		Usage: "http_get <url> [headers...]",
+		Doc: "This runs an HTTP get request and returns the body of " +
+			"the response. Each header should be of the form \"Name: " +
+			"value\".\n\nThe HTTP commands share a session which lasts " +
+			"until the script ends. The session holds the cookies, the " +
+			"default headers, the base URL, and the other settings which " +
+			"the commands below change.",
+	},
+	{Name: "http_post", Category: "I/O",
+		Usage: "http_post <url> <content-type> <body> [headers...]",
+		Doc: "This runs an HTTP post request and returns the body of " +
+			"the response. Each header should be of the form \"Name: " +
+			"value\".",
+	},
+	{Name: "http_put", Category: "I/O",
+		Usage: "http_put <url> <content-type> <body> [headers...]",
+		Doc: "This runs an HTTP put request and returns the body of " +
+			"the response. Each header should be of the form \"Name: " +
+			"value\".",
+	},
+	{Name: "http_patch", Category: "I/O",
+		Usage: "http_patch <url> <content-type> <body> [headers...]",
+		Doc: "This runs an HTTP patch request and returns the body of " +
+			"the response. Each header should be of the form \"Name: " +
+			"value\".",
+	},
+	{Name: "http_delete", Category: "I/O",
+		Usage: "http_delete <url> [headers...]",
+		Doc: "This runs an HTTP delete request and returns the body of " +
+			"the response. Each header should be of the form \"Name: " +
+			"value\".",
+	},
+	{Name: "http_head", Category: "I/O",
+		Usage: "http_head <url> [headers...]",
+		Doc: "This runs an HTTP head request. The status and headers " +
+			"of the response can be read with http_status and " +
+			"http_response_header.",
+	},
+	{Name: "http_download", Category: "I/O",
+		Usage: "http_download <url> <path> [headers...]",
+		Doc: "This runs an HTTP get request and writes the body of the " +
+			"response to a file as it arrives, so large files are never " +
+			"held in memory. If the download fails, the file is removed.",
+	},
+	{Name: "http_status", Category: "I/O",
+		Usage: "http_status",
+		Doc: "This returns the status code of the last HTTP response, " +
+			"such as \"200\" or \"404\". It throws an exception if no " +
+			"request has been made.",
+	},
+	{Name: "http_response_header", Category: "I/O",
+		Usage: "http_response_header [name]",
+		Doc: "This returns a header of the last HTTP response. If the " +
+			"header was sent more than once, its values are returned as " +
+			"an array. Without a name, this returns an array with every " +
+			"header line of the response, such as \"Content-Type: " +
+			"text/html\". It throws an exception if no request has been " +
+			"made.",
+	},
+	{Name: "http_strict", Category: "I/O",
+		Usage: "http_strict <on|off>",
+		Doc: "When this is on, HTTP requests throw an exception if the " +
+			"status code of the response is not 2xx. The status and " +
+			"headers of the response can still be read. It is off by " +
+			"default, so error pages are returned like any other " +
+			"response.",
+	},
+	{Name: "http_base_url", Category: "I/O",
+		Usage: "http_base_url [url]",
+		Doc: "This sets the URL which relative URLs in HTTP requests " +
+			"are resolved against. For example, after `http_base_url " +
+			"https://example.com/api/`, the URL \"users\" refers to " +
+			"\"https://example.com/api/users\". Without an argument, " +
+			"this removes the base URL.",
+	},
+	{Name: "http_cookies_off", Category: "I/O",
+		Usage: "http_cookies_off",
+		Doc: "This disables cookie saving for HTTP requests. This " +
+			"will delete all existing cookies, but it leaves a cookie file " +
+			"as it is.",
+	},
+	{Name: "http_cookies_on", Category: "I/O",
+		Usage: "http_cookies_on [file]",
+		Doc: "This enables cookie saving for HTTP requests. This will " +
+			"delete all existing cookies.\n\nIf a file is given, the " +
+			"cookies in it are loaded, and the cookies are saved to it " +
+			"after every request which changes them. This lets cookies " +
+			"last from one run of a script to the next.",
+	},
+	{Name: "http_header", Category: "I/O",
+		Usage: "http_header <name> [value]",
+		Doc: "This sets a header which is sent with every HTTP request " +
+			"unless the request sets the header itself. Without a value, " +
+			"this stops sending the header.",
+	},
+	{Name: "http_proxy", Category: "I/O",
+		Usage: "http_proxy [url]",
+		Doc: "This sends every HTTP request through a proxy, such as " +
+			"\"http://localhost:8080\". Without an argument, the proxy " +
+			"comes from the HTTP_PROXY and HTTPS_PROXY environment " +
+			"variables, which is the default.",
+	},
+	{Name: "http_redirects", Category: "I/O",
+		Usage: "http_redirects <max>",
+		Doc: "This sets the number of redirects which an HTTP request " +
+			"follows before it throws an exception. The default is 10. If " +
+			"the number is 0, redirects are not followed, and the redirect " +
+			"itself is the response.",
+	},
+	{Name: "http_timeout", Category: "I/O",
+		Usage: "http_timeout <seconds>",
+		Doc: "This makes HTTP requests throw an exception if they take " +
+			"longer than a number of seconds, including the time to read " +
+			"the response. A timeout of 0, the default, means there is no " +
+			"limit.",
+	},
+	{Name: "http_tls_ca", Category: "I/O",
+		Usage: "http_tls_ca <file>",
+		Doc: "This makes HTTPS requests trust the certificate " +
+			"authorities in a PEM file instead of the system's.",
+	},
+	{Name: "http_tls_cert", Category: "I/O",
+		Usage: "http_tls_cert <cert> <key>",
+		Doc: "This sets the client certificate for HTTPS requests from " +
+			"a PEM certificate and a PEM private key.",
+	},
+	{Name: "http_tls_verify", Category: "I/O",
+		Usage: "http_tls_verify <on|off>",
+		Doc: "This turns the verification of server certificates on " +
+			"or off. It is on by default. Turning it off makes HTTPS " +
+			"requests insecure, so it should only be used for testing.",
+	},
+	{Name: "http_route", Category: "I/O",
+		Usage: "http_route <method> <path> <code>",
+		Doc: "This makes the HTTP server run some code for requests " +
+			"with a method and a path. The method may be \"*\" to match " +
+			"every method, and a path which ends with \"*\" matches every " +
+			"path with the same prefix. If several routes match a request, " +
+			"the first one is used.\n\nThe value which the code returns is " +
+			"the body of the response. If the code throws an exception, the " +
+			"response has the status 500. If it stops the script, such as " +
+			"with exit, the server stops as well. The code runs with a " +
+			"copy of the variables which the script had when http_serve " +
+			"was called, and any variables it sets are kept for the next " +
+			"request.",
+	},
+	{Name: "http_serve", Category: "I/O",
+		Usage: "http_serve <address>",
+		Doc: "This starts an HTTP server for the routes of http_route " +
+			"and returns the address which it listens on. An address like " +
+			"\"127.0.0.1:0\" picks a free port.\n\nRequests are handled " +
+			"one at a time, only while the script is waiting in http_wait " +
+			"or in an HTTP request of its own. This lets a script send " +
+			"requests to itself, such as to test a mock service. In a " +
+			"sandbox which limits hosts, the server may only listen on a " +
+			"loopback address.",
+	},
+	{Name: "http_wait", Category: "I/O",
+		Usage: "http_wait",
+		Doc: "This handles requests to the HTTP server until it is " +
+			"stopped by http_stop.",
+	},
+	{Name: "http_stop", Category: "I/O",
+		Usage: "http_stop",
+		Doc: "This stops the HTTP server. A route may stop the server " +
+			"which is running it, and its response is still sent.",
+	},
+	{Name: "http_request", Category: "I/O",
+		Usage: "http_request <part> [name]",
+		Doc: "This returns a part of the request which a route is " +
+			"handling: \"method\", \"path\", \"query\", \"header\", or " +
+			"\"body\". With a name, \"query\" returns a query parameter " +
+			"and \"header\" returns a header. Without one, they return " +
+			"the whole query string and an array of header lines.",
+	},
+	{Name: "http_reply_status", Category: "I/O",
+		Usage: "http_reply_status <code>",
+		Doc: "This sets the status code of the response to the request " +
+			"which a route is handling. The default is 200.",
+	},
+	{Name: "http_reply_header", Category: "I/O",
+		Usage: "http_reply_header <name> <value>",
+		Doc: "This adds a header to the response to the request which " +
+			"a route is handling.",
+	},
+	{Name: "read", Category: "I/O",
+		Usage: "read <resource>",
+		Doc: "This takes one argument which is either a file path or " +
+			"a URL. It returns a string representing the contents of the " +
+			"specified resource, or throws an exception if the resource " +
+			"cannot be read.\n\nA URL is read like http_get, so it uses " +
+			"the cookies, headers, and other settings of the http " +
+			"commands.",
+	},
+	{Name: "write", Category: "I/O",
+		Usage: "write <path> <data>",
+		Doc: "This writes a string to a file. It throws an exception " +
+			"if the data cannot be written.",
+	},
+	{Name: "cmd", Category: "I/O",
+		Usage: "cmd <name> [arguments...]",
+		Doc: "This executes a command on the system. On UNIX-based " +
+			"systems, this is similar to running a command in a shell. It " +
+			"returns the combined output (stdout+stderr) of the command. " +
+			"This throws an exception if the command cannot be executed or " +
+			"if it fails in some platform-specific way.",
+	},
+	// Language essentials
+	{Name: "call", Category: "Language essentials",
+		Usage: "call <name> [arrays...]",
+		Doc: "This takes a command name and zero or more arrays to " +
+			"use as arguments. It executes the command with the specified " +
+			"arguments.",
+		Examples: []CommandExample{
+			{Code: "call + 1\\n2\\n3", Result: "6"},
+			{Code: "call echo (arr a b c)", Result: "a b c"},
+			{Code: "call call echo (arr a\\nb\\nc d\\ne\\nf)",
+				Result: "a b c d e f"},
+		},
+	},
+	{Name: "eval", Category: "Language essentials",
+		Usage: "eval <code>",
+		Doc: "This executes a block of pragmash code. The code which " +
+			"is executed will have complete access to the main script's " +
+			"variables. It will be able to throw exceptions. It will be " +
+			"able to print to the console. In essence, the code runs as if " +
+			"it were part of the main script. The only difference is that " +
+			"the code may use the \"return\" keyword to return values.",
+		Examples: []CommandExample{
+			{Code: "eval \"return test\"", Result: "test"},
+			{Code: "eval \"print test\"", Output: "test"},
+		},
+	},
+	{Name: "exec", Category: "Language essentials",
+		Usage: "exec <file>",
+		Doc: "This executes a pragmash file. `exec <file>` is almost " +
+			"exactly equivalent to `eval (read <file>)`. The only " +
+			"difference is that exceptions generated from the exec'd " +
+			"script include the script's filename.",
+	},
+	{Name: "exit", Category: "Language essentials",
+		Usage: "exit [code]",
+		Doc: "This exits the program. If the exit code is specified, " +
+			"it will be used as the numerical return value of the pragmash " +
+			"executable. If the exit code is not a valid number, an exit " +
+			"code of 1 is used.",
+	},
+	{Name: "get", Category: "Language essentials",
+		Usage: "get <variable>",
+		Doc: "This returns the contents of a variable. It throws an " +
+			"exception if the variable is not defined.",
+	},
+	{Name: "help", Category: "Language essentials",
+		Usage: "help [command]",
+		Pure:  true,
+		Doc: "This returns the documentation of a command, including " +
+			"its usage and examples. Without an argument, it lists the " +
+			"commands in each category. It throws an exception if the " +
+			"command does not exist.",
+	},
+	{Name: "pragmash", Category: "Language essentials",
+		Usage: "pragmash <path> [arguments...]",
+		Doc: "This executes a pragmash script in a new context and " +
+			"returns its return value. The script runs with a new set of " +
+			"variables (including the built-in ones), but it may still " +
+			"print to the console or exit the parent script. The optional " +
+			"arguments after the script path determine the child script's " +
+			"ARGV variable. The child script's DIR and SCRIPT variables " +
+			"will be based on the path of the child script.\n\nFor " +
+			"example, suppose this is the contents of a file " +
+			"\"main.pragmash\":\n\n    pragmash foo.pragmash arg1 arg2\n   " +
+			" puts unreachable\n\nand this is the contents of the file " +
+			"\"foo.pragmash\":\n\n    puts ([] $ARGV 1)\n    exit " +
+			"1\n\nThis would print \"arg2\" to the console and exit with " +
+			"status code 1. The string \"unreachable\" would not be " +
+			"printed to the screen.",
+	},
+	{Name: "set", Category: "Language essentials",
+		Usage: "set <variable> <value>",
+		Doc:   "This assigns a value to a given variable.",
+	},
+	{Name: "swap", Category: "Language essentials",
+		Usage: "swap <variable1> <variable2>",
+		Doc:   "This swaps the value of two variables.",
+	},
+	{Name: "throw", Category: "Language essentials",
+		Usage: "throw [string...]",
+		Doc: "This throws an exception. It joins its arguments with " +
+			"spaces and uses the result as the error message.",
+	},
+	{Name: "trace", Category: "Language essentials",
+		Usage: "trace <on|off>",
+		Doc: "This turns tracing on or off. While tracing is on, every " +
+			"command is printed to standard error as it runs. It throws " +
+			"an exception unless the script was started with tracing " +
+			"available, such as with the -x flag of the pragmash command.",
+	},
+	// Strings
+	{Name: "chars", Category: "Strings",
+		Usage: "chars <string>",
+		Pure:  true,
+		Doc: "This generates a newline-delimited list of strings " +
+			"which correspond to each character of the argument. Newline " +
+			"characters are encoded as the two-character \"\\\\\\\\n\" " +
+			"escape sequence. For example, `chars 12\\n3` yields " +
+			"`\"1\\n2\\n\\\\n\\n3\"`.",
+	},
+	{Name: "chr", Category: "Strings",
+		Usage: "chr <list>",
+		Pure:  true,
+		Doc: "This takes a list of Unicode code points and turns it " +
+			"into a string. It is the inverse of ord.",
+		Examples: []CommandExample{
+			{Code: "chr (arr 104 105 9731)", Result: "hi☃"},
+		},
+	},
+	{Name: "echo", Category: "Strings",
+		Usage: "echo [string...]",
+		Pure:  true,
+		Doc: "This joins its arguments with spaces and returns the " +
+			"result.",
+		Examples: []CommandExample{
+			{Code: "echo", Result: ""},
+			{Code: "echo arg", Result: "arg"},
+			{Code: "echo arg ument", Result: "arg ument"},
+			{Code: "echo a  b    \"c  d\"", Result: "a b c  d"},
+		},
+	},
+	{Name: "escape", Category: "Strings",
+		Usage: "escape <string>",
+		Pure:  true,
+		Doc: "This replaces backslashes with double backslashes and " +
+			"newlines with \"\\\\\\\\n\". This makes it easier to " +
+			"represent array elements which contain newlines.",
+	},
+	{Name: "format", Category: "Strings",
+		Usage: "format <template> [values...]",
+		Doc: "This formats values according to a template, like printf " +
+			"in C. Each verb starts with \"%\", and it may have the flags " +
+			"\"-\" to align left, \"0\" to pad with zeroes, \"+\" to " +
+			"show the sign, then a width and a precision such as " +
+			"\"8.2\".\n\nThe verbs are \"s\" for strings, \"q\" for " +
+			"quoted strings, \"d\" for integers, \"x\", \"X\", " +
+			"\"o\", and \"b\" for integers in hexadecimal, octal, and " +
+			"binary, and \"f\", \"e\", and \"g\" for numbers. " +
+			"\"%%\" is a percent sign. Big integers are formatted exactly. " +
+			"It throws an exception if the number of values does not match " +
+			"the template.",
+		Examples: []CommandExample{
+			{Code: `format "%-5s|%5.2f" ab 3.14159`, Result: "ab   | 3.14"},
+			{Code: `format "%x %o %b %04d" 255 8 5 42`,
+				Result: "ff 10 101 0042"},
+			{Code: `format "%d%%" 100000000000000000000`,
+				Result: "100000000000000000000%"},
+			{Code: `format "%.1f" 100000000000000000001`,
+				Result: "100000000000000000001.0"},
+			{Code: `format "%d" 1.5`, Throws: true},
+		},
+	},
+	{Name: "fields", Category: "Strings",
+		Usage: "fields <string>",
+		Pure:  true,
+		Doc: "This splits a string around each run of whitespace, " +
+			"including newlines, and returns the words as an array.",
+		Examples: []CommandExample{
+			{Code: "fields \"  a b\\n\\tc \"", Result: "a\nb\nc"},
+		},
+	},
+	{Name: "fmtnum", Category: "Strings",
+		Usage: "fmtnum <number> [decimals] [separator]",
+		Doc: "This formats a number with a separator between each group " +
+			"of three digits, which is \",\" by default. If a number of " +
+			"decimal places is given, the number is rounded to it, with " +
+			"halves rounded away from zero, or padded with zeroes.",
+		Examples: []CommandExample{
+			{Code: "fmtnum 1234567", Result: "1,234,567"},
+			{Code: "fmtnum -1234.5678 2", Result: "-1,234.57"},
+			{Code: "fmtnum 1234.5 0", Result: "1,235"},
+			{Code: "fmtnum 2.675 2", Result: "2.68"},
+			{Code: "fmtnum 1000 2 \" \"", Result: "1 000.00"},
+		},
+	},
+	{Name: "has_prefix", Category: "Strings",
+		Usage: "has_prefix <string> <prefix>",
+		Pure:  true,
+		Doc: "This returns \"true\" if the first argument starts with " +
+			"the second argument. Otherwise, it returns \"\".",
+	},
+	{Name: "has_suffix", Category: "Strings",
+		Usage: "has_suffix <string> <prefix>",
+		Pure:  true,
+		Doc: "This returns \"true\" if the first argument ends with " +
+			"the second argument. Otherwise, it returns \"\".",
+	},
+	{Name: "is_digit", Category: "Strings",
+		Usage: "is_digit <string>",
+		Pure:  true,
+		Doc: "This returns \"true\" if the provided argument is a " +
+			"single-character string which represents a digit. Otherwise, " +
+			"it returns \"\".",
+	},
+	{Name: "is_letter", Category: "Strings",
+		Usage: "is_letter <string>",
+		Pure:  true,
+		Doc: "This returns \"true\" if the provided argument is a " +
+			"single-character string which represents a letter. Otherwise, " +
+			"it returns \"\".",
+	},
+	{Name: "join", Category: "Strings",
+		Usage: "join [string...]",
+		Pure:  true,
+		Doc: "This joins its arguments without inserting spaces " +
+			"between them.",
+	},
+	{Name: "len", Category: "Strings",
+		Usage: "len <string>",
+		Pure:  true,
+		Doc: "This returns the number of characters in a string. Like " +
+			"the other string commands, it counts Unicode characters " +
+			"rather than bytes.",
+		Examples: []CommandExample{
+			{Code: "len héllo", Result: "5"},
+		},
+	},
+	{Name: "lowercase", Category: "Strings",
+		Usage: "lowercase [string...]",
+		Pure:  true,
+		Doc: "This joins its arguments with spaces and converts the " +
+			"result to lower-case.",
+	},
+	{Name: "ltrim", Category: "Strings",
+		Usage: "ltrim <string> [cutset]",
+		Pure:  true,
+		Doc: "This removes every character in a cutset from the start " +
+			"of a string. Without a cutset, it removes whitespace.",
+		Examples: []CommandExample{
+			{Code: "ltrim \"  hi \"", Result: "hi "},
+			{Code: "ltrim 007 0", Result: "7"},
+		},
+	},
+	{Name: "match", Category: "Strings",
+		Usage: "match <regexp> <haystack>",
+		Doc: "This matches a string against a regular expression. It " +
+			"returns an array of matches. Each sub-match is its own " +
+			"element in the array.\n\nFor example, `match \"x([a-z])z\" " +
+			"\"abc xyz xwz xoz\"` yields the array equivalent to `arr xyz " +
+			"y xwz w xoz o`. The commands below return the matches in " +
+			"forms which do not depend on the number of groups.",
+	},
+	{Name: "match_all", Category: "Strings",
+		Usage: "match_all <regexp> <string> [flags]",
+		Pure:  true,
+		Doc: "This returns an array with the text of every match of a " +
+			"regular expression.\n\nLike the other regular expression " +
+			"commands, it takes an optional string of flags: \"i\" " +
+			"ignores case, \"m\" makes ^ and $ match at the start and end " +
+			"of each line, \"s\" lets . match a newline, and \"U\" makes " +
+			"repetitions match as little as possible. Regular expressions " +
+			"are compiled once and cached, so they are fast to use in " +
+			"loops.",
+		Examples: []CommandExample{
+			{Code: "match_all \"[a-z]+\" \"ab 12 cd\"", Result: "ab\ncd"},
+			{Code: "match_all \"[a-z]+\" \"AB cd\" i", Result: "AB\ncd"},
+		},
+	},
+	{Name: "match_first", Category: "Strings",
+		Usage: "match_first <regexp> <string> [flags]",
+		Pure:  true,
+		Doc: "This returns an array with the text of the first match " +
+			"of a regular expression followed by the text of each of its " +
+			"groups. It returns an empty array if there is no match.",
+		Examples: []CommandExample{
+			{Code: "match_first \"(\\\\w+)@(\\\\w+)\" \"a@b c@d\"",
+				Result: "a@b\na\nb"},
+			{Code: "count (match_first x abc)", Result: "0"},
+		},
+	},
+	{Name: "match_group", Category: "Strings",
+		Usage: "match_group <regexp> <string> <group> [flags]",
+		Pure:  true,
+		Doc: "This returns an array with the text of a group in every " +
+			"match of a regular expression. The group may be the name of " +
+			"a named group, such as `(?P<year>\\d+)`, or a number, " +
+			"where 0 is the whole match.",
+		Examples: []CommandExample{
+			{Code: "match_group \"(?P<key>\\\\w+)=(\\\\w+)\" " +
+				"\"a=1 b=2\" key", Result: "a\nb"},
+			{Code: "match_group \"(\\\\w+)=(\\\\w+)\" \"a=1 b=2\" 2",
+				Result: "1\n2"},
+		},
+	},
+	{Name: "match_json", Category: "Strings",
+		Usage: "match_json <regexp> <string> [flags]",
+		Pure:  true,
+		Doc: "This returns a JSON array with an object for every match " +
+			"of a regular expression, which the JSON commands can read. " +
+			"Each object maps the number of every group, and the name of " +
+			"every named group, to its text. A group which is not part of " +
+			"the match is null.",
+		Examples: []CommandExample{
+			{Code: "json_get (match_json \"(?P<key>\\\\w+)=(\\\\w+)\" " +
+				"\"a=1 b=2\") 1.key", Result: "b"},
+		},
+	},
+	{Name: "matches", Category: "Strings",
+		Usage: "matches <regexp> <string> [flags]",
+		Pure:  true,
+		Doc: "This returns \"true\" if a regular expression matches " +
+			"part of a string. Otherwise, it returns \"\". Use ^ and $ to " +
+			"match the whole string.",
+		Examples: []CommandExample{
+			{Code: "matches \"^[0-9]+$\" 123", Result: "true"},
+			{Code: "matches abc ABC", Result: ""},
+			{Code: "matches abc ABC i", Result: "true"},
+			{Code: "matches abc ABC x", Throws: true},
+		},
+	},
+	{Name: "ord", Category: "Strings",
+		Usage: "ord <string>",
+		Pure:  true,
+		Doc: "This returns a list of the Unicode code points in a " +
+			"string.",
+		Examples: []CommandExample{
+			{Code: "ord hi☃", Result: "104\n105\n9731"},
+		},
+	},
+	{Name: "pad_zero", Category: "Strings",
+		Usage: "pad_zero <length> <string>",
+		Doc:   "This pads a value with zeroes on the left.",
+		Examples: []CommandExample{
+			{Code: "pad_zero 3 14", Result: "014"},
+			{Code: "pad_zero 2 14", Result: "14"},
+			{Code: "pad_zero 1 14", Result: "14"},
+		},
+	},
+	{Name: "rep", Category: "Strings",
+		Usage: "rep <haystack> <needle> <replacement>",
+		Doc: "This performs a global find-and-replace operation. It " +
+			"replaces all occurences of a \"needle\" inside a \"haystack\" " +
+			"with a \"replacement\" string.\n\nFor example, `rep abcdcba a " +
+			"A` yields \"AbcdcbA\".",
+	},
+	{Name: "repeat", Category: "Strings",
+		Usage: "repeat <string> <count>",
+		Doc: "This returns a string repeated a number of times. It fails " +
+			"if the result would be longer than 1 GiB.",
+		Examples: []CommandExample{
+			{Code: "repeat ab 3", Result: "ababab"},
+			{Code: "repeat ab -1", Throws: true},
+			{Code: "repeat ab 4611686018427387904", Throws: true},
+		},
+	},
+	{Name: "repreg", Category: "Strings",
+		Usage: "repreg <haystack> <regexp> <replacement>",
+		Doc: "This performs a global find-and-replace operation with " +
+			"regular expressions. Inside the replacement string, `$1` can " +
+			"be used to refer to the first submatch, `$2` to the second, " +
+			"etc.",
+		Examples: []CommandExample{
+			{Code: "repreg \"Alex Nichol\" [A-Z] _", Result: "_lex _ichol"},
+			{Code: "repreg \"Alex Nichol\" \"([A-Z])([a-z])\" \"$2$1\"",
+				Result: "lAex iNchol"},
+			{Code: "repreg \"10.50 20 30\" \"([0-9\\\\.]*)\" \"$$$1\"",
+				Result: "$10.50 $20 $30"},
+		},
+	},
+	{Name: "reverse", Category: "Strings",
+		Usage: "reverse <string>",
+		Pure:  true,
+		Doc:   "This reverses the characters of a string.",
+		Examples: []CommandExample{
+			{Code: "reverse héllo", Result: "olléh"},
+		},
+	},
+	{Name: "rtrim", Category: "Strings",
+		Usage: "rtrim <string> [cutset]",
+		Pure:  true,
+		Doc: "This removes every character in a cutset from the end of " +
+			"a string. Without a cutset, it removes whitespace.",
+		Examples: []CommandExample{
+			{Code: "rtrim \"  hi \"", Result: "  hi"},
+			{Code: "rtrim 1.500 0", Result: "1.5"},
+		},
+	},
+	{Name: "split", Category: "Strings",
+		Usage: "split <string> <separator>",
+		Pure:  true,
+		Doc: "This splits a string around each occurrence of a " +
+			"separator and returns the parts as an array. If the " +
+			"separator is \"\", the string is split into its characters.",
+		Examples: []CommandExample{
+			{Code: "split a,b,,c ,", Result: "a\nb\n\nc"},
+			{Code: "split \"a, b\" \", \"", Result: "a\nb"},
+			{Code: "count (split \"\" ,)", Result: "0"},
+		},
+	},
+	{Name: "split_regex", Category: "Strings",
+		Usage: "split_regex <regexp> <string> [flags]",
+		Pure:  true,
+		Doc: "This splits a string around each match of a regular " +
+			"expression and returns the parts as an array.",
+		Examples: []CommandExample{
+			{Code: "split_regex \" *, *\" \"a, b ,c\"", Result: "a\nb\nc"},
+		},
+	},
+	{Name: "str_count", Category: "Strings",
+		Usage: "str_count <string> <substring>",
+		Pure:  true,
+		Doc: "This returns the number of times that a substring occurs " +
+			"in a string, without counting overlapping occurrences. " +
+			"Unlike count, it works on strings rather than arrays.",
+		Examples: []CommandExample{
+			{Code: "str_count banana an", Result: "2"},
+			{Code: "str_count aaaa aa", Result: "2"},
+		},
+	},
+	{Name: "str_index", Category: "Strings",
+		Usage: "str_index <string> <substring>",
+		Pure:  true,
+		Doc: "This returns the index of the first character of the " +
+			"first occurrence of a substring, or -1 if it does not occur. " +
+			"Unlike index, it works on strings rather than arrays.",
+		Examples: []CommandExample{
+			{Code: "str_index héllo llo", Result: "2"},
+			{Code: "str_index hello z", Result: "-1"},
+		},
+	},
+	{Name: "str_lastindex", Category: "Strings",
+		Usage: "str_lastindex <string> <substring>",
+		Pure:  true,
+		Doc: "This returns the index of the first character of the " +
+			"last occurrence of a substring, or -1 if it does not occur.",
+		Examples: []CommandExample{
+			{Code: "str_lastindex banana an", Result: "3"},
+		},
+	},
+	{Name: "substr", Category: "Strings",
+		Usage: "substr <string> <start> [end]",
+		Pure:  true,
+		Doc: "This returns the characters of a string from a starting " +
+			"index up to, but not including, an ending index. Without an " +
+			"ending index, it returns the rest of the string. Indices " +
+			"outside of the string are moved to its start or end.",
+		Examples: []CommandExample{
+			{Code: "substr yoyo 1 3", Result: "oy"},
+			{Code: "substr héllo 1 3", Result: "él"},
+			{Code: "substr abc 1", Result: "bc"},
+		},
+	},
+	{Name: "title", Category: "Strings",
+		Usage: "title [string...]",
+		Pure:  true,
+		Doc: "This joins its arguments with spaces and converts the " +
+			"first letter of each word to upper-case.",
+		Examples: []CommandExample{
+			{Code: "title \"don't stop\" me-now", Result: "Don't Stop Me-Now"},
+		},
+	},
+	{Name: "trim", Category: "Strings",
+		Usage: "trim <string> [cutset]",
+		Pure:  true,
+		Doc: "This removes every character in a cutset from both ends " +
+			"of a string. Without a cutset, it removes whitespace.",
+		Examples: []CommandExample{
+			{Code: "trim \"  hi \"", Result: "hi"},
+			{Code: "trim --hi-- -", Result: "hi"},
+		},
+	},
+	{Name: "unescape", Category: "Strings",
+		Usage: "unescape <string>",
+		Pure:  true,
+		Doc:   "This inverts the effect of the escape command.",
+	},
+	{Name: "uppercase", Category: "Strings",
+		Usage: "uppercase [string...]",
+		Pure:  true,
+		Doc: "This joins its arguments with spaces and converts the " +
+			"result to upper-case.",
+	},
+	// Arrays
+	{Name: "arr", Category: "Arrays",
+		Usage: "arr [arrays...]",
+		Pure:  true,
+		Doc: "This joins its arguments with newlines and throws away " +
+			"empty arguments.",
+		Examples: []CommandExample{
+			{Code: "arr a b c", Result: "a\nb\nc"},
+			{Code: "arr \"\" a \"\"", Result: "a"},
+		},
+	},
+	{Name: "change", Category: "Arrays",
+		Usage: "change <array> <index> <element>",
+		Pure:  true,
+		Doc:   "This changes an element at a given index.",
+		Examples: []CommandExample{
+			{Code: "change (arr a b c) 1 B", Result: "a\nB\nc"},
+			{Code: "change (arr a b c) 3 D", Throws: true},
+		},
+	},
+	{Name: "contains", Category: "Arrays",
+		Usage: "contains <array> <element>",
+		Pure:  true,
+		Doc: "This takes an array and a string and returns \"true\" " +
+			"if the array contains the string. Otherwise, it returns \"\".",
+	},
+	{Name: "count", Category: "Arrays",
+		Usage: "count <array>",
+		Pure:  true,
+		Doc: "This takes a newline-delimited list and returns the " +
+			"number of elements it contains. If the argument is \"\", this " +
+			"returns 0.",
+	},
+	{Name: "delete", Category: "Arrays",
+		Usage: "delete <array> <index>",
+		Pure:  true,
+		Doc:   "This deletes an element from an array.",
+		Examples: []CommandExample{
+			{Code: "delete (arr a b c) 1", Result: "a\nc"},
+			{Code: "delete (arr a b c) 0", Result: "b\nc"},
+			{Code: "delete \"\" 0", Throws: true},
+		},
+	},
+	{Name: "index", Category: "Arrays",
+		Usage: "index <array> <string>",
+		Pure:  true,
+		Doc: "This returns the index of a string in an array, or -1 " +
+			"if the string was not present in the array.",
+		Examples: []CommandExample{
+			{Code: "index (arr a b c) b", Result: "1"},
+			{Code: "index (arr a b c) d", Result: "-1"},
+		},
+	},
+	{Name: "insert", Category: "Arrays",
+		Usage: "insert <array> <index> <element>",
+		Pure:  true,
+		Doc:   "This inserts an element into an array.",
+		Examples: []CommandExample{
+			{Code: "insert (arr a b c) 1 A", Result: "a\nA\nb\nc"},
+			{Code: "insert (arr a b c) 3 d", Result: "a\nb\nc\nd"},
+			{Code: "insert (arr a b c) 4 d", Throws: true},
+		},
+	},
+	{Name: "range", Category: "Arrays",
+		Usage: "range [start] <end> [count]",
+		Doc: "This generates a newline-delimited list of " +
+			"integers.\n\nIf the command is given one argument `N`, it " +
+			"will generate the ordered list of integers `i` such that `0 " +
+			"<= i < N`.\n\nIf the command is given two arguments `M` and " +
+			"`N`, it will generate the ordered list of integers `i` such " +
+			"that `M <= i < N`\n\nIf the command is given three arguments, " +
+			"it generates the ordered list of integers starting with the " +
+			"first argument going to the second argument, stepping by the " +
+			"third argument each time. For example, `range 10 5 -2` yields " +
+			"`10\\n8\\n6`.",
+	},
+	{Name: "shuffle", Category: "Arrays",
+		Usage: "shuffle <array>",
+		Doc: "This takes an array and returns an array with the same " +
+			"elements in a random order.",
+	},
+	{Name: "sort", Category: "Arrays",
+		Usage: "sort <array>",
+		Pure:  true,
+		Doc: "This takes an array and returns an alphabetically " +
+			"sorted version.",
+	},
+	{Name: "sortnums", Category: "Arrays",
+		Usage: "sortnums <array>",
+		Pure:  true,
+		Doc: "This takes an array of numbers and returns the sorted " +
+			"array.",
+	},
+	{Name: "subarr", Category: "Arrays",
+		Usage: "subarr <array> <start> [end]",
+		Pure:  true,
+		Doc: "This takes an array and two indices. It returns a " +
+			"portion of the original array.",
+		Examples: []CommandExample{
+			{Code: "subarr (arr a b c) 1 3", Result: "b\nc"},
+			{Code: "subarr (arr a b c) 0 2", Result: "a\nb"},
+		},
+	},
+	{Name: "sum", Category: "Arrays",
+		Usage: "sum [arrays...]",
+		Pure:  true,
+		Doc: "This takes zero or more arrays of numbers and returns " +
+			"the sum of all the numbers.",
+	},
+	// Filesystem
+	// JSON
+	{Name: "json_get", Category: "JSON",
+		Usage: "json_get <doc> [path]",
+		Pure:  true,
+		Doc: "This returns the value at a path in a JSON document. " +
+			"Strings are returned without quotes, and numbers, true, " +
+			"false, null, objects, and arrays are returned as JSON. It " +
+			"throws an exception if the path does not exist.",
+		Examples: []CommandExample{
+			{Code: `json_get "{\"users\": [{\"name\": \"bob\"}]}" ` +
+				`users.0.name`, Result: "bob"},
+			{Code: `json_get "{\"a\": [1, 2]}" a`, Result: "[1,2]"},
+			{Code: `json_get "{\"a\": 1}" b`, Throws: true},
+			{Code: `json_get "{\"a\": false, \"b\": null}" a`,
+				Result: "false"},
+			{Code: `json_get "{\"a\": false, \"b\": null}" b`,
+				Result: "null"},
+		},
+	},
+	{Name: "json_set", Category: "JSON",
+		Usage: "json_set <doc> <path> <json>",
+		Pure:  true,
+		Doc: "This returns a copy of a JSON document with the value at " +
+			"a path replaced by another JSON value. Objects which are " +
+			"missing along the path are created, and the index just past " +
+			"the end of an array appends to it. Use json_encode to turn a " +
+			"string into a JSON value.",
+		Examples: []CommandExample{
+			{Code: `json_set "{\"a\": 1}" b.c true`,
+				Result: `{"a":1,"b":{"c":true}}`},
+			{Code: `json_set [1] 1 (json_encode string two)`,
+				Result: `[1,"two"]`},
+		},
+	},
+	{Name: "json_keys", Category: "JSON",
+		Usage: "json_keys <doc> [path]",
+		Pure:  true,
+		Doc: "This returns an array of the keys of a JSON object in " +
+			"sorted order.",
+		Examples: []CommandExample{
+			{Code: `json_keys "{\"b\": 1, \"a\": 2}"`, Result: "a\nb"},
+		},
+	},
+	{Name: "json_array", Category: "JSON",
+		Usage: "json_array <doc> [path]",
+		Pure:  true,
+		Doc: "This converts a JSON array into an array. Each element " +
+			"is formatted like the result of json_get.",
+		Examples: []CommandExample{
+			{Code: `json_array "[\"a\", 2, {}]"`, Result: "a\n2\n{}"},
+		},
+	},
+	{Name: "json_encode", Category: "JSON",
+		Usage: "json_encode <kind> [values...]",
+		Pure:  true,
+		Doc: "This creates a JSON value. The kind is \"string\", " +
+			"\"number\", or \"bool\" with one value, \"null\" with no " +
+			"values, \"array\" with elements, or \"object\" with " +
+			"alternating keys and values. The elements of an array or an " +
+			"object may also be given as arrays, so an array of keys and " +
+			"values becomes an object. Elements become strings, even if " +
+			"they look like numbers or bools. The kinds \"json_array\" " +
+			"and \"json_object\" instead take elements which are JSON, " +
+			"such as the results of json_encode, and insert them as they " +
+			"are. A bool is false if its value is empty or \"false\", " +
+			"and true otherwise.",
+		Examples: []CommandExample{
+			{Code: `json_encode string "say \"hi\""`,
+				Result: `"say \"hi\""`},
+			{Code: "json_encode array (arr a b)", Result: `["a","b"]`},
+			{Code: "json_encode array 007 12345 true",
+				Result: `["007","12345","true"]`},
+			{Code: "json_encode object (arr name bob) age 3",
+				Result: `{"age":"3","name":"bob"}`},
+			{Code: "json_encode json_object " +
+				"(arr a 1 b (json_encode array x))",
+				Result: `{"a":1,"b":["x"]}`},
+			{Code: "json_encode json_array 1 (json_encode string 1)",
+				Result: `[1,"1"]`},
+			{Code: "json_encode json_array x", Throws: true},
+			{Code: "json_encode bool false", Result: "false"},
+			{Code: "json_encode number 3.50", Result: "3.5"},
+		},
+	},
+	{Name: "json_pretty", Category: "JSON",
+		Usage: "json_pretty <doc>",
+		Pure:  true,
+		Doc: "This formats a JSON document on several lines with an " +
+			"indent of two spaces.",
+		Examples: []CommandExample{
+			{Code: "json_pretty [1]", Result: "[\n  1\n]"},
+		},
+	},
+	// CSV
+	{Name: "csv_rows", Category: "CSV",
+		Usage: "csv_rows <doc>",
+		Pure:  true,
+		Doc: "This returns an array of the rows of a CSV document, " +
+			"including the header. Each row is encoded as CSV on its own. " +
+			"Since an element of an array cannot contain a newline, this " +
+			"throws an exception if a field has a newline in it, and so " +
+			"do csv_fields, csv_header, and csv_column. Use csv_get to " +
+			"read such a field.",
+		Examples: []CommandExample{
+			{Code: "csv_rows \"name,age\\nbob,3\"", Result: "name,age\nbob,3"},
+			{Code: "csv_rows \"name\\n\\\"two\\nlines\\\"\"", Throws: true},
+		},
+	},
+	{Name: "csv_fields", Category: "CSV",
+		Usage: "csv_fields <row>",
+		Pure:  true,
+		Doc: "This returns an array of the fields in one row of CSV, " +
+			"removing any quotes.",
+		Examples: []CommandExample{
+			{Code: `csv_fields "a,\"b,c\",d"`, Result: "a\nb,c\nd"},
+		},
+	},
+	{Name: "csv_header", Category: "CSV",
+		Usage: "csv_header <doc>",
+		Pure:  true,
+		Doc: "This returns an array of the fields in the first row of " +
+			"a CSV document.",
+		Examples: []CommandExample{
+			{Code: "csv_header \"name,age\\nbob,3\"", Result: "name\nage"},
+		},
+	},
+	{Name: "csv_column", Category: "CSV",
+		Usage: "csv_column <doc> <name>",
+		Pure:  true,
+		Doc: "This returns an array of the fields under a header, " +
+			"leaving out the header itself. Rows which are too short have " +
+			"an empty field.",
+		Examples: []CommandExample{
+			{Code: "csv_column \"name,age\\nbob,3\\nann,4\" age",
+				Result: "3\n4"},
+			{Code: "csv_column \"name,age\\nbob,3\" size", Throws: true},
+		},
+	},
+	{Name: "csv_get", Category: "CSV",
+		Usage: "csv_get <doc> <row> <name>",
+		Pure:  true,
+		Doc: "This returns the field under a header in a row. The " +
+			"first row after the header is row 0.",
+		Examples: []CommandExample{
+			{Code: "csv_get \"name,age\\nbob,3\\nann,4\" 1 name",
+				Result: "ann"},
+		},
+	},
+	{Name: "csv_encode", Category: "CSV",
+		Usage: "csv_encode [fields...]",
+		Pure:  true,
+		Doc: "This returns a row of CSV with the given fields, quoting " +
+			"them where necessary. The fields may be given as arrays, " +
+			"but an empty argument is still an empty field. The rows of a " +
+			"document can be combined with arr.",
+		Examples: []CommandExample{
+			{Code: `csv_encode a "b,c" "say \"hi\""`,
+				Result: `a,"b,c","say ""hi"""`},
+			{Code: "csv_encode (arr x y) z", Result: "x,y,z"},
+			{Code: `csv_encode a "" b`, Result: "a,,b"},
+		},
+	},
+	{Name: "tsv_to_csv", Category: "CSV",
+		Usage: "tsv_to_csv <doc>",
+		Pure:  true,
+		Doc: "This converts a document with fields separated by tabs " +
+			"into CSV, so that it can be read with the other commands.",
+		Examples: []CommandExample{
+			{Code: "tsv_to_csv (join a \"\t\" \"b,c\")", Result: `a,"b,c"`},
+		},
+	},
+	{Name: "csv_to_tsv", Category: "CSV",
+		Usage: "csv_to_tsv <doc>",
+		Pure:  true,
+		Doc: "This converts a CSV document into one with fields " +
+			"separated by tabs.",
+		Examples: []CommandExample{
+			{Code: "csv_to_tsv \"a,\\\"b,c\\\"\"", Result: "a\tb,c"},
+		},
+	},
+	{Name: "exists", Category: "Filesystem",
+		Usage: "exists <path>",
+		Doc: "This returns \"true\" if a file exists or \"\" if it " +
+			"does not. It may throw an exception if the file is " +
+			"inaccessible.",
+	},
+	{Name: "filetype", Category: "Filesystem",
+		Usage: "filetype <path>",
+		Doc: "This returns the type of a named file. This can be " +
+			"\"file\", \"dir\", \"link\", or \"other\". This will throw an " +
+			"exception if the file's type cannot be determined or if it " +
+			"does not exist.",
+	},
+	{Name: "glob", Category: "Filesystem",
+		Usage: "glob [globs...]",
+		Doc: "This takes any number of arguments and \"globs\" files " +
+			"by those names. \n\nFor example, if my current directory " +
+			"includes the files \"foo\" \"bar\" and \"foobar\", `glob " +
+			"foo*`, it would return the array equivalent to `arr foo " +
+			"foobar`.",
+	},
+	{Name: "mkdir", Category: "Filesystem",
+		Usage: "mkdir <dir>",
+		Doc: "This creates a directory at a given path. This will not " +
+			"create intermediate directories. This may return an exception " +
+			"if the directory cannot be created.",
+	},
+	{Name: "path", Category: "Filesystem",
+		Usage: "path [comps...]",
+		Pure:  true,
+		Doc: "This takes any number of string arguments and joins " +
+			"them as path components.",
+	},
+	{Name: "rm", Category: "Filesystem",
+		Usage: "rm <path>",
+		Doc: "This deletes a file or an empty directory. This will " +
+			"throw an exception if the file or directory cannot be deleted.",
+	},
+	{Name: "rmall", Category: "Filesystem",
+		Usage: "rmall <path>",
+		Doc: "This deletes a file or directory recursively. This will " +
+			"throw an exception if the file or directory cannot be deleted.",
+	},
+	{Name: "touch", Category: "Filesystem",
+		Usage: "touch [path...]",
+		Doc: "This creates one or more files or updates their " +
+			"timestamps to the current time. This will throw an exception " +
+			"if the file's timestamp cannot be changed or if the file " +
+			"cannot be created.",
+	},
+	// Math
+	{Name: "abs", Category: "Math",
+		Usage: "abs <number>",
+		Pure:  true,
+		Doc:   "This takes the absolute value of its numerical argument.",
+		Examples: []CommandExample{
+			{Code: "abs -2", Result: "2"},
+			{Code: "abs 2", Result: "2"},
+		},
+	},
+	{Name: "acos", Category: "Math",
+		Usage: "acos <number>",
+		Doc: "This returns the inverse cosine of a value. This will " +
+			"throw an exception if the number is less than -1 or greater " +
+			"than 1.",
+	},
+	{Name: "asin", Category: "Math",
+		Usage: "asin <number>",
+		Doc: "This returns the inverse sine of a value. This will " +
+			"throw an exception if the number is less than -1 or greater " +
+			"than 1.",
+	},
+	{Name: "atan", Category: "Math",
+		Usage: "atan <number>",
+		Doc:   "This returns the inverse tangent of a value.",
+	},
+	{Name: "atan2", Category: "Math",
+		Usage: "atan2 <y> <x>",
+		Doc: "This returns the inverse tangent using an x and y " +
+			"coordinate.",
+	},
+	{Name: "ceil", Category: "Math",
+		Usage: "ceil <number>",
+		Pure:  true,
+		Doc: "This returns the greatest integer which is less than or " +
+			"equal to a given floating-point number.",
+	},
+	{Name: "cos", Category: "Math",
+		Usage: "cos <angle>",
+		Pure:  true,
+		Doc:   "This computes the cosine of an angle in radians.",
+	},
+	{Name: "exp", Category: "Math",
+		Usage: "exp [exponent]",
+		Doc: "This takes an argument x and computes e^x where e is " +
+			"Euler's constant. If no arguments are given, this returns the " +
+			"value of Euler's constant.",
+	},
+	{Name: "factorial", Category: "Math",
+		Usage: "factorial <number>",
+		Doc: "This takes a number and returns its factorial. If the " +
+			"number is not a positive integer, this uses the [Gamma " +
+			"function](http://en.wikipedia.org/wiki/Gamma_function) to " +
+			"compute a fractional answer.",
+	},
+	{Name: "floor", Category: "Math",
+		Usage: "floor <number>",
+		Pure:  true,
+		Doc: "This returns the lowest integer which is greater than " +
+			"or equal to a given floating-point number.",
+	},
+	{Name: "log", Category: "Math",
+		Usage: "log [base] <number>",
+		Doc: "This computes a logarithm. If you supply one argument, " +
+			"this computes log base 10 of its argument. If there are two " +
+			"arguments, the first argument is treated as the base. If " +
+			"either argument is invalid, this will throw an exception.",
+	},
+	{Name: "pi", Category: "Math",
+		Usage: "pi",
+		Pure:  true,
+		Doc:   "This takes no arguments and returns the value of pi.",
+	},
+	{Name: "rand", Category: "Math",
+		Usage: "rand",
+		Doc: "This returns a random floating-point number between 0.0 " +
+			"and 1.0.",
+	},
+	{Name: "round", Category: "Math",
+		Usage: "round <number>",
+		Pure:  true,
+		Doc: "This rounds a floating-point number to the nearest " +
+			"integer.",
+	},
+	{Name: "sin", Category: "Math",
+		Usage: "sin <angle>",
+		Pure:  true,
+		Doc:   "This computes the sine of an angle in radians.",
+	},
+	{Name: "sqrt", Category: "Math",
+		Usage: "sqrt <argument>",
+		Pure:  true,
+		Doc: "This takes the square root of a number. If the number " +
+			"is negative, this throws an exception.",
+	},
+	// Time
+	{Name: "sleep", Category: "Time",
+		Usage: "sleep <seconds>",
+		Doc: "This takes a numerical argument and sleeps for that " +
+			"many seconds. The argument may be a floating-point number.",
+	},
+	{Name: "time", Category: "Time",
+		Usage: "time",
+		Doc: "This returns the current UNIX epoch time as a " +
+			"floating-point in seconds.",
+	},
+	{Name: "time_day", Category: "Time",
+		Usage: "time_day <timestamp> [location]",
+		Doc: "This returns the day of the month for a given " +
+			"timestamp.\n\nIf no location is specified, this uses the " +
+			"local location. Otherwise, the location must be in the IANA " +
+			"Time Zone database.",
+	},
+	{Name: "time_hour", Category: "Time",
+		Usage: "time_hour <timestamp> [location]",
+		Doc: "This returns the hour of the day for a given " +
+			"timestamp.\n\nIf no location is specified, this uses the " +
+			"local location. Otherwise, the location must be in the IANA " +
+			"Time Zone database.",
+	},
+	{Name: "time_minute", Category: "Time",
+		Usage: "time_minute <timestamp> [location]",
+		Doc: "This returns the minute of the hour for a given " +
+			"timestamp.\n\nIf no location is specified, this uses the " +
+			"local location. Otherwise, the location must be in the IANA " +
+			"Time Zone database.",
+	},
+	{Name: "time_month", Category: "Time",
+		Usage: "time_month <timestamp> [location]",
+		Doc: "This returns the month of the year for a given " +
+			"timestamp.\n\nIf no location is specified, this uses the " +
+			"local location. Otherwise, the location must be in the IANA " +
+			"Time Zone database.",
+	},
+	{Name: "time_second", Category: "Time",
+		Usage: "time_second <timestamp> [location]",
+		Doc: "This returns the second of the minute for a given " +
+			"timestamp.\n\nIf no location is specified, this uses the " +
+			"local location. Otherwise, the location must be in the IANA " +
+			"Time Zone database.",
+	},
+	{Name: "time_year", Category: "Time",
+		Usage: "time_year <timestamp> [location]",
+		Doc: "This returns the year for a given timestamp.\n\nIf no " +
+			"location is specified, this uses the local location. " +
+			"Otherwise, the location must be in the IANA Time Zone " +
+			"database.",
+	},
+	// Testing
+	{Name: "assert", Category: "Testing",
+		Usage: "assert <condition> [message...]",
+		Doc: "This throws an exception if its first argument is empty. " +
+			"Any other arguments are joined with spaces and used as the " +
+			"error message.",
+		Examples: []CommandExample{
+			{Code: "assert true", Result: ""},
+			{Code: "assert \"\" \"x is empty\"", Throws: true},
+		},
+	},
+	{Name: "assert_eq", Category: "Testing",
+		Usage: "assert_eq <expected> <actual> [message...]",
+		Doc: "This throws an exception if its first two arguments are " +
+			"not equal as strings. Any other arguments are added to the " +
+			"start of the error message.",
+		Examples: []CommandExample{
+			{Code: "assert_eq 4 (+ 2 2)", Result: ""},
+			{Code: "assert_eq 5 (+ 2 2)", Throws: true},
+		},
+	},
+	{Name: "assert_throws", Category: "Testing",
+		Usage: "assert_throws <code> [substring]",
+		Doc: "This runs some code like `eval` and throws an exception " +
+			"unless the code throws one. If a substring is given, the " +
+			"code's exception must contain it. This returns the message of " +
+			"the code's exception.",
+		Examples: []CommandExample{
+			{Code: "assert_throws \"throw oops\"", Result: "oops"},
+			{Code: "assert_throws \"echo fine\"", Throws: true},
+		},
+	},
+}
@@ -0,0 +1,311 @@
+package pragmash
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//go:generate go test -run TestCommandReference -pragmash.updatecommands
+
+// A CommandDoc documents a command in the standard library.
+type CommandDoc struct {
+	// Name is the usual name of the command, such as "+" or "has_prefix".
+	Name string
+
+	// Aliases are the other names of the command, such as "add" for "+".
+	Aliases []string
+
+	Category string
+
+	// Usage shows how to call the command, such as
+	// "substr <string> <start> [end]". Optional arguments are in brackets.
+	Usage string
+
+	// Params are the types of the arguments, as in StdCommandUsage. If
+	// Variadic is true, the last one may be repeated any number of times.
+	Params   []string
+	Variadic bool
+
+	// Pure is true if the command has no side effects, always returns the
+	// same result for the same arguments, and returns a result which is not
+	// much larger than its arguments. Compile may evaluate calls to pure
+	// commands ahead of time.
+	Pure bool
+
+	// Doc describes the command in Markdown.
+	Doc      string
+	Examples []CommandExample
+}
+
+// A CommandExample is a line of code which uses a command, along with the
+// result, the printed output, or the exception that it should produce.
+type CommandExample struct {
+	Code   string
+	Result string
+	Output string
+	Throws bool
+}
+
+type commandCategory struct {
+	Name   string
+	Anchor string
+	Intro  string
+}
+
+var (
+	commandDocsOnce   sync.Once
+	commandDocsByName map[string]*CommandDoc
+)
+
+// StdCommands returns the sorted names of the commands in the standard
+// library, including operators such as "+" and the "get" and "set" commands.
+func StdCommands() []string {
+	res := []string{"get", "set"}
+	t := reflect.TypeOf(&StdAll{})
+	for i := 0; i < t.NumMethod(); i++ {
+		res = append(res, commandName(t.Method(i).Name))
+	}
+	for name := range OperatorRewrites {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// StdCommandDocs returns the documentation of every standard command in the
+// order of the command reference.
+func StdCommandDocs() []*CommandDoc {
+	loadCommandDocs()
+	res := make([]*CommandDoc, len(commandDocs))
+	for i, doc := range commandDocs {
+		copied := *doc
+		res[i] = &copied
+	}
+	return res
+}
+
+// StdCommandDoc returns the documentation of a standard command, which may be
+// named by any of its aliases. The second return value is false if the
+// command does not exist or is not documented.
+func StdCommandDoc(name string) (*CommandDoc, bool) {
+	loadCommandDocs()
+	doc, ok := commandDocsByName[name]
+	if !ok {
+		if method, found := stdCommandMethod(name); found {
+			doc, ok = commandDocsByName[method.Name]
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	copied := *doc
+	return &copied, true
+}
+
+// StdCommandUsage returns a summary of the arguments which a standard command
+// takes, such as "substr string int int" or "+ number...". The second return
+// value is false if there is no such command.
+func StdCommandUsage(name string) (string, bool) {
+	params, variadic, ok := commandParams(name)
+	if !ok {
+		return "", false
+	}
+	parts := append([]string{name}, params...)
+	if variadic {
+		parts[len(parts)-1] += "..."
+	}
+	return strings.Join(parts, " "), true
+}
+
+// Help returns the documentation of the command as plain text.
+func (c *CommandDoc) Help() string {
+	var buf bytes.Buffer
+	buf.WriteString(c.Usage + "\n")
+	if len(c.Aliases) > 0 {
+		buf.WriteString("Aliases: " + strings.Join(c.Aliases, ", ") + "\n")
+	}
+	buf.WriteString("\n" + c.Doc + "\n")
+	if len(c.Examples) > 0 {
+		buf.WriteString("\nExamples:\n")
+		for _, x := range c.Examples {
+			buf.WriteString("  " + x.Code + "  " + x.describe(false) + "\n")
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// WriteCommandReference writes the Markdown reference of every standard
+// command, which is kept in COMMANDS.md.
+func WriteCommandReference(w io.Writer) error {
+	loadCommandDocs()
+	var buf bytes.Buffer
+	buf.WriteString("# Overview\n\nA standard pragmash environment " +
+		"should have a basic set of commands. These commands offer " +
+		"everything from network I/O to string manipulation. This file " +
+		"lists these commands. It is generated from the documentation in " +
+		"command_docs.go, so edit that file instead and run " +
+		"`go generate`.\n\nThese commands are divided into several " +
+		"categories. Here are the categories:\n\n")
+	for _, c := range commandCategories {
+		buf.WriteString(" * [" + c.Name + "](#" + c.Anchor + ")\n")
+	}
+	for _, c := range commandCategories {
+		buf.WriteString("\n<a name=\"" + c.Anchor + "\"></a>\n# " + c.Name +
+			"\n")
+		if c.Intro != "" {
+			buf.WriteString("\n" + c.Intro + "\n")
+		}
+		for _, doc := range commandDocs {
+			if doc.Category == c.Name {
+				doc.writeMarkdown(&buf)
+			}
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (c *CommandDoc) writeMarkdown(buf *bytes.Buffer) {
+	escaper := strings.NewReplacer("[", "\\[", "]", "\\]", "<", "&lt;",
+		">", "&gt;", "&", "&amp;", "*", "\\*", "|", "\\|")
+	buf.WriteString("\n### " + escaper.Replace(c.Usage) + "\n\n" + c.Doc +
+		"\n")
+	if len(c.Aliases) > 0 {
+		buf.WriteString("\nAliases: `" + strings.Join(c.Aliases, "`, `") +
+			"`\n")
+	}
+	if len(c.Examples) > 0 {
+		buf.WriteString("\nExamples:\n\n")
+		for _, x := range c.Examples {
+			buf.WriteString(" * `" + x.Code + "` " + x.describe(true) + "\n")
+		}
+	}
+}
+
+// describe returns the expected outcome of the example, such as
+// `yields "3"`. The result is in code quotes for Markdown.
+func (c CommandExample) describe(markdown bool) string {
+	quote := func(s string) string {
+		s = "\"" + strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(s) +
+			"\""
+		if markdown {
+			return "`" + s + "`"
+		}
+		return s
+	}
+	if c.Throws {
+		return "throws an exception"
+	} else if c.Output != "" {
+		return "prints " + quote(c.Output)
+	}
+	return "yields " + quote(c.Result)
+}
+
+// commandList lists the standard commands in each category.
+func commandList() string {
+	loadCommandDocs()
+	var lines []string
+	for _, c := range commandCategories {
+		var names []string
+		for _, doc := range commandDocs {
+			if doc.Category == c.Name {
+				names = append(names, doc.Name)
+			}
+		}
+		lines = append(lines, c.Name+": "+strings.Join(names, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// loadCommandDocs fills in the parts of commandDocs which come from the
+// methods of StdAll.
+func loadCommandDocs() {
+	commandDocsOnce.Do(func() {
+		commandDocsByName = map[string]*CommandDoc{}
+		for _, doc := range commandDocs {
+			if alias, ok := OperatorRewrites[doc.Name]; ok {
+				doc.Aliases = []string{alias}
+			}
+			doc.Params, doc.Variadic, _ = commandParams(doc.Name)
+			commandDocsByName[doc.Name] = doc
+			if method, ok := stdCommandMethod(doc.Name); ok {
+				commandDocsByName[method.Name] = doc
+			}
+		}
+	})
+}
+
+// commandParams returns the types of the arguments of a standard command.
+func commandParams(name string) (params []string, variadic, ok bool) {
+	switch name {
+	case "get":
+		return []string{"string"}, false, true
+	case "set":
+		return []string{"string", "value"}, false, true
+	}
+	method, ok := stdCommandMethod(name)
+	if !ok {
+		return nil, false, false
+	}
+	t := method.Type
+	params = []string{}
+	for i := 1; i < t.NumIn(); i++ {
+		if t.In(i) == runnerType {
+			continue
+		}
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			params = append(params, typeUsage(t.In(i).Elem()))
+		} else {
+			params = append(params, typeUsage(t.In(i)))
+		}
+	}
+	return params, t.IsVariadic(), true
+}
+
+// stdCommandMethod looks up the method of StdAll which implements a command.
+// The first input of the method's type is the receiver.
+func stdCommandMethod(name string) (reflect.Method, bool) {
+	if name == "" {
+		return reflect.Method{}, false
+	}
+	return reflect.TypeOf(&StdAll{}).MethodByName(
+		RewriteCommandName(OperatorRewrites, name))
+}
+
+// commandName turns a method name like "HasPrefix" into the command name
+// "has_prefix".
+func commandName(method string) string {
+	var res []rune
+	for i, r := range method {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				res = append(res, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		res = append(res, r)
+	}
+	return string(res)
+}
+
+func typeUsage(t reflect.Type) string {
+	switch t {
+	case boolType:
+		return "bool"
+	case floatType, numType:
+		return "number"
+	case intType:
+		return "int"
+	case strType:
+		return "string"
+	case floatArrType, intArrType, numArrType, strArrType, valArrType:
+		return "array"
+	default:
+		return "value"
+	}
+}
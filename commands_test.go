@@ -0,0 +1,233 @@
+package pragmash
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var updateCommands = flag.Bool("pragmash.updatecommands", false,
+	"rewrite COMMANDS.md from the command docs")
+
+func TestStdCommandUsage(t *testing.T) {
+	cases := map[string]string{
+		"+":          "+ number...",
+		"has_prefix": "has_prefix string string",
+		"exec":       "exec string",
+		"set":        "set string value",
+		"sort":       "sort array",
+	}
+	for name, expected := range cases {
+		if usage, ok := StdCommandUsage(name); !ok || usage != expected {
+			t.Errorf("%s: expected %q but got %q", name, expected, usage)
+		}
+	}
+	if _, ok := StdCommandUsage("frobnicate"); ok {
+		t.Error("unexpected usage for an unknown command")
+	}
+
+	names := StdCommands()
+	found := map[string]bool{}
+	for _, name := range names {
+		found[name] = true
+		if _, ok := StdCommandUsage(name); !ok {
+			t.Errorf("no usage for %s", name)
+		}
+	}
+	for _, name := range []string{"+", "puts", "time_day", "assert_eq"} {
+		if !found[name] {
+			t.Errorf("missing command %s", name)
+		}
+	}
+}
+
+func TestCommandDocs(t *testing.T) {
+	documented := map[string]bool{}
+	for _, doc := range StdCommandDocs() {
+		if documented[doc.Name] {
+			t.Errorf("%s is documented twice", doc.Name)
+		}
+		documented[doc.Name] = true
+		if !strings.HasPrefix(doc.Usage, doc.Name) {
+			t.Errorf("%s: bad usage %q", doc.Name, doc.Usage)
+		}
+		if doc.Params == nil {
+			t.Errorf("%s: not a standard command", doc.Name)
+			continue
+		}
+
+		// Check the usage against the arguments of the method.
+		var required, optional int
+		var repeated bool
+		for _, arg := range strings.Fields(doc.Usage)[1:] {
+			if strings.HasPrefix(arg, "[") {
+				optional++
+			} else {
+				required++
+			}
+			repeated = repeated || strings.HasSuffix(arg, "...]") ||
+				strings.HasSuffix(arg, "...>")
+		}
+		if !doc.Variadic && (required != len(doc.Params) || optional > 0) {
+			t.Errorf("%s: usage %q does not take %d arguments", doc.Name,
+				doc.Usage, len(doc.Params))
+		} else if doc.Variadic && (required < len(doc.Params)-1 ||
+			(optional == 0 && !repeated)) {
+			t.Errorf("%s: usage %q is not variadic", doc.Name, doc.Usage)
+		}
+	}
+
+	typ := reflect.TypeOf(&StdAll{})
+	for i := 0; i < typ.NumMethod(); i++ {
+		method := typ.Method(i)
+		doc, ok := StdCommandDoc(commandName(method.Name))
+		if !ok {
+			t.Errorf("%s is not documented", commandName(method.Name))
+		} else if m, _ := stdCommandMethod(doc.Name); m.Name != method.Name {
+			t.Errorf("%s is documented as %s", method.Name, doc.Name)
+		}
+	}
+	if doc, ok := StdCommandDoc("add"); !ok || doc.Name != "+" {
+		t.Error("the add alias is not documented")
+	}
+}
+
+func TestCommandExamples(t *testing.T) {
+	for _, doc := range StdCommandDocs() {
+		for _, example := range doc.Examples {
+			runnable, err := parseCode(example.Code, "")
+			if err != nil {
+				t.Errorf("%s: %s", example.Code, err)
+				continue
+			}
+			runner := &exampleRunner{}
+			runner.inner = NewStdRunner(CreateStandardVariables("", nil))
+			runner.SetOuter(runner)
+			val, bo := runnable.Run(runner)
+			if example.Throws {
+				if bo == nil {
+					t.Errorf("%s: expected an exception", example.Code)
+				}
+				continue
+			} else if bo != nil {
+				t.Errorf("%s: %s", example.Code, bo.Error())
+				continue
+			}
+			if example.Output != "" {
+				if out := runner.output.String(); out != example.Output {
+					t.Errorf("%s: expected output %q but got %q",
+						example.Code, example.Output, out)
+				}
+			} else if val.String() != example.Result {
+				t.Errorf("%s: expected %q but got %q", example.Code,
+					example.Result, val.String())
+			}
+		}
+	}
+}
+
+func TestPureCommands(t *testing.T) {
+	runner := NewStdRunner(nil).(Resolver)
+	for _, doc := range StdCommandDocs() {
+		if !doc.Pure {
+			continue
+		}
+		method, _ := stdCommandMethod(doc.Name)
+
+		// A pure command can neither reach the runner nor need a permission.
+		for i := 1; i < method.Type.NumIn(); i++ {
+			if method.Type.In(i) == runnerType {
+				t.Errorf("%s is pure but takes a runner", doc.Name)
+			}
+		}
+		if _, ok := sandboxRules[method.Name]; ok {
+			t.Errorf("%s is pure but needs a permission", doc.Name)
+		}
+		for _, example := range doc.Examples {
+			if example.Output != "" {
+				t.Errorf("%s is pure but prints output", doc.Name)
+			}
+		}
+
+		// Calls in code which never runs are evaluated as well, so a result
+		// may not be much larger than the arguments.
+		args := make([]*Value, len(doc.Params))
+		size := 0
+		for i, param := range doc.Params {
+			switch param {
+			case "bool":
+				args[i] = NewValueBool(true)
+			case "int", "number":
+				args[i] = NewValueString("100000")
+			case "array":
+				args[i] = NewValueString(strings.Repeat("ab\n", 100))
+			default:
+				args[i] = NewValueString(strings.Repeat("ab ", 100))
+			}
+			size += len(args[i].String())
+		}
+		fn, pure := runner.ResolveCommand(doc.Name)
+		if !pure {
+			t.Errorf("%s is not resolved as pure", doc.Name)
+		} else if res, err := fn(args); err == nil &&
+			len(res.String()) > size*8+1024 {
+			t.Errorf("%s is pure but returned %d bytes", doc.Name,
+				len(res.String()))
+		}
+	}
+}
+
+func TestCommandReference(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCommandReference(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if *updateCommands {
+		if err := ioutil.WriteFile("COMMANDS.md", buf.Bytes(),
+			0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	contents, err := ioutil.ReadFile("COMMANDS.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, buf.Bytes()) {
+		t.Error("COMMANDS.md is out of date; run go generate")
+	}
+}
+
+func TestHelp(t *testing.T) {
+	runner := NewStdRunner(nil)
+	val, err := runner.RunCommand("help", []*Value{NewValueString("add")})
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(val.String(), "+ [number...]\n") {
+		t.Errorf("unexpected help: %q", val.String())
+	}
+	val, err = runner.RunCommand("help", []*Value{})
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(val.String(), "Testing: assert ") {
+		t.Errorf("unexpected command list: %q", val.String())
+	}
+	_, err = runner.RunCommand("help", []*Value{NewValueString("nope")})
+	if err == nil {
+		t.Error("expected an error for an unknown command")
+	}
+}
+
+// exampleRunner captures the output of a command example.
+type exampleRunner struct {
+	forwardingRunner
+	output bytes.Buffer
+}
+
+func (e *exampleRunner) Stdout() io.Writer {
+	return &e.output
+}
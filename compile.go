@@ -0,0 +1,264 @@
+package pragmash
+
+import (
+	"errors"
+)
+
+// A CommandFunc runs a command which has already been looked up by name.
+type CommandFunc func(args []*Value) (*Value, error)
+
+// A Resolver is a Runner which can look up commands and variables ahead of
+// time so that Compile can bind them directly.
+type Resolver interface {
+	Runner
+
+	// ResolveCommand returns a function for the named command, or nil if the
+	// command does not exist or cannot be resolved ahead of time. The second
+	// return value is true if the command has no side effects and always
+	// returns the same result for the same arguments.
+	ResolveCommand(name string) (CommandFunc, bool)
+
+	// Variable returns the slot which holds a named variable, or nil if
+	// variables cannot be resolved ahead of time.
+	Variable(name string) *Variable
+}
+
+// A Variable is a slot which holds the value of a named variable.
+// A nil Value means that the variable is undefined.
+type Variable struct {
+	Name  string
+	Value *Value
+}
+
+// Get returns the value of the variable or fails if it is undefined.
+func (v *Variable) Get() (*Value, error) {
+	if v.Value == nil {
+		return nil, errors.New("variable undefined: " + v.Name)
+	}
+	return v.Value, nil
+}
+
+// Compile resolves a Runnable (usually from ScanAll) against a Runner.
+//
+// Commands whose names are constant are bound directly to their functions,
+// calls to pure commands with constant arguments are evaluated ahead of time,
+// and "get" and "set" with constant variable names are bound to variable
+// slots. Commands with dynamic names are left as they are.
+//
+// The result should only be run with the Runner it was compiled against. If
+// the Runner is not a Resolver, the Runnable is returned unchanged. If the
+// Runner is an Observer, nothing is evaluated ahead of time so that every
+// command is still observed.
+func Compile(r Runnable, runner Runner) Runnable {
+	resolver, ok := runner.(Resolver)
+	if !ok {
+		return r
+	}
+	_, observed := runner.(Observer)
+	return (&compiler{resolver, !observed}).runnable(r)
+}
+
+// A BoundCommand is a command whose name was resolved by Compile.
+type BoundCommand struct {
+	Arguments []Runnable
+	Context   string
+	Func      CommandFunc
+	Name      string
+}
+
+// Run evaluates every argument, then calls the bound function.
+func (b BoundCommand) Run(r Runner) (*Value, *Breakout) {
+	observer, _ := r.(Observer)
+	if observer != nil {
+		observer.EnterCommand(b.Context)
+	}
+	args := make([]*Value, len(b.Arguments))
+	for i, x := range b.Arguments {
+		val, bo := x.Run(r)
+		if bo != nil {
+			if observer != nil {
+				observer.ExitCommand(b.Context, "", nil, nil, bo)
+			}
+			return nil, bo
+		}
+		args[i] = val
+	}
+	if observer != nil {
+		observer.CallCommand(b.Context, b.Name, args)
+	}
+	val, err := b.Func(args)
+	var bo *Breakout
+	if err != nil {
+		val, bo = nil, NewBreakoutException(b.Context, err)
+	}
+	if observer != nil {
+		observer.ExitCommand(b.Context, b.Name, args, val, bo)
+	}
+	return val, bo
+}
+
+// A VariableGet reads a variable slot which was resolved by Compile.
+type VariableGet struct {
+	Context  string
+	Variable *Variable
+}
+
+// Run returns the value of the variable or throws an exception if it is
+// undefined.
+func (v VariableGet) Run(r Runner) (*Value, *Breakout) {
+	observer, _ := r.(Observer)
+	var args []*Value
+	if observer != nil {
+		args = []*Value{NewValueString(v.Variable.Name)}
+		observer.EnterCommand(v.Context)
+		observer.CallCommand(v.Context, "get", args)
+	}
+	val, err := v.Variable.Get()
+	var bo *Breakout
+	if err != nil {
+		val, bo = nil, NewBreakoutException(v.Context, err)
+	}
+	if observer != nil {
+		observer.ExitCommand(v.Context, "get", args, val, bo)
+	}
+	return val, bo
+}
+
+// A VariableSet assigns to a variable slot which was resolved by Compile.
+type VariableSet struct {
+	Context  string
+	Value    Runnable
+	Variable *Variable
+}
+
+// Run evaluates the value and stores it in the variable.
+func (v VariableSet) Run(r Runner) (*Value, *Breakout) {
+	observer, _ := r.(Observer)
+	if observer != nil {
+		observer.EnterCommand(v.Context)
+	}
+	val, bo := v.Value.Run(r)
+	if bo != nil {
+		if observer != nil {
+			observer.ExitCommand(v.Context, "", nil, nil, bo)
+		}
+		return nil, bo
+	}
+	if observer != nil {
+		args := []*Value{NewValueString(v.Variable.Name), val}
+		observer.CallCommand(v.Context, "set", args)
+		v.Variable.Value = val
+		observer.ExitCommand(v.Context, "set", args, emptyValue, nil)
+		return emptyValue, nil
+	}
+	v.Variable.Value = val
+	return emptyValue, nil
+}
+
+// A compiler holds the options for a call to Compile.
+type compiler struct {
+	resolver Resolver
+	fold     bool
+}
+
+func (c *compiler) command(x CommandRunnable) Runnable {
+	args := c.runnables(x.Arguments)
+	nameVal, ok := x.Name.(*Value)
+	if !ok {
+		return CommandRunnable{x.Context, c.runnable(x.Name), args}
+	}
+	name := nameVal.String()
+
+	// Bind variable accesses with constant names to their slots.
+	if name == "get" && len(args) == 1 {
+		if v, ok := args[0].(*Value); ok {
+			if slot := c.resolver.Variable(v.String()); slot != nil {
+				return VariableGet{x.Context, slot}
+			}
+		}
+	} else if name == "set" && len(args) == 2 {
+		if v, ok := args[0].(*Value); ok {
+			if slot := c.resolver.Variable(v.String()); slot != nil {
+				return VariableSet{x.Context, args[1], slot}
+			}
+		}
+	}
+
+	fn, pure := c.resolver.ResolveCommand(name)
+	if fn == nil {
+		// Unknown commands still need to fail at runtime.
+		return CommandRunnable{x.Context, nameVal, args}
+	}
+
+	// Evaluate pure commands ahead of time if their arguments are constant.
+	// Errors are left for the runtime so they are reported with context.
+	if pure && c.fold {
+		if vals, ok := constantValues(args); ok {
+			if val, err := fn(vals); err == nil {
+				return val
+			}
+		}
+	}
+
+	return BoundCommand{args, x.Context, fn, name}
+}
+
+func (c *compiler) runnable(x Runnable) Runnable {
+	switch x := x.(type) {
+	case CommandRunnable:
+		return c.command(x)
+	case Condition:
+		return Condition(c.runnables(x))
+	case For:
+		var index, variable Runnable
+		if x.Index != nil {
+			index = c.runnable(x.Index)
+		}
+		if x.Variable != nil {
+			variable = c.runnable(x.Variable)
+		}
+		return For{c.runnable(x.Body), x.Context,
+			c.runnable(x.Expression), index, variable}
+	case If:
+		return If{c.runnables(x.Branches), c.runnables(x.Conditions)}
+	case NotCondition:
+		return NotCondition(c.runnables(x))
+	case ReturnRunner:
+		return ReturnRunner{c.runnables(x.Arguments), x.Context}
+	case RunnableList:
+		return RunnableList(c.runnables(x))
+	case TestBlock:
+		return TestBlock{c.runnable(x.Body), x.Context, c.runnable(x.Name)}
+	case Try:
+		var variable Runnable
+		if x.Variable != nil {
+			variable = c.runnable(x.Variable)
+		}
+		return Try{c.runnable(x.Catch), x.CatchContext,
+			c.runnable(x.Try), variable}
+	case While:
+		return While{c.runnable(x.Body), c.runnable(x.Condition), x.Context}
+	default:
+		return x
+	}
+}
+
+func (c *compiler) runnables(list []Runnable) []Runnable {
+	res := make([]Runnable, len(list))
+	for i, x := range list {
+		res[i] = c.runnable(x)
+	}
+	return res
+}
+
+func constantValues(list []Runnable) ([]*Value, bool) {
+	res := make([]*Value, len(list))
+	for i, x := range list {
+		v, ok := x.(*Value)
+		if !ok {
+			return nil, false
+		}
+		res[i] = v
+	}
+	return res, true
+}
@@ -0,0 +1,453 @@
+package pragmash
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// These are the kinds of branch which Coverage records. They are used as the
+// block numbers in lcov reports.
+const (
+	coverBlockIf = iota
+	coverBlockLoop
+	coverBlockTry
+)
+
+// Coverage records which statements and branches of instrumented scripts
+// have run.
+//
+// A statement is a line of a block, such as a command or the first line of an
+// if statement or loop. The branches are the blocks of an if statement (with a
+// final branch for when no block runs, unless there is an else block), whether
+// a while or for loop ran its body (then whether it did not), and whether a
+// try block caught an exception (then whether it did not).
+type Coverage struct {
+	files map[string]*fileCoverage
+}
+
+type fileCoverage struct {
+	lines    map[int]*int64
+	branches map[coverBranchKey]*coverBranch
+}
+
+type coverBranchKey struct {
+	line  int
+	block int
+}
+
+// A coverBranch counts how many times a branch point ran and how many times
+// each of its arms was taken.
+type coverBranch struct {
+	runs int64
+	arms []int64
+
+	// implicit is true if the last arm is taken when none of the others
+	// are, such as an if statement without an else block.
+	implicit bool
+}
+
+// NewCoverage creates an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{map[string]*fileCoverage{}}
+}
+
+// Instrument returns a copy of a Runnable (usually from ScanAll or Compile)
+// which records its coverage when it runs. The file is the path of the script,
+// which is used for statements whose contexts do not name a file.
+//
+// Instrumented Runnables cannot be compiled to bytecode.
+func (c *Coverage) Instrument(r Runnable, file string) Runnable {
+	return c.instrument(r, filepath.Clean(file))
+}
+
+// Percent returns the percentage of statements which have run.
+func (c *Coverage) Percent() float64 {
+	var hit, total int
+	for _, f := range c.files {
+		for _, count := range f.lines {
+			total++
+			if *count > 0 {
+				hit++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(hit) / float64(total)
+}
+
+// WriteLcov writes the coverage in the lcov tracefile format.
+func (c *Coverage) WriteLcov(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, name := range c.fileNames() {
+		f := c.files[name]
+		fmt.Fprintln(bw, "TN:")
+		fmt.Fprintln(bw, "SF:"+name)
+
+		var branchesFound, branchesHit int
+		for _, key := range f.branchKeys() {
+			branch := f.branches[key]
+			for i, count := range branch.counts() {
+				taken := "-"
+				if branch.runs > 0 {
+					taken = strconv.FormatInt(count, 10)
+				}
+				fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n", key.line, key.block, i,
+					taken)
+				branchesFound++
+				if count > 0 {
+					branchesHit++
+				}
+			}
+		}
+
+		var linesHit int
+		lines := f.lineNumbers()
+		for _, line := range lines {
+			count := *f.lines[line]
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, count)
+			if count > 0 {
+				linesHit++
+			}
+		}
+
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", branchesFound, branchesHit)
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\n", len(lines), linesHit)
+		fmt.Fprintln(bw, "end_of_record")
+	}
+	return bw.Flush()
+}
+
+// WriteHTML writes a web page which shows the source of every instrumented
+// file, with statements which ran in green and the others in red.
+// Branches which were never taken are marked next to their lines.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "<!doctype html>\n<html>\n<head>\n"+
+		"<meta charset=\"utf-8\">\n<title>pragmash coverage</title>\n"+
+		"<style>\nbody { font-family: sans-serif; }\n"+
+		"pre { font-family: monospace; }\n"+
+		".hit { background: #cfc; }\n.miss { background: #fcc; }\n"+
+		".partial { background: #ffc; }\n"+
+		".count { color: #888; display: inline-block; width: 6em; }\n"+
+		"</style>\n</head>\n<body>")
+	fmt.Fprintf(bw, "<h1>Coverage: %.1f%% of statements</h1>\n", c.Percent())
+	for _, name := range c.fileNames() {
+		f := c.files[name]
+		fmt.Fprintf(bw, "<h2>%s</h2>\n<pre>\n", html.EscapeString(name))
+		contents, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintf(bw, "could not read file: %s\n</pre>\n",
+				html.EscapeString(err.Error()))
+			continue
+		}
+		partial := f.partialLines()
+		lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+		for i, line := range lines {
+			class, count := "", ""
+			if hits, ok := f.lines[i+1]; ok {
+				class = "miss"
+				if *hits > 0 {
+					class = "hit"
+					if partial[i+1] {
+						class = "partial"
+					}
+				}
+				count = strconv.FormatInt(*hits, 10)
+			}
+			fmt.Fprintf(bw, "<span class=\"%s\"><span class=\"count\">%s"+
+				"</span>%4d  %s</span>\n", class, count, i+1,
+				html.EscapeString(line))
+		}
+		fmt.Fprintln(bw, "</pre>")
+	}
+	fmt.Fprintln(bw, "</body>\n</html>")
+	return bw.Flush()
+}
+
+func (c *Coverage) file(name string) *fileCoverage {
+	if f, ok := c.files[name]; ok {
+		return f
+	}
+	f := &fileCoverage{map[int]*int64{}, map[coverBranchKey]*coverBranch{}}
+	c.files[name] = f
+	return f
+}
+
+func (c *Coverage) fileNames() []string {
+	res := make([]string, 0, len(c.files))
+	for name := range c.files {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// branch returns the branch point for a context, creating it if necessary.
+// The same branch point is returned each time a script is instrumented, so a
+// script which is run with exec more than once is only reported once.
+func (c *Coverage) branch(file, context string, block,
+	arms int) *coverBranch {
+	name, line, ok := c.location(file, context)
+	if !ok {
+		return &coverBranch{arms: make([]int64, arms)}
+	}
+	f := c.file(name)
+	key := coverBranchKey{line, block}
+	if b, ok := f.branches[key]; ok && len(b.arms) == arms {
+		return b
+	}
+	b := &coverBranch{arms: make([]int64, arms)}
+	f.branches[key] = b
+	return b
+}
+
+func (c *Coverage) instrument(x Runnable, file string) Runnable {
+	switch x := x.(type) {
+	case RunnableList:
+		res := make(RunnableList, len(x))
+		for i, statement := range x {
+			res[i] = c.statement(statement, file)
+		}
+		return res
+	case If:
+		context := runnableContext(x)
+		arms := len(x.Branches)
+		implicit := true
+		if len(x.Conditions) > 0 {
+			// An else block has a constant condition.
+			_, isElse := x.Conditions[len(x.Conditions)-1].(*Value)
+			implicit = !isElse
+		}
+		if implicit {
+			arms++
+		}
+		branch := c.branch(file, context, coverBlockIf, arms)
+		branch.implicit = implicit
+		branches := make([]Runnable, len(x.Branches))
+		for i, body := range x.Branches {
+			branches[i] = coverCounter{c.instrument(body, file),
+				&branch.arms[i]}
+		}
+		return coverBranchPoint{If{branches, x.Conditions}, branch, nil}
+	case For:
+		branch := c.branch(file, x.Context, coverBlockLoop, 2)
+		var body int64
+		x.Body = coverCounter{c.instrument(x.Body, file), &body}
+		return coverBranchPoint{x, branch, &body}
+	case TestBlock:
+		x.Body = c.instrument(x.Body, file)
+		return x
+	case Try:
+		branch := c.branch(file, x.CatchContext, coverBlockTry, 2)
+		var caught int64
+		x.Try = c.instrument(x.Try, file)
+		x.Catch = coverCounter{c.instrument(x.Catch, file), &caught}
+		return coverBranchPoint{x, branch, &caught}
+	case While:
+		branch := c.branch(file, x.Context, coverBlockLoop, 2)
+		var body int64
+		x.Body = coverCounter{c.instrument(x.Body, file), &body}
+		return coverBranchPoint{x, branch, &body}
+	default:
+		return x
+	}
+}
+
+// location finds the file and line of a context.
+func (c *Coverage) location(file, context string) (string, int, bool) {
+	name, line, ok := parseContext(context)
+	if !ok {
+		return "", 0, false
+	}
+	if name == "" {
+		name = file
+	}
+	return filepath.Clean(name), line, true
+}
+
+func (c *Coverage) statement(x Runnable, file string) Runnable {
+	instrumented := c.instrument(x, file)
+	name, line, ok := c.location(file, runnableContext(x))
+	if !ok {
+		return instrumented
+	}
+	f := c.file(name)
+	count, ok := f.lines[line]
+	if !ok {
+		count = new(int64)
+		f.lines[line] = count
+	}
+	return coverCounter{instrumented, count}
+}
+
+// counts returns the number of times each arm was taken.
+func (b *coverBranch) counts() []int64 {
+	res := append([]int64{}, b.arms...)
+	if b.implicit {
+		var taken int64
+		for _, x := range res[:len(res)-1] {
+			taken += x
+		}
+		res[len(res)-1] = b.runs - taken
+	}
+	return res
+}
+
+func (f *fileCoverage) branchKeys() []coverBranchKey {
+	res := make([]coverBranchKey, 0, len(f.branches))
+	for key := range f.branches {
+		res = append(res, key)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].line != res[j].line {
+			return res[i].line < res[j].line
+		}
+		return res[i].block < res[j].block
+	})
+	return res
+}
+
+func (f *fileCoverage) lineNumbers() []int {
+	res := make([]int, 0, len(f.lines))
+	for line := range f.lines {
+		res = append(res, line)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// partialLines returns the lines with branches that were never taken.
+func (f *fileCoverage) partialLines() map[int]bool {
+	res := map[int]bool{}
+	for key, branch := range f.branches {
+		for _, count := range branch.counts() {
+			if count == 0 {
+				res[key.line] = true
+			}
+		}
+	}
+	return res
+}
+
+// A coverCounter counts the runs of a Runnable.
+type coverCounter struct {
+	Runnable Runnable
+	count    *int64
+}
+
+// Run increments the counter and runs the Runnable.
+func (c coverCounter) Run(r Runner) (*Value, *Breakout) {
+	*c.count++
+	return c.Runnable.Run(r)
+}
+
+// A coverBranchPoint counts the runs of a branching Runnable. If body is not
+// nil, it is the counter of the arm which is taken when the body runs, and
+// the other arm is taken when it does not.
+type coverBranchPoint struct {
+	Runnable Runnable
+	branch   *coverBranch
+	body     *int64
+}
+
+// Run runs the Runnable and records which arm it took.
+func (c coverBranchPoint) Run(r Runner) (*Value, *Breakout) {
+	c.branch.runs++
+	if c.body == nil {
+		return c.Runnable.Run(r)
+	}
+	before := *c.body
+	val, bo := c.Runnable.Run(r)
+	if *c.body != before {
+		c.branch.arms[0]++
+	} else {
+		c.branch.arms[1]++
+	}
+	return val, bo
+}
+
+// A CoverageRunner is a Runner which records the coverage of the scripts
+// started with exec and pragmash, in addition to any script which was
+// instrumented before it was run.
+type CoverageRunner struct {
+	forwardingRunner
+	coverage *Coverage
+}
+
+// NewCoverageRunner wraps a Runner with a CoverageRunner.
+func NewCoverageRunner(r Runner, c *Coverage) *CoverageRunner {
+	res := &CoverageRunner{forwardingRunner{wrappedRunner{r}}, c}
+	res.SetOuter(res)
+	return res
+}
+
+// inherit records the coverage of a separate script started with the
+// pragmash command.
+func (c *CoverageRunner) inherit(r Runner) Runner {
+	return NewCoverageRunner(r, c.coverage)
+}
+
+// instrumentScript instruments a script which is about to be run by exec or
+// pragmash if there is a CoverageRunner in a chain of WrapperRunners.
+func instrumentScript(r Runner, x Runnable, path string) Runnable {
+	for r != nil {
+		if c, ok := r.(*CoverageRunner); ok {
+			return c.coverage.Instrument(x, path)
+		}
+		w, ok := r.(WrapperRunner)
+		if !ok {
+			break
+		}
+		r = w.Unwrap()
+	}
+	return x
+}
+
+// runnableContext finds the context of the first line of a Runnable, or
+// returns an empty string if it has none.
+func runnableContext(x Runnable) string {
+	switch x := x.(type) {
+	case BoundCommand:
+		return x.Context
+	case BreakRunner:
+		return x.Context
+	case CommandRunnable:
+		return x.Context
+	case Condition:
+		for _, y := range x {
+			if context := runnableContext(y); context != "" {
+				return context
+			}
+		}
+	case ContinueRunner:
+		return x.Context
+	case For:
+		return x.Context
+	case If:
+		if len(x.Conditions) > 0 {
+			return runnableContext(x.Conditions[0])
+		}
+	case NotCondition:
+		return runnableContext(Condition(x))
+	case ReturnRunner:
+		return x.Context
+	case TestBlock:
+		return x.Context
+	case VariableGet:
+		return x.Context
+	case VariableSet:
+		return x.Context
+	case While:
+		return x.Context
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package pragmash
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	script := "set x 2\nif (= $x 1) {\nset y 1\n} else if (= $x 2) {\n" +
+		"set y 2\n}\nfor i (range 0) {\nset z 1\n}\ntry {\nthrow bad\n" +
+		"} catch e {\nset z 2\n}\n"
+	lines, contexts, err := TokenizeString(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runnable, err := ScanAll(lines, contexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coverage := NewCoverage()
+	runnable = coverage.Instrument(runnable, "test.pragmash")
+	if _, bo := runnable.Run(NewStdRunner(nil)); bo != nil {
+		t.Fatal("unexpected breakout:", bo.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := coverage.WriteLcov(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "TN:\nSF:test.pragmash\n" +
+		"BRDA:2,0,0,0\nBRDA:2,0,1,1\nBRDA:2,0,2,0\n" +
+		"BRDA:7,1,0,0\nBRDA:7,1,1,1\n" +
+		"BRDA:12,2,0,1\nBRDA:12,2,1,0\n" +
+		"DA:1,1\nDA:2,1\nDA:3,0\nDA:5,1\nDA:7,1\nDA:8,0\nDA:11,1\n" +
+		"DA:13,1\nBRF:7\nBRH:3\nLF:8\nLH:6\nend_of_record\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected lcov output: %q", buf.String())
+	}
+	if percent := coverage.Percent(); percent != 75 {
+		t.Errorf("unexpected percentage: %f", percent)
+	}
+}
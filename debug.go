@@ -0,0 +1,408 @@
+package pragmash
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const debuggerHelp = `Commands:
+  s, step            run until the next command, including nested commands
+  n, next            run until the next command at this depth or above
+  o, out             run until the current command finishes
+  c, continue        run until a breakpoint
+  b, break [SPEC]    add a breakpoint at LINE or FILE:LINE, or list them
+  d, delete SPEC     remove a breakpoint
+  v, vars            show every variable
+  p, print CODE      evaluate pragmash code, e.g. "p get x" or "p set x 3"
+  w, where           show the commands being evaluated
+  l, list            show the source around the current line
+  q, quit            abort the script
+  h, help            show this message
+An empty line repeats the previous command.`
+
+type debugMode int
+
+const (
+	debugStep debugMode = iota
+	debugNext
+	debugOut
+	debugContinue
+)
+
+// A debugBreakpoint is a line in a script. An empty file refers to the main
+// script.
+type debugBreakpoint struct {
+	file string
+	line int
+}
+
+// A Debugger is a Runner which pauses a script before its commands run and
+// reads debugging commands from an interactive prompt.
+//
+// A Debugger must wrap every other Runner so that it can observe commands.
+// Events are passed on to the wrapped Runner if it is an Observer as well.
+// It starts out paused before the first command.
+type Debugger struct {
+	wrappedRunner
+
+	in     *bufio.Reader
+	out    io.Writer
+	script string
+
+	breakpoints []debugBreakpoint
+	sources     map[string][]string
+
+	// stack holds the contexts of the commands which are being evaluated.
+	stack []string
+
+	mode      debugMode
+	stopDepth int
+	last      string
+
+	evaluating bool
+	quit       bool
+}
+
+// NewDebugger wraps a Runner with a Debugger which reads commands from in and
+// writes to out. The script is the path of the main script, which is used to
+// show source lines.
+func NewDebugger(r Runner, script string, in io.Reader,
+	out io.Writer) *Debugger {
+	res := &Debugger{wrappedRunner: wrappedRunner{r}, in: bufio.NewReader(in),
+		out: out, script: script, sources: map[string][]string{}}
+	res.SetOuter(res)
+	return res
+}
+
+// AddBreakpoint adds a breakpoint of the form "LINE" for the main script or
+// "FILE:LINE" for a script run with exec.
+func (d *Debugger) AddBreakpoint(spec string) error {
+	bp, err := parseBreakpoint(spec)
+	if err != nil {
+		return err
+	}
+	d.breakpoints = append(d.breakpoints, bp)
+	return nil
+}
+
+// Continue makes the Debugger run until the next breakpoint instead of pausing
+// before the first command.
+func (d *Debugger) Continue() {
+	d.mode = debugContinue
+}
+
+// RunCommand runs a command with the wrapped Runner unless the user has quit.
+func (d *Debugger) RunCommand(name string, args []*Value) (*Value, error) {
+	if d.quit {
+		return nil, &AbortError{errors.New("debugger: quit")}
+	}
+	return d.inner.RunCommand(name, args)
+}
+
+// EnterCommand pauses if the debugger is stepping or if the command starts a
+// line with a breakpoint.
+func (d *Debugger) EnterCommand(context string) {
+	if o, ok := d.inner.(Observer); ok {
+		o.EnterCommand(context)
+	}
+	depth := len(d.stack)
+	newLine := depth == 0 || d.stack[depth-1] != context
+	d.stack = append(d.stack, context)
+	if d.evaluating || d.quit {
+		return
+	}
+	var stop bool
+	switch d.mode {
+	case debugStep:
+		stop = true
+	case debugNext:
+		stop = depth <= d.stopDepth
+	case debugOut:
+		stop = depth < d.stopDepth
+	}
+	if stop || (newLine && d.isBreakpoint(context)) {
+		d.pause(context, depth)
+	}
+}
+
+// CallCommand passes the event on to the wrapped Runner.
+func (d *Debugger) CallCommand(context, name string, args []*Value) {
+	if o, ok := d.inner.(Observer); ok {
+		o.CallCommand(context, name, args)
+	}
+}
+
+// ExitCommand keeps track of the commands which are being evaluated.
+func (d *Debugger) ExitCommand(context, name string, args []*Value,
+	res *Value, bo *Breakout) {
+	if o, ok := d.inner.(Observer); ok {
+		o.ExitCommand(context, name, args, res, bo)
+	}
+	if len(d.stack) > 0 {
+		d.stack = d.stack[:len(d.stack)-1]
+	}
+}
+
+func (d *Debugger) pause(context string, depth int) {
+	d.printLocation(context, depth)
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		line, err := d.in.ReadString('\n')
+		if err != nil && line == "" {
+			// Once there is no more input, the script runs to completion.
+			fmt.Fprintln(d.out)
+			d.mode = debugContinue
+			d.breakpoints = nil
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+		cmd, arg := line, ""
+		if idx := strings.IndexAny(line, " \t"); idx >= 0 {
+			cmd, arg = line[:idx], strings.TrimSpace(line[idx+1:])
+		}
+		switch cmd {
+		case "":
+		case "s", "step":
+			d.mode = debugStep
+			return
+		case "n", "next":
+			d.mode, d.stopDepth = debugNext, depth
+			return
+		case "o", "out":
+			d.mode, d.stopDepth = debugOut, depth
+			return
+		case "c", "continue":
+			d.mode = debugContinue
+			return
+		case "q", "quit":
+			d.quit = true
+			return
+		case "b", "break":
+			d.breakCommand(arg)
+		case "d", "delete":
+			d.deleteCommand(arg)
+		case "v", "vars":
+			d.printVariables()
+		case "p", "print":
+			d.printCode(arg)
+		case "w", "where":
+			for i := len(d.stack) - 1; i >= 0; i-- {
+				fmt.Fprintf(d.out, "#%d %s\n", len(d.stack)-1-i, d.stack[i])
+			}
+		case "l", "list":
+			d.printSource(context)
+		case "h", "help":
+			fmt.Fprintln(d.out, debuggerHelp)
+		default:
+			fmt.Fprintln(d.out, "unknown debugger command: "+cmd)
+		}
+	}
+}
+
+func (d *Debugger) breakCommand(spec string) {
+	if spec == "" {
+		for _, bp := range d.breakpoints {
+			fmt.Fprintln(d.out, bp.String())
+		}
+	} else if err := d.AddBreakpoint(spec); err != nil {
+		fmt.Fprintln(d.out, err)
+	}
+}
+
+func (d *Debugger) deleteCommand(spec string) {
+	bp, err := parseBreakpoint(spec)
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+	for i, x := range d.breakpoints {
+		if x == bp {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return
+		}
+	}
+	fmt.Fprintln(d.out, "no breakpoint at "+spec)
+}
+
+func (d *Debugger) isBreakpoint(context string) bool {
+	file, line, ok := parseContext(context)
+	if !ok {
+		return false
+	}
+	for _, bp := range d.breakpoints {
+		if bp.line != line {
+			continue
+		} else if bp.file == "" {
+			if file == "" {
+				return true
+			}
+			continue
+		}
+		path := file
+		if path == "" {
+			path = d.script
+		}
+		if filepath.Clean(bp.file) == filepath.Clean(path) ||
+			filepath.Base(path) == bp.file {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Debugger) printCode(code string) {
+	lines, contexts, err := TokenizeString(code)
+	if err == nil {
+		for i, x := range contexts {
+			contexts[i] = x + " in debugger"
+		}
+		var runnable Runnable
+		runnable, err = ScanAll(lines, contexts)
+		if err == nil {
+			d.evaluating = true
+			val, bo := runnable.Run(d)
+			d.evaluating = false
+			if bo == nil {
+				fmt.Fprintln(d.out, val.String())
+				return
+			} else if bo.Type() == BreakoutTypeReturn {
+				fmt.Fprintln(d.out, bo.Value().String())
+				return
+			}
+			err = bo.Error()
+		}
+	}
+	fmt.Fprintln(d.out, "error:", err)
+}
+
+func (d *Debugger) printLocation(context string, depth int) {
+	location := context
+	if depth > 0 {
+		location += " (depth " + strconv.Itoa(depth) + ")"
+	}
+	if source, ok := d.sourceLine(context, 0); ok {
+		location += ": " + strings.TrimSpace(source)
+	}
+	fmt.Fprintln(d.out, location)
+}
+
+func (d *Debugger) printSource(context string) {
+	for offset := -2; offset <= 2; offset++ {
+		source, ok := d.sourceLine(context, offset)
+		if !ok {
+			continue
+		}
+		_, line, _ := parseContext(context)
+		marker := "  "
+		if offset == 0 {
+			marker = "> "
+		}
+		fmt.Fprintf(d.out, "%s%4d %s\n", marker, line+offset, source)
+	}
+}
+
+func (d *Debugger) printVariables() {
+	lister := findVariableLister(d.inner)
+	if lister == nil {
+		fmt.Fprintln(d.out, "variables are not available")
+		return
+	}
+	for _, name := range lister.VariableNames() {
+		val := lister.Variable(name).Value
+		fmt.Fprintln(d.out, name+" = "+strconv.Quote(val.String()))
+	}
+}
+
+// sourceLine returns a line of source code relative to the line of a context.
+func (d *Debugger) sourceLine(context string, offset int) (string, bool) {
+	file, line, ok := parseContext(context)
+	if !ok {
+		return "", false
+	}
+	if file == "" {
+		file = d.script
+	}
+	lines, ok := d.sources[file]
+	if !ok {
+		// Files which cannot be read, such as evaluated code, have no source.
+		if contents, err := ioutil.ReadFile(file); err == nil {
+			lines = strings.Split(strings.TrimSuffix(string(contents), "\n"),
+				"\n")
+		}
+		d.sources[file] = lines
+	}
+	idx := line + offset - 1
+	if idx < 0 || idx >= len(lines) {
+		return "", false
+	}
+	return lines[idx], true
+}
+
+func (b debugBreakpoint) String() string {
+	if b.file == "" {
+		return strconv.Itoa(b.line)
+	}
+	return b.file + ":" + strconv.Itoa(b.line)
+}
+
+// A variableLister is a Runner which can list its variables.
+type variableLister interface {
+	VariableNames() []string
+	Variable(name string) *Variable
+}
+
+// findVariableLister returns the first variableLister in a chain of
+// WrapperRunners, or nil if there is none.
+func findVariableLister(r Runner) variableLister {
+	for r != nil {
+		if lister, ok := r.(variableLister); ok {
+			return lister
+		}
+		w, ok := r.(WrapperRunner)
+		if !ok {
+			break
+		}
+		r = w.Unwrap()
+	}
+	return nil
+}
+
+func parseBreakpoint(spec string) (debugBreakpoint, error) {
+	var bp debugBreakpoint
+	lineStr := spec
+	if idx := strings.LastIndex(spec, ":"); idx >= 0 {
+		bp.file, lineStr = spec[:idx], spec[idx+1:]
+	}
+	line, err := strconv.Atoi(lineStr)
+	if err != nil || line < 1 {
+		return bp, errors.New("invalid breakpoint: " + spec)
+	}
+	bp.line = line
+	return bp, nil
+}
+
+// parseContext parses a context of the form "line N" or "line N in FILE".
+func parseContext(context string) (file string, line int, ok bool) {
+	if !strings.HasPrefix(context, "line ") {
+		return "", 0, false
+	}
+	rest := context[len("line "):]
+	if idx := strings.Index(rest, " in "); idx >= 0 {
+		rest, file = rest[:idx], rest[idx+len(" in "):]
+	}
+	line, err := strconv.Atoi(rest)
+	if err != nil {
+		return "", 0, false
+	}
+	return file, line, true
+}
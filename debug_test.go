@@ -0,0 +1,51 @@
+package pragmash
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDebuggerBreakpoint(t *testing.T) {
+	script := "set x 1\ntry {\nset x (+ $x 1)\nthrow oops\n} catch e {\n}\n" +
+		"set z 3\n"
+	for _, mode := range runModes {
+		var out bytes.Buffer
+		input := strings.NewReader("w\np get x\nc\n")
+		d := NewDebugger(NewStdRunner(nil), "", input, &out)
+		if err := d.AddBreakpoint("7"); err != nil {
+			t.Fatal(err)
+		}
+		d.Continue()
+		if bo, err := runSource(script, d, mode); err != nil {
+			t.Fatal(err)
+		} else if bo != nil {
+			t.Fatal("unexpected breakout:", bo.Error())
+		}
+		expected := "line 7\n(debug) #0 line 7\n(debug) 2\n(debug) "
+		if out.String() != expected {
+			t.Errorf("unexpected %s output: %q", modeNames[mode],
+				out.String())
+		}
+	}
+}
+
+func TestDebuggerStep(t *testing.T) {
+	script := "set x (+ 1 2)\nset y $x\n"
+	for _, mode := range runModes {
+		var out bytes.Buffer
+		input := strings.NewReader("s\ns\nq\n")
+		d := NewDebugger(NewStdRunner(nil), "", input, &out)
+		bo, err := runSource(script, d, mode)
+		if err != nil {
+			t.Fatal(err)
+		} else if bo == nil || bo.Type() != BreakoutTypeAbort {
+			t.Fatal("expected abort breakout")
+		}
+		expected := "line 1\n(debug) line 1 (depth 1)\n(debug) line 2\n(debug) "
+		if out.String() != expected {
+			t.Errorf("unexpected %s output: %q", modeNames[mode],
+				out.String())
+		}
+	}
+}
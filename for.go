@@ -21,6 +21,7 @@ type For struct {
 
 // Run executes the for loop.
 // This fails if the variable name, exression, or body triggers an exception.
+// The loop is aborted if the Runner's context is done.
 func (f For) Run(r Runner) (*Value, *Breakout) {
 	expr, bo := f.Expression.Run(r)
 	if bo != nil {
@@ -44,6 +45,9 @@ func (f For) Run(r Runner) (*Value, *Breakout) {
 	}
 
 	for i, val := range expr.Array() {
+		if bo := checkRunner(r, f.Context); bo != nil {
+			return nil, bo
+		}
 		if variable != nil {
 			_, err := r.RunCommand("set", []*Value{variable, val})
 			if err != nil {
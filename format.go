@@ -0,0 +1,172 @@
+package pragmash
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	formatIndent       = "  "
+	formatContinuation = "    "
+	formatWidth        = 80
+)
+
+// Format rewrites a script in the canonical style.
+//
+// Blocks are indented by two spaces, arguments are only quoted when they need
+// to be, "(get x)" is written as "$x", and runs of blank lines are collapsed
+// into one. Lines which are longer than 80 columns are split between their
+// arguments with line continuations. Comments are kept.
+func Format(source string) (string, error) {
+	lines, err := TokenizeSource(source)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	depth := 0
+	blank, opened := false, false
+	for _, l := range lines {
+		// Blank lines are not kept at the start or end of a block.
+		if l.Comment == "" && l.Blank() {
+			blank = buf.Len() > 0 && !opened
+			continue
+		}
+		if blank && !l.Close {
+			buf.WriteByte('\n')
+		}
+		blank, opened = false, l.Open
+		if l.Close && depth > 0 {
+			depth--
+		}
+		indent := strings.Repeat(formatIndent, depth)
+		if l.Comment != "" {
+			buf.WriteString(indent + l.Comment + "\n")
+			continue
+		}
+		formatLine(&buf, indent, l.Line)
+		if l.Open {
+			depth++
+		}
+	}
+
+	// The formatted script must be read exactly like the original.
+	res := buf.String()
+	formatted, err := TokenizeSource(res)
+	if err != nil {
+		return "", err
+	}
+	if !reflect.DeepEqual(significantLines(lines),
+		significantLines(formatted)) {
+		return "", errors.New("formatting changed the meaning of the script")
+	}
+	return res, nil
+}
+
+// formatLine writes a line, splitting it if it is too long.
+func formatLine(buf *bytes.Buffer, indent string, l Line) {
+	var parts []string
+	if l.Close {
+		parts = append(parts, "}")
+	}
+	for _, t := range l.Tokens {
+		parts = append(parts, formatToken(t))
+	}
+	if l.Open {
+		parts = append(parts, "{")
+	}
+
+	line := indent + parts[0]
+	for i, part := range parts[1:] {
+		// Leave room for " \" unless this is the last part.
+		limit := formatWidth
+		if i+2 < len(parts) {
+			limit -= 2
+		}
+		width := utf8.RuneCountInString(line) + 1 +
+			utf8.RuneCountInString(part)
+		if width > limit {
+			buf.WriteString(line + " \\\n")
+			line = indent + formatContinuation + part
+		} else {
+			line += " " + part
+		}
+	}
+	buf.WriteString(line + "\n")
+}
+
+// formatToken returns the source code for a token.
+func formatToken(t Token) string {
+	if t.Nested == nil {
+		if !formatNeedsQuotes(t.String) {
+			return t.String
+		}
+		return formatQuote(t.String)
+	}
+	if len(t.Nested) == 2 && t.Nested[0].Nested == nil &&
+		t.Nested[0].String == "get" && t.Nested[1].Nested == nil &&
+		!formatNeedsQuotes(t.Nested[1].String) {
+		return "$" + t.Nested[1].String
+	}
+	parts := make([]string, len(t.Nested))
+	for i, x := range t.Nested {
+		parts[i] = formatToken(x)
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// formatNeedsQuotes returns true if a string cannot be written as a bare
+// word.
+func formatNeedsQuotes(s string) bool {
+	if s == "" || strings.ContainsAny(s, "\\\"()") ||
+		strings.HasPrefix(s, "$") || strings.HasPrefix(s, "#") {
+		return true
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatQuote(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			buf.WriteRune('\\')
+			buf.WriteRune(r)
+		case '\n':
+			buf.WriteString("\\n")
+		case '\r':
+			buf.WriteString("\\r")
+		case '\t':
+			buf.WriteString("\\t")
+		case '\a':
+			buf.WriteString("\\a")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// significantLines returns the lines and comments of a script without their
+// line numbers or blank lines.
+func significantLines(lines []SourceLine) []SourceLine {
+	var res []SourceLine
+	for _, l := range lines {
+		if l.Comment != "" || !l.Blank() {
+			l.Number = 0
+			res = append(res, l)
+		}
+	}
+	return res
+}
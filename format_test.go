@@ -0,0 +1,52 @@
+package pragmash
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	source := "\n# top\nset   x    \"abc\"\nif (get x) {\n\n" +
+		"      puts   \"hello world\"   \\\n   (get \"a b\")  $x\n" +
+		"    # inner\n  set y \"$100\" \"\" \"q\\\"uote\" \"new\\nline\"\n\n" +
+		"} else {\nputs ()\n\t}\n\n\nputs one two three four five six " +
+		"seven eight nine ten eleven twelve thirteen fourteen\n"
+	expected := "# top\nset x abc\nif $x {\n" +
+		"  puts \"hello world\" (get \"a b\") $x\n  # inner\n" +
+		"  set y \"$100\" \"\" \"q\\\"uote\" \"new\\nline\"\n" +
+		"} else {\n  puts ()\n}\n\n" +
+		"puts one two three four five six seven eight nine ten eleven " +
+		"twelve thirteen \\\n    fourteen\n"
+	actual, err := Format(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != expected {
+		t.Errorf("unexpected output: %q", actual)
+	}
+	if again, err := Format(actual); err != nil || again != actual {
+		t.Errorf("formatting is not idempotent: %q", again)
+	}
+}
+
+func TestFormatScripts(t *testing.T) {
+	paths, err := filepath.Glob("tests/*.pragmash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := Format(string(contents))
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+		if again, _ := Format(formatted); again != formatted {
+			t.Errorf("%s: formatting is not idempotent", path)
+		}
+	}
+}
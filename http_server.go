@@ -0,0 +1,256 @@
+package pragmash
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// An httpServer holds the routes of a script and, while it is serving, the
+// listener which receives requests for them.
+//
+// Requests are handled one at a time by the goroutine which runs the script,
+// while the script is waiting in http_wait or in an HTTP request of its own.
+// This way handlers never run at the same time as the script or each other.
+type httpServer struct {
+	routes []httpRoute
+
+	// handlers runs the code of every route. It is created when the server
+	// starts, with a copy of the variables of the script, so that handlers
+	// share variables with each other but not with the script.
+	handlers *StdAll
+	runner   Runner
+
+	listener *httpListener
+}
+
+// An httpRoute maps a method and a path to the code which handles them.
+type httpRoute struct {
+	method string
+	path   string
+	code   Runnable
+}
+
+// An httpListener passes the requests which a running server receives to the
+// goroutine of the script.
+type httpListener struct {
+	server   *http.Server
+	requests chan *httpExchange
+	stopped  chan struct{}
+}
+
+// An httpExchange is a request which is being handled, along with the
+// response which the handler has built so far.
+type httpExchange struct {
+	req  *http.Request
+	body string
+
+	status   int
+	header   http.Header
+	respBody string
+	done     chan struct{}
+}
+
+// addRoute adds a route. Routes which are added first take precedence.
+func (s *httpServer) addRoute(method, path, code string) error {
+	if !strings.HasPrefix(path, "/") {
+		return errors.New("route path must start with /: " + path)
+	}
+	runnable, err := parseCode(code, " in "+method+" "+path)
+	if err != nil {
+		return err
+	}
+	s.routes = append(s.routes, httpRoute{strings.ToUpper(method), path,
+		runnable})
+	return nil
+}
+
+// start listens on an address and returns the address which it is listening
+// on, which is useful if the port was 0.
+func (s *httpServer) start(r Runner, addr string) (string, error) {
+	if s.listener != nil {
+		return "", errors.New("already serving HTTP")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	s.handlers = &StdAll{StdIo: StdIo{server: s}}
+	s.runner = newStdRunner(s.handlers, runnerVariables(r))
+	s.listener = &httpListener{
+		requests: make(chan *httpExchange),
+		stopped:  make(chan struct{}),
+	}
+	s.listener.server = &http.Server{Handler: s.listener}
+	go s.listener.server.Serve(l)
+	return l.Addr().String(), nil
+}
+
+// stop closes the listener. Requests which are being handled still get
+// their responses.
+func (s *httpServer) stop() error {
+	if s.listener == nil {
+		return errors.New("not serving HTTP")
+	}
+	close(s.listener.stopped)
+	go s.listener.server.Shutdown(context.Background())
+	s.listener = nil
+	return nil
+}
+
+// wait handles requests until the server is stopped or the context of the
+// runner is done.
+func (s *httpServer) wait(r Runner) error {
+	ctx := RunnerContext(r)
+	for s.listener != nil {
+		select {
+		case ex := <-s.listener.requests:
+			if err := s.handle(r, ex); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// await calls f on another goroutine and handles requests until it returns.
+// If a handler is aborted, await returns without waiting for f, and the body
+// of the response from f is closed once it arrives.
+func (s *httpServer) await(r Runner,
+	f func() (*http.Response, error)) (*http.Response, error) {
+	if s.listener == nil {
+		return f()
+	}
+	var resp *http.Response
+	var err error
+	done := make(chan struct{})
+	go func() {
+		resp, err = f()
+		close(done)
+	}()
+	for {
+		var requests chan *httpExchange
+		if s.listener != nil {
+			requests = s.listener.requests
+		}
+		select {
+		case <-done:
+			return resp, err
+		case ex := <-requests:
+			if abortErr := s.handle(r, ex); abortErr != nil {
+				go func() {
+					<-done
+					if resp != nil {
+						resp.Body.Close()
+					}
+				}()
+				return nil, abortErr
+			}
+		}
+	}
+}
+
+// handle runs the handler for a request. It returns an error if the handler
+// was aborted, in which case the script should stop as well. The server stops
+// then too, so that every request still gets a response.
+func (s *httpServer) handle(r Runner, ex *httpExchange) error {
+	defer close(ex.done)
+	route := s.match(ex.req)
+	if route == nil {
+		ex.status = http.StatusNotFound
+		ex.respBody = "no route for " + ex.req.Method + " " +
+			ex.req.URL.Path + "\n"
+		return nil
+	}
+
+	// Handlers may make requests to the server, so they may be nested.
+	last := s.handlers.request
+	s.handlers.request = ex
+	defer func() {
+		s.handlers.request = last
+	}()
+	runner := inheritRunner(r, s.runner)
+	runnable := Compile(route.code, runner)
+	if val, bo := runnable.Run(runner); bo == nil {
+		ex.respBody = val.String()
+	} else if bo.Type() == BreakoutTypeReturn {
+		ex.respBody = bo.Value().String()
+	} else if bo.Type() == BreakoutTypeAbort {
+		ex.status = http.StatusInternalServerError
+		ex.respBody = bo.Error().Error() + "\n"
+		if s.listener != nil {
+			s.stop()
+		}
+		return bo.Error()
+	} else {
+		ex.status = http.StatusInternalServerError
+		ex.respBody = bo.Context() + ": " + bo.Error().Error() + "\n"
+	}
+	return nil
+}
+
+// match finds the first route for a request. A route path which ends with *
+// matches every path with the same prefix. A route method of * matches every
+// method.
+func (s *httpServer) match(req *http.Request) *httpRoute {
+	for i, route := range s.routes {
+		if route.method != "*" && route.method != req.Method {
+			continue
+		}
+		if route.path == req.URL.Path || (strings.HasSuffix(route.path, "*") &&
+			strings.HasPrefix(req.URL.Path, route.path[:len(route.path)-1])) {
+			return &s.routes[i]
+		}
+	}
+	return nil
+}
+
+// ServeHTTP passes a request to the script and writes the response once the
+// script has handled it.
+func (l *httpListener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ex := &httpExchange{
+		req:    req,
+		body:   string(body),
+		status: http.StatusOK,
+		header: http.Header{},
+		done:   make(chan struct{}),
+	}
+	select {
+	case l.requests <- ex:
+	case <-l.stopped:
+		http.Error(w, "server stopped", http.StatusServiceUnavailable)
+		return
+	case <-req.Context().Done():
+		return
+	}
+	<-ex.done
+	for name, values := range ex.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(ex.status)
+	w.Write([]byte(ex.respBody))
+}
+
+// runnerVariables copies the variables of the standard runner which a Runner
+// wraps.
+func runnerVariables(r Runner) map[string]*Value {
+	lister := findVariableLister(r)
+	if lister == nil {
+		return nil
+	}
+	res := map[string]*Value{}
+	for _, name := range lister.VariableNames() {
+		res[name] = lister.Variable(name).Value
+	}
+	return res
+}
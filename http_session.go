@@ -0,0 +1,241 @@
+package pragmash
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// An HttpSession holds the state which HTTP commands share: cookies, default
+// headers, a base URL, and the settings of the client.
+//
+// Each standard runner has its own session, so the settings which a script
+// makes last until the script ends.
+type HttpSession struct {
+	// Headers are sent with every request which does not set them itself.
+	Headers http.Header
+
+	// BaseURL is used to resolve relative URLs. It may be nil.
+	BaseURL *url.URL
+
+	// Timeout limits the time of each request, including redirects and
+	// reading the body. If it is 0, there is no limit.
+	Timeout time.Duration
+
+	// Proxy is the URL of a proxy for every request. If it is nil, the proxy
+	// comes from the HTTP_PROXY and HTTPS_PROXY environment variables.
+	Proxy *url.URL
+
+	// MaxRedirects is the number of redirects which a request follows before
+	// it fails. If it is 0, redirects are not followed, and the redirect
+	// response is returned instead.
+	MaxRedirects int
+
+	// TLS configures secure connections. It should be replaced rather than
+	// modified once the session has been used.
+	TLS *tls.Config
+
+	// Strict makes the HTTP commands throw an exception if the status code
+	// of a response is not 2xx.
+	Strict bool
+
+	// These describe the last response which an HTTP command received.
+	lastStatus int
+	lastHeader http.Header
+
+	jar *sessionJar
+
+	// transport is reused by requests until Proxy or TLS changes.
+	transport      *http.Transport
+	transportProxy *url.URL
+	transportTLS   *tls.Config
+}
+
+// NewHttpSession creates a session with no cookies, no default headers, and
+// the redirect limit of a regular HTTP client.
+func NewHttpSession() *HttpSession {
+	return &HttpSession{
+		Headers:      http.Header{},
+		MaxRedirects: 10,
+		TLS:          &tls.Config{},
+	}
+}
+
+// EnableCookies starts storing cookies, deleting any existing ones.
+//
+// If path is not "", cookies are loaded from that file if it exists, and
+// they are saved back to it after every request which changes them.
+func (s *HttpSession) EnableCookies(path string) error {
+	// TODO: use a public suffix list
+	jar, _ := cookiejar.New(nil)
+	res := &sessionJar{Jar: jar, path: path, cookies: map[string]savedCookie{}}
+	if path != "" {
+		if err := res.load(); err != nil {
+			return err
+		}
+	}
+	s.jar = res
+	return nil
+}
+
+// DisableCookies stops storing cookies and deletes the existing ones. A
+// cookie file is left as it is.
+func (s *HttpSession) DisableCookies() {
+	s.jar = nil
+}
+
+// Do sends a request with the session's cookies and settings. The URL of the
+// request may be relative to BaseURL.
+func (s *HttpSession) Do(req *http.Request) (*http.Response, error) {
+	if s.BaseURL != nil {
+		req.URL = s.BaseURL.ResolveReference(req.URL)
+	}
+	if !req.URL.IsAbs() {
+		return nil, errors.New("URL is not absolute: " + req.URL.String())
+	}
+	for name, values := range s.Headers {
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+	if err := checkSandboxHost(req); err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		CheckRedirect: s.checkRedirect,
+		Timeout:       s.Timeout,
+		Transport:     s.currentTransport(),
+	}
+	if s.jar != nil {
+		client.Jar = s.jar
+	}
+	resp, err := client.Do(req)
+	if s.jar != nil {
+		if saveErr := s.jar.save(); saveErr != nil && err == nil {
+			resp.Body.Close()
+			return nil, saveErr
+		}
+	}
+	return resp, err
+}
+
+func (s *HttpSession) checkRedirect(req *http.Request,
+	via []*http.Request) error {
+	if s.MaxRedirects == 0 {
+		return http.ErrUseLastResponse
+	} else if len(via) >= s.MaxRedirects {
+		return errors.New("stopped after " + strconv.Itoa(s.MaxRedirects) +
+			" redirects")
+	}
+	return checkSandboxHost(req)
+}
+
+func (s *HttpSession) currentTransport() *http.Transport {
+	if s.transport != nil && s.transportProxy == s.Proxy &&
+		s.transportTLS == s.TLS {
+		return s.transport
+	}
+	if s.transport != nil {
+		s.transport.CloseIdleConnections()
+	}
+	s.transport = http.DefaultTransport.(*http.Transport).Clone()
+	if s.Proxy != nil {
+		s.transport.Proxy = http.ProxyURL(s.Proxy)
+	}
+	s.transport.TLSClientConfig = s.TLS
+	s.transportProxy, s.transportTLS = s.Proxy, s.TLS
+	return s.transport
+}
+
+// A sessionJar is a cookie jar which remembers the cookies it is given so
+// that they can be saved to a file.
+type sessionJar struct {
+	*cookiejar.Jar
+	path    string
+	cookies map[string]savedCookie
+	changed bool
+}
+
+// A savedCookie is a cookie in a cookie file, along with the URL which set
+// it.
+type savedCookie struct {
+	URL    string
+	Cookie *http.Cookie
+}
+
+// SetCookies stores cookies from a response.
+func (j *sessionJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+	for _, c := range cookies {
+		// The cookie has to expire at the same time when it is loaded again.
+		saved := *c
+		if saved.MaxAge > 0 {
+			saved.Expires = time.Now().Add(time.Duration(saved.MaxAge) *
+				time.Second)
+			saved.MaxAge = 0
+		}
+		key := u.Host + " " + c.Domain + " " + c.Path + " " + c.Name
+		j.cookies[key] = savedCookie{URL: u.String(), Cookie: &saved}
+		j.changed = true
+	}
+}
+
+func (j *sessionJar) load() error {
+	data, err := ioutil.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var saved []savedCookie
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return errors.New("invalid cookie file: " + err.Error())
+	}
+	for _, s := range saved {
+		u, err := url.Parse(s.URL)
+		if err != nil || s.Cookie == nil {
+			return errors.New("invalid cookie file: " + j.path)
+		}
+		j.SetCookies(u, []*http.Cookie{s.Cookie})
+	}
+	j.changed = false
+	return nil
+}
+
+// save writes the cookies to the cookie file if they have changed. Cookies
+// which have expired or been deleted are left out.
+func (j *sessionJar) save() error {
+	if j.path == "" || !j.changed {
+		return nil
+	}
+	keys := make([]string, 0, len(j.cookies))
+	for key, s := range j.cookies {
+		c := s.Cookie
+		if c.MaxAge < 0 || (!c.Expires.IsZero() &&
+			c.Expires.Before(time.Now())) {
+			delete(j.cookies, key)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	list := make([]savedCookie, len(keys))
+	for i, key := range keys {
+		list[i] = j.cookies[key]
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	j.changed = false
+	return ioutil.WriteFile(j.path, data, 0600)
+}
@@ -0,0 +1,299 @@
+package pragmash
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestHttpSession(t *testing.T) {
+	server := newHttpTestServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Each step runs after the previous ones, with a new runner if fresh is
+	// set.
+	steps := []struct {
+		script   string
+		expected string
+		fresh    bool
+	}{
+		// Cookies last between commands, and between runners with a file.
+		{"http_base_url $URL\nhttp_cookies_on $COOKIES\nhttp_get login\n" +
+			"return (http_get check)", "secret", true},
+		{"http_base_url $URL\nhttp_cookies_on $COOKIES\n" +
+			"return (http_get check)", "secret", true},
+		{"http_cookies_off\nreturn (http_get check)", "", false},
+
+		// URLs are read with the session's cookies and headers.
+		{"http_cookies_on\nread (join $URL /login)\n" +
+			"return (read (join $URL /check))", "secret", true},
+		{"http_header X-Test c\nreturn (read (join $API header))", "c",
+			false},
+
+		{"http_base_url $API\nhttp_header X-Test a\n" +
+			"return (http_get header)", "a", false},
+		{"return (http_get header \"X-Test: b\")", "b", false},
+
+		{"http_base_url $URL\nreturn (http_get redirect)", "target", false},
+		{"http_redirects 0\nreturn (http_get redirect)", "moved", false},
+
+		{"http_timeout 0.05\ntry {\nhttp_get slow\n} catch e {\n" +
+			"return timeout\n}", "timeout", false},
+	}
+	for _, mode := range runModes {
+		variables := map[string]*Value{
+			"API": NewValueString(server.URL + "/api/"),
+			"COOKIES": NewValueString(filepath.Join(dir,
+				modeNames[mode]+".json")),
+			"URL": NewValueString(server.URL),
+		}
+		var runner Runner
+		for _, step := range steps {
+			if step.fresh {
+				runner = NewStdRunner(variables)
+			}
+			res, err := sourceResult(step.script, runner, mode)
+			if err != nil {
+				t.Errorf("%q (%s): %s", step.script, modeNames[mode], err)
+			} else if res != step.expected {
+				t.Errorf("%q (%s): expected %q but got %q", step.script,
+					modeNames[mode], step.expected, res)
+			}
+		}
+	}
+}
+
+func TestHttpResponses(t *testing.T) {
+	server := newHttpTestServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runner := NewStdRunner(map[string]*Value{
+		"FILE": NewValueString(filepath.Join(dir, "download")),
+		"URL":  NewValueString(server.URL),
+	})
+	if _, err := sourceResult("http_base_url $URL", runner,
+		runTree); err != nil {
+		t.Fatal(err)
+	}
+
+	testSourceResults(t, runner, map[string]string{
+		"http_put echo text/plain a":                    "PUT a",
+		"http_patch echo text/plain b":                  "PATCH b",
+		"http_delete echo":                              "DELETE ",
+		"http_head echo\nhttp_response_header x-method": "HEAD",
+		"http_get missing\nhttp_status":                 "404",
+		"http_get echo\nhttp_status":                    "200",
+		"http_get missing\n" +
+			"contains (http_response_header) \"X-Method: GET\"": "true",
+		"http_strict on\ntry {\nhttp_get missing\n} catch e {\n}\n" +
+			"http_strict off\nget e": "GET " + server.URL +
+			"/missing: 404 Not Found",
+		"http_download echo $FILE\nread $FILE": "GET ",
+	})
+}
+
+func TestHttpServer(t *testing.T) {
+	setup := `http_route GET /hello "set COUNT (+ $COUNT 1)\n` +
+		`http_reply_header X-Count $COUNT\nhttp_request query name"
+http_route POST /echo "join (http_request method) (http_request body)"
+http_route * /fail "http_reply_status 202\nthrow oops"
+http_route * /stop "http_stop\nhttp_reply_status 202"
+http_route GET /files/* "http_request path"
+http_base_url (join http:// (http_serve 127.0.0.1:0))`
+
+	// Each step runs after the previous ones against the same server.
+	steps := []struct {
+		script   string
+		expected string
+	}{
+		{"http_get hello?name=bob", "bob"},
+		{"http_post echo text/plain hi", "POSThi"},
+		{"http_get files/a/b", "/files/a/b"},
+		{"http_get missing\nhttp_status", "404"},
+		{"http_get fail\nhttp_status", "500"},
+		{"http_get fail", "line 2 in * /fail: oops\n"},
+
+		// Variables set by the script after the server starts are not seen
+		// by handlers.
+		{"set COUNT 10\nhttp_get hello\nhttp_response_header x-count", "2"},
+
+		{"http_get stop\nhttp_status", "202"},
+		{"try {\nhttp_get hello\n} catch e {\nreturn stopped\n}",
+			"stopped"},
+	}
+	for _, mode := range runModes {
+		runner := NewStdRunner(map[string]*Value{
+			"COUNT": NewValueString("0"),
+		})
+		if _, err := sourceResult(setup, runner, mode); err != nil {
+			t.Fatal(err)
+		}
+		for _, step := range steps {
+			res, err := sourceResult(step.script, runner, mode)
+			if err != nil {
+				t.Errorf("%q (%s): %s", step.script, modeNames[mode], err)
+			} else if res != step.expected {
+				t.Errorf("%q (%s): expected %q but got %q", step.script,
+					modeNames[mode], step.expected, res)
+			}
+		}
+	}
+}
+
+func TestHttpWait(t *testing.T) {
+	script := "http_route * /stop http_stop\n" +
+		"http_route * /* \"http_request header x-test\"\n" +
+		"http_serve 127.0.0.1:0"
+	for _, mode := range runModes {
+		runner := NewStdRunner(nil)
+		addr, err := sourceResult(script, runner, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results := make(chan string, 1)
+		go func() {
+			req, _ := http.NewRequest("GET", "http://"+addr+"/a", nil)
+			req.Header.Set("X-Test", "value")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				results <- err.Error()
+			} else {
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				results <- string(body)
+			}
+			if resp, err := http.Get("http://" + addr + "/stop"); err == nil {
+				resp.Body.Close()
+			}
+		}()
+		if _, err := sourceResult("http_wait", runner, mode); err != nil {
+			t.Fatal(err)
+		}
+		if res := <-results; res != "value" {
+			t.Errorf("unexpected %s response: %q", modeNames[mode], res)
+		}
+	}
+}
+
+func TestHttpServerAbort(t *testing.T) {
+	// A handler which exits stops the script and the server, and every
+	// request still gets a response.
+	script := "http_route * /exit \"exit 3\"\n" +
+		"http_base_url (join http:// (http_serve 127.0.0.1:0))\n" +
+		"http_get exit"
+	for _, mode := range runModes {
+		goroutines := runtime.NumGoroutine()
+		runner := NewStdRunner(nil)
+		bo, err := runSource(script, runner, mode)
+		if err != nil {
+			t.Fatal(err)
+		} else if code, ok := bo.ExitCode(); bo == nil || !ok || code != 3 {
+			t.Fatalf("expected %s exit breakout", modeNames[mode])
+		}
+		if res, err := sourceResult("http_stop", runner, mode); err == nil {
+			t.Errorf("server is still running: %q", res)
+		}
+		for i := 0; runtime.NumGoroutine() > goroutines; i++ {
+			if i == 100 {
+				t.Fatalf("%s: %d goroutines leaked", modeNames[mode],
+					runtime.NumGoroutine()-goroutines)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+}
+
+func TestHttpSandbox(t *testing.T) {
+	server := newHttpTestServer()
+	defer server.Close()
+	policy := SandboxPolicy{
+		Allow: map[string]bool{GroupNetwork: true},
+		Hosts: []string{"example.com"},
+	}
+	runner := NewSandboxRunner(NewStdRunner(map[string]*Value{
+		"URL": NewValueString(server.URL),
+	}), policy)
+
+	cases := map[string]string{
+		// The host is checked once the base URL has been applied.
+		"http_base_url $URL\ntry {\nhttp_get check\n} catch e {\n" +
+			"return $e\n}": "sandbox: host is not allowed: 127.0.0.1",
+
+		// Servers may only listen on loopback addresses when hosts are
+		// limited.
+		"try {\nhttp_serve 0.0.0.0:0\n} catch e {\nreturn $e\n}": "" +
+			"sandbox: may only listen on a loopback address: 0.0.0.0:0",
+		"http_serve 127.0.0.1:0\nhttp_stop\nreturn ok": "ok",
+	}
+
+	// Commands which read or write files need the file permissions too.
+	commands := map[string]string{
+		"http_cookies_on cookies.json": "sandbox: http_cookies_on requires " +
+			"the fs-write permission",
+		"http_download x file": "sandbox: http_download requires the " +
+			"fs-write permission",
+		"http_tls_ca ca.pem": "sandbox: http_tls_ca requires the fs-read " +
+			"permission",
+		"http_tls_cert a.pem b.pem": "sandbox: http_tls_cert requires the " +
+			"fs-read permission",
+	}
+	for command, expected := range commands {
+		cases["try {\n"+command+"\n} catch e {\nreturn $e\n}"] = expected
+	}
+	testSourceResults(t, runner, cases)
+}
+
+func newHttpTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret",
+			MaxAge: 60})
+	})
+	mux.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err == nil {
+			w.Write([]byte(c.Value))
+		}
+	})
+	mux.HandleFunc("/api/header", func(w http.ResponseWriter,
+		r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Test")))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter,
+		r *http.Request) {
+		w.Header().Set("Location", "/target")
+		w.WriteHeader(http.StatusFound)
+		w.Write([]byte("moved"))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Write([]byte(r.Method + " " + string(body)))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter,
+		r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		http.Error(w, "nope", http.StatusNotFound)
+	})
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second / 5)
+	})
+	return httptest.NewServer(mux)
+}
@@ -0,0 +1,216 @@
+package pragmash
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A LintIssue is a likely mistake in a script.
+type LintIssue struct {
+	Context string
+	Message string
+}
+
+// String returns the context and the message of the issue.
+func (l LintIssue) String() string {
+	return l.Context + ": " + l.Message
+}
+
+// lintNotPattern matches conditions which start with a quoted "not", which is
+// still read as a negation.
+var lintNotPattern = regexp.MustCompile(`^\s*(\}\s*else\s+)?(if|while)\s+` +
+	`"not"(\s|$)`)
+
+// Lint checks a script for likely mistakes without running it. It fails if the
+// script cannot be parsed.
+//
+// Lint reports commands which are not in the standard library, commands with
+// the wrong number of arguments, variables which are read but never set,
+// code after a return, break, or continue, conditions which start with a
+// quoted "not" (see SYNTAX.md), and break or continue outside of loops.
+// Variables are not checked if the script uses eval or exec, since they may
+// set variables which Lint cannot see.
+func Lint(source string) ([]LintIssue, error) {
+	lines, contexts, err := TokenizeString(source)
+	if err != nil {
+		return nil, err
+	}
+	runnable, err := ScanAll(lines, contexts)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &linter{assigned: map[string]bool{}}
+	for name := range CreateStandardVariables("", nil) {
+		l.assigned[name] = true
+	}
+	l.runnable(runnable, 0)
+	if !l.dynamic {
+		for _, read := range l.reads {
+			if !l.assigned[read.Message] {
+				l.issue(read.Context, "variable is never set: "+read.Message)
+			}
+		}
+	}
+
+	for i, line := range strings.Split(source, "\n") {
+		if lintNotPattern.MatchString(line) {
+			l.issue("line "+strconv.Itoa(i+1), "a quoted \"not\" still "+
+				"negates the condition; put \"not\" last to compare with it")
+		}
+	}
+
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		_, line1, _ := parseContext(l.issues[i].Context)
+		_, line2, _ := parseContext(l.issues[j].Context)
+		return line1 < line2
+	})
+	return l.issues, nil
+}
+
+type linter struct {
+	issues   []LintIssue
+	assigned map[string]bool
+
+	// reads holds the contexts and names of variable reads.
+	reads []LintIssue
+
+	// dynamic is true if variables may be set in ways that cannot be seen.
+	dynamic bool
+}
+
+func (l *linter) issue(context, message string) {
+	l.issues = append(l.issues, LintIssue{context, message})
+}
+
+// assign records a variable which is set by a loop or a catch block.
+func (l *linter) assign(variable Runnable, loops int) {
+	if variable == nil {
+		return
+	}
+	if name, ok := variable.(*Value); ok {
+		l.assigned[name.String()] = true
+	} else {
+		l.dynamic = true
+		l.runnable(variable, loops)
+	}
+}
+
+func (l *linter) command(x CommandRunnable, name string) {
+	switch name {
+	case "get":
+		if len(x.Arguments) == 1 {
+			if v, ok := x.Arguments[0].(*Value); ok {
+				l.reads = append(l.reads, LintIssue{x.Context, v.String()})
+			}
+		}
+	case "set":
+		if len(x.Arguments) == 2 {
+			if v, ok := x.Arguments[0].(*Value); ok {
+				l.assigned[v.String()] = true
+			} else {
+				l.dynamic = true
+			}
+		}
+	case "eval", "exec":
+		l.dynamic = true
+	}
+
+	count := len(x.Arguments)
+	min, max, ok := lintArguments(name)
+	if !ok {
+		l.issue(x.Context, "unknown command: "+name)
+	} else if count < min || (max >= 0 && count > max) {
+		l.issue(x.Context, name+": "+argumentsError(max < 0, min).Error()+
+			", got "+strconv.Itoa(count))
+	}
+}
+
+func (l *linter) runnable(x Runnable, loops int) {
+	switch x := x.(type) {
+	case BreakRunner:
+		if loops == 0 {
+			l.issue(x.Context, "break outside of a loop")
+		}
+	case CommandRunnable:
+		l.runnable(x.Name, loops)
+		l.runnables(x.Arguments, loops)
+		if name, ok := x.Name.(*Value); ok {
+			l.command(x, name.String())
+		}
+	case Condition:
+		l.runnables(x, loops)
+	case ContinueRunner:
+		if loops == 0 {
+			l.issue(x.Context, "continue outside of a loop")
+		}
+	case For:
+		l.runnable(x.Expression, loops)
+		l.assign(x.Index, loops)
+		l.assign(x.Variable, loops)
+		l.runnable(x.Body, loops+1)
+	case If:
+		l.runnables(x.Conditions, loops)
+		l.runnables(x.Branches, loops)
+	case NotCondition:
+		l.runnables(x, loops)
+	case ReturnRunner:
+		l.runnables(x.Arguments, loops)
+	case RunnableList:
+		l.runnables(x, loops)
+		for i := 0; i+1 < len(x); i++ {
+			switch x[i].(type) {
+			case BreakRunner, ContinueRunner, ReturnRunner:
+				l.issue(runnableContext(x[i+1]), "unreachable code")
+				return
+			}
+		}
+	case TestBlock:
+		// Breakouts cannot leave a test, so it starts outside of any loop.
+		l.runnable(x.Name, loops)
+		l.runnable(x.Body, 0)
+	case Try:
+		l.runnable(x.Try, loops)
+		l.assign(x.Variable, loops)
+		l.runnable(x.Catch, loops)
+	case While:
+		l.runnable(x.Condition, loops)
+		l.runnable(x.Body, loops+1)
+	}
+}
+
+func (l *linter) runnables(list []Runnable, loops int) {
+	for _, x := range list {
+		l.runnable(x, loops)
+	}
+}
+
+// lintArguments returns the range of argument counts which a standard command
+// accepts. The maximum is -1 if there is none. The last return value is false
+// if the command does not exist.
+func lintArguments(name string) (min, max int, ok bool) {
+	switch name {
+	case "get":
+		return 1, 1, true
+	case "set":
+		return 2, 2, true
+	}
+	method, ok := stdCommandMethod(name)
+	if !ok {
+		return 0, 0, false
+	}
+	t := method.Type
+
+	// The first argument is the receiver.
+	for i := 1; i < t.NumIn(); i++ {
+		if t.In(i) != runnerType {
+			min++
+		}
+	}
+	if t.IsVariadic() {
+		return min - 1, -1, true
+	}
+	return min, min, true
+}
@@ -0,0 +1,42 @@
+package pragmash
+
+import (
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	script := "set x 1\nputs $x $y\nfrobnicate 3\nlen a b\n" +
+		"if \"not\" $x {\nbreak\n}\nfor i (range 3) {\ncontinue\n" +
+		"puts $i\n}\ntry {\nthrow oops\n} catch e {\nputs $e\n}\n" +
+		"test t {\nbreak\n}\nreturn 1\nputs after\n"
+	issues, err := Lint(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"line 2: variable is never set: y",
+		"line 3: unknown command: frobnicate",
+		"line 4: len: expected 1 argument, got 2",
+		"line 5: a quoted \"not\" still negates the condition; put \"not\" " +
+			"last to compare with it",
+		"line 6: break outside of a loop",
+		"line 10: unreachable code",
+		"line 18: break outside of a loop",
+		"line 21: unreachable code",
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("expected %d issues but got %v", len(expected), issues)
+	}
+	for i, x := range expected {
+		if issues[i].String() != x {
+			t.Errorf("issue %d: expected %q but got %q", i, x, issues[i])
+		}
+	}
+
+	issues, err = Lint("eval \"set y 1\"\nputs $y\n")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(issues) != 0 {
+		t.Error("unexpected issues after eval:", issues)
+	}
+}
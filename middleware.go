@@ -0,0 +1,131 @@
+package pragmash
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A CommandHandler runs a command by name, just like Runner.RunCommand.
+type CommandHandler func(name string, args []*Value) (*Value, error)
+
+// A Middleware wraps a CommandHandler with extra behavior. It may inspect or
+// change the command before calling next, or skip next entirely.
+type Middleware func(next CommandHandler) CommandHandler
+
+// A CommandEvent describes a command which has finished running.
+type CommandEvent struct {
+	Name     string
+	Args     []*Value
+	Result   *Value
+	Err      error
+	Duration time.Duration
+}
+
+// A MiddlewareRunner passes every command through a chain of Middleware
+// before it reaches the wrapped Runner.
+type MiddlewareRunner struct {
+	wrappedRunner
+	handler CommandHandler
+}
+
+// NewMiddlewareRunner wraps a Runner with a chain of Middleware.
+// The first Middleware sees each command first.
+func NewMiddlewareRunner(r Runner, m ...Middleware) *MiddlewareRunner {
+	handler := r.RunCommand
+	for i := len(m) - 1; i >= 0; i-- {
+		handler = m[i](handler)
+	}
+	res := &MiddlewareRunner{wrappedRunner{r}, handler}
+	res.SetOuter(res)
+	return res
+}
+
+// RunCommand runs a command through the Middleware chain.
+func (m *MiddlewareRunner) RunCommand(name string, args []*Value) (*Value,
+	error) {
+	return m.handler(name, args)
+}
+
+// AuditLog returns Middleware which writes a line to w for every run of the
+// named commands, including the arguments and the outcome. If no names are
+// given, every command is logged.
+func AuditLog(w io.Writer, names ...string) Middleware {
+	methods := commandMethodSet(names)
+	return Hooks(nil, func(e *CommandEvent) {
+		if methods != nil && !methods[RewriteCommandName(OperatorRewrites,
+			e.Name)] {
+			return
+		}
+		args := make([]string, len(e.Args))
+		for i, x := range e.Args {
+			args[i] = strconv.Quote(x.String())
+		}
+		status := "ok"
+		if e.Err != nil {
+			status = "error: " + strconv.Quote(e.Err.Error())
+		}
+		fmt.Fprintf(w, "%s %s [%s] %s (%s)\n",
+			time.Now().UTC().Format(time.RFC3339), e.Name,
+			strings.Join(args, ", "), status, e.Duration)
+	})
+}
+
+// Hooks returns Middleware which calls before just before each command runs
+// and after once it finishes. Either function may be nil.
+func Hooks(before func(name string, args []*Value),
+	after func(e *CommandEvent)) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(name string, args []*Value) (*Value, error) {
+			if before != nil {
+				before(name, args)
+			}
+			start := time.Now()
+			res, err := next(name, args)
+			if after != nil {
+				// The arguments are copied because the caller may reuse the
+				// slice once the command returns.
+				argsCopy := make([]*Value, len(args))
+				copy(argsCopy, args)
+				after(&CommandEvent{name, argsCopy, res, err,
+					time.Since(start)})
+			}
+			return res, err
+		}
+	}
+}
+
+// MockCommands returns Middleware which replaces the named commands with
+// handlers. Commands which are not in the map run as usual.
+// Names are matched the same way the standard library matches them, so a mock
+// for "http_get" also replaces "httpGet".
+func MockCommands(mocks map[string]CommandHandler) Middleware {
+	methods := map[string]CommandHandler{}
+	for name, handler := range mocks {
+		methods[RewriteCommandName(OperatorRewrites, name)] = handler
+	}
+	return func(next CommandHandler) CommandHandler {
+		return func(name string, args []*Value) (*Value, error) {
+			method := RewriteCommandName(OperatorRewrites, name)
+			if mock, ok := methods[method]; ok {
+				return mock(name, args)
+			}
+			return next(name, args)
+		}
+	}
+}
+
+// commandMethodSet converts command names to a set of method names, or nil if
+// there are no names.
+func commandMethodSet(names []string) map[string]bool {
+	if len(names) == 0 {
+		return nil
+	}
+	res := map[string]bool{}
+	for _, name := range names {
+		res[RewriteCommandName(OperatorRewrites, name)] = true
+	}
+	return res
+}
@@ -0,0 +1,74 @@
+package pragmash
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareMock(t *testing.T) {
+	mock := MockCommands(map[string]CommandHandler{
+		"cmd": func(name string, args []*Value) (*Value, error) {
+			return NewValueString("mocked " + args[0].String()), nil
+		},
+		"http_get": func(name string, args []*Value) (*Value, error) {
+			return nil, errors.New("offline")
+		},
+	})
+	script := "try {\nhttpGet http://example.com\n} catch e {\n}\n" +
+		"return (cmd ls) $e"
+	for _, mode := range runModes {
+		var calls []string
+		hooks := Hooks(func(name string, args []*Value) {
+			calls = append(calls, name)
+		}, nil)
+		runner := NewMiddlewareRunner(NewStdRunner(nil), hooks, mock)
+		res, err := sourceResult(script, runner, mode)
+		if err != nil {
+			t.Fatal(err)
+		} else if res != "mocked ls offline" {
+			t.Errorf("unexpected %s result: %s", modeNames[mode], res)
+		}
+		expected := []string{"httpGet", "set", "cmd", "get"}
+		if strings.Join(calls, " ") != strings.Join(expected, " ") {
+			t.Errorf("unexpected %s calls: %v", modeNames[mode], calls)
+		}
+	}
+}
+
+func TestMiddlewareAuditLog(t *testing.T) {
+	// Commands run by eval should pass through the middleware too.
+	script := "eval \"+ 1 2\"\ntry {\neval \"/ 1 0\"\n} catch e {\n}"
+	for _, mode := range runModes {
+		var log bytes.Buffer
+		var events []*CommandEvent
+		hooks := Hooks(nil, func(e *CommandEvent) {
+			events = append(events, e)
+		})
+		runner := NewMiddlewareRunner(NewStdRunner(nil),
+			AuditLog(&log, "eval"), hooks)
+		if _, err := sourceResult(script, runner, mode); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("unexpected %s log: %s", modeNames[mode], log.String())
+		} else if !strings.Contains(lines[0], ` eval ["+ 1 2"] ok (`) {
+			t.Error("unexpected log line:", lines[0])
+		} else if !strings.Contains(lines[1],
+			`error: "line 1 in eval: division`) {
+			t.Error("unexpected log line:", lines[1])
+		}
+
+		if len(events) != 5 {
+			t.Fatalf("unexpected number of %s events: %d", modeNames[mode],
+				len(events))
+		} else if events[0].Name != "+" || events[0].Result.String() != "3" {
+			t.Error("unexpected first event:", events[0])
+		} else if events[2].Name != "/" || events[2].Err == nil {
+			t.Error("unexpected third event:", events[2])
+		}
+	}
+}
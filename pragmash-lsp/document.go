@@ -0,0 +1,457 @@
+package main
+
+import (
+	"github.com/unixpickle/pragmash"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	contextLine = regexp.MustCompile(`line (\d+)`)
+	execPattern = regexp.MustCompile(`(^|[\s(])exec\s+("[^"]*"|[^\s()]+)`)
+)
+
+var blockKeywords = map[string]bool{
+	"for": true, "if": true, "test": true, "try": true, "while": true,
+}
+
+// A document is an open script and what is known about it.
+type document struct {
+	uri   string
+	text  string
+	lines []string
+
+	// source is nil if the script cannot be tokenized.
+	source []pragmash.SourceLine
+
+	// definitions maps each variable to the place where it is first set.
+	definitions map[string]position
+}
+
+func newDocument(uri, text string) *document {
+	d := &document{uri: uri, text: text, lines: strings.Split(text, "\n"),
+		definitions: map[string]position{}}
+	if source, err := pragmash.TokenizeSource(text); err == nil {
+		d.source = source
+		d.findDefinitions()
+	}
+	return d
+}
+
+// diagnostics returns the syntax errors in the script, or the issues that
+// pragmash.Lint finds if there are none.
+func (d *document) diagnostics() []diagnostic {
+	res := []diagnostic{}
+	if _, _, err := pragmash.TokenizeString(d.text); err != nil {
+		message := err.Error()
+		if err == io.EOF {
+			message = "unterminated string or nested command"
+		}
+		line := d.tokenizeErrorLine()
+		return append(res, d.lineDiagnostic(line, severityError, message))
+	}
+	issues, err := pragmash.Lint(d.text)
+	if err != nil {
+		// Errors without a line number come from the end of the script.
+		line := contextLineIndex(err.Error(), len(d.lines)-1)
+		return append(res, d.lineDiagnostic(line, severityError, err.Error()))
+	}
+	for _, issue := range issues {
+		line := contextLineIndex(issue.Context, 0)
+		res = append(res, d.lineDiagnostic(line, severityWarning,
+			issue.Message))
+	}
+	return res
+}
+
+// tokenizeErrorLine finds the line which the tokenizer fails on.
+func (d *document) tokenizeErrorLine() int {
+	tokenizer := pragmash.NewTokenizer()
+	for i, line := range d.lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if _, err := tokenizer.Line(line); err != nil {
+			return i
+		}
+	}
+	return len(d.lines) - 1
+}
+
+func (d *document) lineDiagnostic(line, severity int,
+	message string) diagnostic {
+	return diagnostic{Range: d.lineRange(line), Severity: severity,
+		Source: "pragmash", Message: message}
+}
+
+// hover describes the command or variable under the cursor.
+func (d *document) hover(pos position) *hover {
+	word, start, end, ok := d.wordAt(pos)
+	if !ok {
+		return nil
+	}
+	r := textRange{d.position(pos.Line, start), d.position(pos.Line, end)}
+	var text string
+	if d.isVariable(pos.Line, start) {
+		if def, ok := d.definitions[word]; ok {
+			text = "variable `" + word + "`, first set on line " +
+				strconv.Itoa(def.Line+1)
+		} else if standardVariable(word) {
+			text = "standard variable `" + word + "`"
+		} else {
+			return nil
+		}
+	} else if d.isCommand(pos.Line, start) {
+		usage, ok := pragmash.StdCommandUsage(word)
+		if !ok {
+			return nil
+		}
+		text = "```\n" + usage + "\n```"
+		if doc, ok := pragmash.StdCommandDoc(word); ok {
+			text = "```\n" + doc.Usage + "\n```\n\n" + doc.Doc
+		}
+	} else {
+		return nil
+	}
+	return &hover{markupContent{"markdown", text}, r}
+}
+
+// completion lists the variables after a "$" and the commands elsewhere.
+func (d *document) completion(pos position) []completionItem {
+	res := []completionItem{}
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return res
+	}
+	line := d.lines[pos.Line]
+	idx := byteIndex(line, pos.Character)
+	start := idx
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(line[:start])
+		if !isWordRune(r) {
+			break
+		}
+		start -= size
+	}
+
+	if d.isVariable(pos.Line, start) {
+		for _, name := range d.variableNames() {
+			res = append(res, completionItem{Label: name,
+				Kind: completionVariable})
+		}
+		return res
+	}
+	for _, name := range pragmash.StdCommands() {
+		usage, _ := pragmash.StdCommandUsage(name)
+		if doc, ok := pragmash.StdCommandDoc(name); ok {
+			usage = doc.Usage
+		}
+		res = append(res, completionItem{Label: name,
+			Kind: completionFunction, Detail: usage})
+	}
+	return res
+}
+
+// definition finds where the variable under the cursor is first set, or the
+// file which the path under the cursor runs with exec.
+func (d *document) definition(pos position) *location {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return nil
+	}
+	line := d.lines[pos.Line]
+	idx := byteIndex(line, pos.Character)
+	for _, match := range execPattern.FindAllStringSubmatchIndex(line, -1) {
+		if idx < match[4] || idx > match[5] {
+			continue
+		}
+		path := d.resolvePath(strings.Trim(line[match[4]:match[5]], `"`))
+		if path == "" {
+			return nil
+		}
+		u := url.URL{Scheme: "file", Path: path}
+		return &location{u.String(), textRange{}}
+	}
+
+	word, start, _, ok := d.wordAt(pos)
+	if !ok || !d.isVariable(pos.Line, start) {
+		return nil
+	}
+	def, ok := d.definitions[word]
+	if !ok {
+		return nil
+	}
+	end := def
+	end.Character += utf16Length(word)
+	return &location{d.uri, textRange{def, end}}
+}
+
+// symbols returns the blocks in the script, with the variables which are
+// first set in each block.
+func (d *document) symbols() []*documentSymbol {
+	root := &documentSymbol{}
+	stack := []*documentSymbol{root}
+	seen := map[string]bool{}
+	for _, l := range d.source {
+		if l.Comment != "" || l.Blank() {
+			continue
+		}
+		lineIdx := l.Number - 1
+		parent := stack[len(stack)-1]
+		if l.Close && !l.Open && len(stack) > 1 {
+			parent.Range.End = d.lineRange(lineIdx).End
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if len(l.Tokens) == 0 {
+			continue
+		}
+		keyword := l.Tokens[0].String
+		if l.Open && !l.Close && blockKeywords[keyword] {
+			lineRange := d.lineRange(lineIdx)
+			name := strings.TrimSpace(d.lines[lineIdx])
+			name = strings.TrimSpace(strings.TrimSuffix(name, "{"))
+			kind := symbolNamespace
+			if keyword == "test" {
+				kind = symbolFunction
+			}
+			symbol := &documentSymbol{Name: name, Kind: kind,
+				Range: lineRange, SelectionRange: lineRange}
+			parent.Children = append(parent.Children, symbol)
+			stack = append(stack, symbol)
+		} else if keyword == "set" && len(l.Tokens) == 3 &&
+			l.Tokens[1].Nested == nil && !seen[l.Tokens[1].String] {
+			seen[l.Tokens[1].String] = true
+			lineRange := d.lineRange(lineIdx)
+			parent.Children = append(parent.Children, &documentSymbol{
+				Name: l.Tokens[1].String, Kind: symbolVariable,
+				Range: lineRange, SelectionRange: lineRange})
+		}
+	}
+
+	// Blocks which are never closed end with the script.
+	for _, symbol := range stack[1:] {
+		symbol.Range.End = d.lineRange(len(d.lines) - 1).End
+	}
+	if root.Children == nil {
+		return []*documentSymbol{}
+	}
+	return root.Children
+}
+
+// findDefinitions records where each variable is first set by "set", a for
+// loop, or a catch block.
+func (d *document) findDefinitions() {
+	for _, l := range d.source {
+		if l.Comment != "" || len(l.Tokens) == 0 {
+			continue
+		}
+		lineIdx := l.Number - 1
+		tokens := l.Tokens
+		switch {
+		case tokens[0].String == "for" && l.Open && len(tokens) > 2:
+			for _, t := range tokens[1 : len(tokens)-1] {
+				d.define(t, lineIdx)
+			}
+		case tokens[0].String == "catch" && l.Close && len(tokens) == 2:
+			d.define(tokens[1], lineIdx)
+		default:
+			d.findSets(tokens, lineIdx)
+		}
+	}
+}
+
+func (d *document) findSets(tokens []pragmash.Token, lineIdx int) {
+	if len(tokens) == 3 && tokens[0].Nested == nil &&
+		tokens[0].String == "set" {
+		d.define(tokens[1], lineIdx)
+	}
+	for _, t := range tokens {
+		if t.Nested != nil {
+			d.findSets(t.Nested, lineIdx)
+		}
+	}
+}
+
+func (d *document) define(t pragmash.Token, lineIdx int) {
+	if t.Nested != nil || t.String == "" {
+		return
+	}
+	if _, ok := d.definitions[t.String]; ok {
+		return
+	}
+	column := 0
+	if lineIdx < len(d.lines) {
+		column = wordIndex(d.lines[lineIdx], t.String)
+	}
+	d.definitions[t.String] = d.position(lineIdx, column)
+}
+
+func (d *document) variableNames() []string {
+	var res []string
+	for name := range d.definitions {
+		res = append(res, name)
+	}
+	for name := range pragmash.CreateStandardVariables("", nil) {
+		if _, ok := d.definitions[name]; !ok {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// resolvePath finds an exec'd file relative to the script or the working
+// directory. It returns "" if the file does not exist.
+func (d *document) resolvePath(path string) string {
+	u, err := url.Parse(d.uri)
+	if err != nil {
+		return ""
+	}
+	dir := filepath.Dir(u.Path)
+	if strings.HasPrefix(path, "$DIR") {
+		path = dir + path[len("$DIR"):]
+	}
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		candidates = []string{filepath.Join(dir, path), path}
+	}
+	for _, p := range candidates {
+		if abs, err := filepath.Abs(p); err == nil {
+			if _, err := os.Stat(abs); err == nil {
+				return abs
+			}
+		}
+	}
+	return ""
+}
+
+// wordAt returns the word under the cursor and its byte offsets in the line.
+func (d *document) wordAt(pos position) (word string, start, end int,
+	ok bool) {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return "", 0, 0, false
+	}
+	line := d.lines[pos.Line]
+	start = byteIndex(line, pos.Character)
+	end = start
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(line[:start])
+		if !isWordRune(r) {
+			break
+		}
+		start -= size
+	}
+	for end < len(line) {
+		r, size := utf8.DecodeRuneInString(line[end:])
+		if !isWordRune(r) {
+			break
+		}
+		end += size
+	}
+	if start == end {
+		return "", 0, 0, false
+	}
+	return line[start:end], start, end, true
+}
+
+// isVariable returns true if the word at a byte offset follows a "$" or the
+// name of the get or set command.
+func (d *document) isVariable(lineIdx, start int) bool {
+	line := d.lines[lineIdx]
+	if start > 0 && line[start-1] == '$' {
+		return true
+	}
+	fields := strings.FieldsFunc(line[:start], func(r rune) bool {
+		return unicode.IsSpace(r) || r == '('
+	})
+	if len(fields) == 0 {
+		return false
+	}
+	last := fields[len(fields)-1]
+	return last == "get" || last == "set"
+}
+
+// isCommand returns true if the word at a byte offset starts a line or a
+// nested command.
+func (d *document) isCommand(lineIdx, start int) bool {
+	before := strings.TrimSpace(d.lines[lineIdx][:start])
+	return before == "" || strings.HasSuffix(before, "(")
+}
+
+func (d *document) lineRange(lineIdx int) textRange {
+	if lineIdx < 0 {
+		lineIdx = 0
+	}
+	if lineIdx >= len(d.lines) {
+		lineIdx = len(d.lines) - 1
+	}
+	return textRange{position{lineIdx, 0},
+		d.position(lineIdx, len(d.lines[lineIdx]))}
+}
+
+// position converts a byte offset in a line to a protocol position, which
+// counts UTF-16 code units.
+func (d *document) position(lineIdx, offset int) position {
+	return position{lineIdx, utf16Length(d.lines[lineIdx][:offset])}
+}
+
+// contextLineIndex finds a line number like "line 3" in a message and returns
+// its index, or a default index if there is none.
+func contextLineIndex(message string, def int) int {
+	match := contextLine.FindStringSubmatch(message)
+	if match == nil {
+		return def
+	}
+	line, _ := strconv.Atoi(match[1])
+	return line - 1
+}
+
+func standardVariable(name string) bool {
+	_, ok := pragmash.CreateStandardVariables("", nil)[name]
+	return ok
+}
+
+func isWordRune(r rune) bool {
+	return !unicode.IsSpace(r) && !strings.ContainsRune(`()"$`, r)
+}
+
+// wordIndex returns the byte offset of the first place where a word appears
+// on its own in a line, or 0 if it does not.
+func wordIndex(line, word string) int {
+	for i := 0; i+len(word) <= len(line); i++ {
+		if !strings.HasPrefix(line[i:], word) {
+			continue
+		}
+		before, _ := utf8.DecodeLastRuneInString(line[:i])
+		after, _ := utf8.DecodeRuneInString(line[i+len(word):])
+		if (i == 0 || !isWordRune(before)) &&
+			(i+len(word) == len(line) || !isWordRune(after)) {
+			return i
+		}
+	}
+	return 0
+}
+
+func utf16Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// byteIndex converts a UTF-16 column to a byte offset in a line.
+func byteIndex(line string, column int) int {
+	units := 0
+	for i, r := range line {
+		if units >= column {
+			return i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len(line)
+}
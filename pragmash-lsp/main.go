@@ -0,0 +1,137 @@
+// Command pragmash-lsp is a language server for pragmash scripts.
+//
+// It speaks the Language Server Protocol over standard input and output, so
+// any editor with an LSP client can run it to get diagnostics, hover
+// documentation, completion, go-to-definition, and document symbols.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	methodNotFound = -32601
+	invalidParams  = -32602
+)
+
+// A message is a request, a notification, or a response.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type errorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   responseError    `json:"error"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+func main() {
+	os.Exit(serve(os.Stdin, os.Stdout))
+}
+
+// serve handles messages from a client until it exits or closes its input, and
+// returns the status to exit with. The status is 0 only if the client asked
+// the server to shut down first.
+func serve(in io.Reader, out io.Writer) int {
+	s := newServer(out)
+	r := bufio.NewReader(in)
+	for !s.exited {
+		msg, err := readMessage(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "pragmash-lsp:", err)
+			return 1
+		}
+		s.handle(msg)
+		if s.err != nil {
+			fmt.Fprintln(os.Stderr, "pragmash-lsp:", s.err)
+			return 1
+		}
+	}
+	if s.shutdown {
+		return 0
+	}
+	return 1
+}
+
+// readMessage reads a message with its Content-Length header.
+func readMessage(r *bufio.Reader) (*message, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// readBody reads the headers of a message and returns its body.
+func readBody(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 &&
+			strings.EqualFold(strings.TrimSpace(parts[0]), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, errors.New("invalid Content-Length header")
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// writeMessage writes a message with its Content-Length header.
+func writeMessage(w io.Writer, msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/unixpickle/pragmash"
+	"io"
+)
+
+// These are the parts of the protocol which the server uses.
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type textRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string    `json:"uri"`
+	Range textRange `json:"range"`
+}
+
+type textDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type textDocumentPosition struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+type diagnostic struct {
+	Range    textRange `json:"range"`
+	Severity int       `json:"severity"`
+	Source   string    `json:"source"`
+	Message  string    `json:"message"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    textRange     `json:"range"`
+}
+
+type completionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type documentSymbol struct {
+	Name           string            `json:"name"`
+	Kind           int               `json:"kind"`
+	Range          textRange         `json:"range"`
+	SelectionRange textRange         `json:"selectionRange"`
+	Children       []*documentSymbol `json:"children,omitempty"`
+}
+
+const (
+	severityError   = 1
+	severityWarning = 2
+
+	completionFunction = 3
+	completionVariable = 6
+
+	symbolNamespace = 3
+	symbolFunction  = 12
+	symbolVariable  = 13
+)
+
+// A server handles the messages from a client.
+type server struct {
+	out       io.Writer
+	documents map[string]*document
+	shutdown  bool
+	exited    bool
+
+	// err is the first error from writing to the client.
+	err error
+}
+
+func newServer(out io.Writer) *server {
+	return &server{out: out, documents: map[string]*document{}}
+}
+
+func (s *server) handle(msg *message) {
+	var result interface{}
+	var err *responseError
+	switch msg.Method {
+	case "initialize":
+		result = s.initialize()
+	case "initialized":
+	case "shutdown":
+		s.shutdown = true
+	case "exit":
+		s.exited = true
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument textDocumentItem `json:"textDocument"`
+		}
+		if err = decodeParams(msg, &params); err == nil {
+			s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument   textDocumentIdentifier `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		err = decodeParams(msg, &params)
+		if err == nil && len(params.ContentChanges) > 0 {
+			changes := params.ContentChanges
+			s.update(params.TextDocument.URI, changes[len(changes)-1].Text)
+		}
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument textDocumentIdentifier `json:"textDocument"`
+		}
+		if err = decodeParams(msg, &params); err == nil {
+			delete(s.documents, params.TextDocument.URI)
+			s.publish(params.TextDocument.URI, []diagnostic{})
+		}
+	case "textDocument/hover":
+		var params textDocumentPosition
+		if err = decodeParams(msg, &params); err == nil {
+			if doc := s.documents[params.TextDocument.URI]; doc != nil {
+				if h := doc.hover(params.Position); h != nil {
+					result = h
+				}
+			}
+		}
+	case "textDocument/completion":
+		var params textDocumentPosition
+		if err = decodeParams(msg, &params); err == nil {
+			result = []completionItem{}
+			if doc := s.documents[params.TextDocument.URI]; doc != nil {
+				result = doc.completion(params.Position)
+			}
+		}
+	case "textDocument/definition":
+		var params textDocumentPosition
+		if err = decodeParams(msg, &params); err == nil {
+			if doc := s.documents[params.TextDocument.URI]; doc != nil {
+				if loc := doc.definition(params.Position); loc != nil {
+					result = loc
+				}
+			}
+		}
+	case "textDocument/documentSymbol":
+		var params struct {
+			TextDocument textDocumentIdentifier `json:"textDocument"`
+		}
+		if err = decodeParams(msg, &params); err == nil {
+			result = []*documentSymbol{}
+			if doc := s.documents[params.TextDocument.URI]; doc != nil {
+				result = doc.symbols()
+			}
+		}
+	default:
+		err = &responseError{methodNotFound, "method not found: " + msg.Method}
+	}
+
+	// Notifications never get a response.
+	if msg.ID == nil {
+		return
+	}
+	if err != nil {
+		s.write(errorResponse{"2.0", msg.ID, *err})
+	} else {
+		s.write(response{"2.0", msg.ID, result})
+	}
+}
+
+func (s *server) initialize() interface{} {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync": 1,
+			"hoverProvider":    true,
+			"completionProvider": map[string]interface{}{
+				"triggerCharacters": []string{"$", "("},
+			},
+			"definitionProvider":     true,
+			"documentSymbolProvider": true,
+		},
+		"serverInfo": map[string]interface{}{
+			"name":    "pragmash-lsp",
+			"version": pragmash.Version(),
+		},
+	}
+}
+
+// update replaces the text of a document and publishes its diagnostics.
+func (s *server) update(uri, text string) {
+	doc := newDocument(uri, text)
+	s.documents[uri] = doc
+	s.publish(uri, doc.diagnostics())
+}
+
+func (s *server) publish(uri string, diagnostics []diagnostic) {
+	s.write(notification{"2.0", "textDocument/publishDiagnostics",
+		map[string]interface{}{"uri": uri, "diagnostics": diagnostics}})
+}
+
+func (s *server) write(msg interface{}) {
+	if s.err == nil {
+		s.err = writeMessage(s.out, msg)
+	}
+}
+
+func decodeParams(msg *message, params interface{}) *responseError {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return &responseError{invalidParams, err.Error()}
+	}
+	return nil
+}
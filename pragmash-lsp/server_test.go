@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testURI = "file:///tmp/script.pragmash"
+
+func TestMessageFraming(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("content-length: 17\r\nContent-Type: x\r\n\r\n" +
+		`{"method":"a"}   `)
+	if err := writeMessage(&buf, notification{"2.0", "b", 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), "Content-Length: 41\r\n\r\n"+
+		`{"jsonrpc":"2.0","method":"b","params":1}`) {
+		t.Errorf("unexpected framing: %q", buf.String())
+	}
+	in := bufio.NewReader(&buf)
+	for _, method := range []string{"a", "b"} {
+		msg, err := readMessage(in)
+		if err != nil {
+			t.Fatal(err)
+		} else if msg.Method != method {
+			t.Errorf("expected method %q but got %q", method, msg.Method)
+		}
+	}
+	if _, err := readMessage(in); err != io.EOF {
+		t.Error("expected EOF but got", err)
+	}
+
+	in = bufio.NewReader(strings.NewReader("Content-Type: x\r\n\r\n{}"))
+	if _, err := readMessage(in); err == nil ||
+		err.Error() != "missing Content-Length header" {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newTestClient(t)
+	c.open("set x \"abc\n")
+	diags := c.diagnostics()
+	if len(diags) != 1 || diags[0].Severity != severityError ||
+		diags[0].Range.Start.Line != 0 {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]string{"uri": testURI},
+		"contentChanges": []map[string]string{{"text": "puts $y\n"}},
+	})
+	diags = c.diagnostics()
+	if len(diags) != 1 || diags[0].Severity != severityWarning ||
+		diags[0].Message != "variable is never set: y" {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]string{"uri": testURI},
+		"contentChanges": []map[string]string{{"text": "puts hi\n"}},
+	})
+	if diags := c.diagnostics(); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := newTestClient(t)
+	c.open("set x 1\nputs (len $x)\n")
+	c.diagnostics()
+
+	var h hover
+	c.request("textDocument/hover", c.position(1, 7), &h)
+	if !strings.HasPrefix(h.Contents.Value, "```\nlen <string>\n```") {
+		t.Errorf("unexpected hover: %q", h.Contents.Value)
+	} else if h.Range != (textRange{position{1, 6}, position{1, 9}}) {
+		t.Errorf("unexpected range: %+v", h.Range)
+	}
+
+	c.request("textDocument/hover", c.position(1, 11), &h)
+	if h.Contents.Value != "variable `x`, first set on line 1" {
+		t.Errorf("unexpected hover: %q", h.Contents.Value)
+	}
+
+	var res interface{}
+	c.request("textDocument/hover", c.position(0, 6), &res)
+	if res != nil {
+		t.Errorf("expected no hover but got %v", res)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c := newTestClient(t)
+	c.open("set count 1\nputs $c\nputs (le)\n")
+	c.diagnostics()
+
+	var items []completionItem
+	c.request("textDocument/completion", c.position(1, 7), &items)
+	labels := map[string]bool{}
+	for _, item := range items {
+		labels[item.Label] = true
+		if item.Kind != completionVariable {
+			t.Errorf("unexpected kind for %s: %d", item.Label, item.Kind)
+		}
+	}
+	if !labels["count"] || !labels["ARGV"] || labels["len"] {
+		t.Errorf("unexpected variables: %v", labels)
+	}
+
+	c.request("textDocument/completion", c.position(2, 8), &items)
+	var found bool
+	for _, item := range items {
+		if item.Label == "len" {
+			found = true
+			if item.Kind != completionFunction ||
+				item.Detail != "len <string>" {
+				t.Errorf("unexpected item: %+v", item)
+			}
+		}
+	}
+	if !found {
+		t.Error("missing completion for len")
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c := newTestClient(t)
+	c.open("puts start\n  set total 0\nfor i (range 3) {\n" +
+		"  set total (+ $total $i)\n}\n")
+	c.diagnostics()
+
+	var loc location
+	c.request("textDocument/definition", c.position(3, 17), &loc)
+	expected := location{testURI, textRange{position{1, 6},
+		position{1, 11}}}
+	if loc != expected {
+		t.Errorf("expected %+v but got %+v", expected, loc)
+	}
+
+	c.request("textDocument/definition", c.position(3, 23), &loc)
+	expected.Range = textRange{position{2, 4}, position{2, 5}}
+	if loc != expected {
+		t.Errorf("expected %+v but got %+v", expected, loc)
+	}
+}
+
+func TestUnicodeColumns(t *testing.T) {
+	c := newTestClient(t)
+	c.open("for 😀 é (arr a b) {\n}\nset e 1\nputs $😀 $é $e\n")
+	c.diagnostics()
+
+	var loc location
+	c.request("textDocument/definition", c.position(3, 10), &loc)
+	expected := location{testURI, textRange{position{0, 7},
+		position{0, 8}}}
+	if loc != expected {
+		t.Errorf("expected %+v but got %+v", expected, loc)
+	}
+
+	c.request("textDocument/definition", c.position(3, 13), &loc)
+	expected.Range = textRange{position{2, 4}, position{2, 5}}
+	if loc != expected {
+		t.Errorf("expected %+v but got %+v", expected, loc)
+	}
+
+	var h hover
+	c.request("textDocument/hover", c.position(3, 10), &h)
+	if h.Range != (textRange{position{3, 10}, position{3, 11}}) {
+		t.Errorf("unexpected range: %+v", h.Range)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newTestClient(t)
+	c.open("set x 1\nfor i (range 3) {\n  set y $i\n  set x 2\n}\n" +
+		"test works {\n  assert 1\n}\n")
+	c.diagnostics()
+
+	var symbols []*documentSymbol
+	c.request("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+	}, &symbols)
+	if len(symbols) != 3 {
+		t.Fatalf("expected 3 symbols but got %d", len(symbols))
+	}
+	if symbols[0].Name != "x" || symbols[0].Kind != symbolVariable {
+		t.Errorf("unexpected symbol: %+v", symbols[0])
+	}
+	loop := symbols[1]
+	if loop.Name != "for i (range 3)" || loop.Kind != symbolNamespace ||
+		loop.Range.Start.Line != 1 || loop.Range.End.Line != 4 {
+		t.Errorf("unexpected symbol: %+v", loop)
+	} else if len(loop.Children) != 1 || loop.Children[0].Name != "y" {
+		t.Errorf("unexpected children: %+v", loop.Children)
+	}
+	if symbols[2].Name != "test works" || symbols[2].Kind != symbolFunction {
+		t.Errorf("unexpected symbol: %+v", symbols[2])
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	c := newTestClient(t)
+	c.send(message{JSONRPC: "2.0", ID: rawID(7), Method: "bogus"})
+	res := c.read()
+	var id int
+	var respErr responseError
+	json.Unmarshal(res["id"], &id)
+	json.Unmarshal(res["error"], &respErr)
+	if id != 7 || respErr.Code != methodNotFound {
+		t.Errorf("unexpected response: id %d, error %+v", id, respErr)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	shutdown := message{JSONRPC: "2.0", ID: rawID(1), Method: "shutdown"}
+	exit := message{JSONRPC: "2.0", Method: "exit"}
+	unknown := message{JSONRPC: "2.0", ID: rawID(2), Method: "bogus"}
+	cases := []struct {
+		messages []message
+		status   int
+	}{
+		{[]message{shutdown, exit}, 0},
+		{[]message{shutdown}, 0},
+		{[]message{exit, shutdown}, 1},
+		{[]message{unknown}, 1},
+		{nil, 1},
+	}
+	for i, c := range cases {
+		var in, out bytes.Buffer
+		for _, msg := range c.messages {
+			writeMessage(&in, msg)
+		}
+		if status := serve(&in, &out); status != c.status {
+			t.Errorf("case %d: expected status %d but got %d", i, c.status,
+				status)
+		}
+	}
+
+	// Messages after exit are not handled.
+	var in, out bytes.Buffer
+	writeMessage(&in, exit)
+	writeMessage(&in, unknown)
+	serve(&in, &out)
+	if out.Len() != 0 {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+}
+
+// A testClient talks to a server over in-memory pipes, using the same framing
+// as a real client.
+type testClient struct {
+	t      *testing.T
+	in     io.Writer
+	out    *bufio.Reader
+	nextID int
+}
+
+func newTestClient(t *testing.T) *testClient {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	go func() {
+		serve(inReader, outWriter)
+		outWriter.Close()
+	}()
+	t.Cleanup(func() {
+		inWriter.Close()
+		outReader.Close()
+	})
+	return &testClient{t: t, in: inWriter, out: bufio.NewReader(outReader)}
+}
+
+func (c *testClient) send(msg message) {
+	if err := writeMessage(c.in, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) notify(method string, params interface{}) {
+	c.send(message{JSONRPC: "2.0", Method: method, Params: rawJSON(params)})
+}
+
+// request sends a request and decodes the result of its response.
+func (c *testClient) request(method string, params, result interface{}) {
+	c.nextID++
+	c.send(message{JSONRPC: "2.0", ID: rawID(c.nextID), Method: method,
+		Params: rawJSON(params)})
+	res := c.read()
+	var id int
+	if err := json.Unmarshal(res["id"], &id); err != nil || id != c.nextID {
+		c.t.Fatalf("unexpected response to %s: %s", method, res["id"])
+	} else if res["error"] != nil {
+		c.t.Fatalf("error from %s: %s", method, res["error"])
+	}
+	if err := json.Unmarshal(res["result"], result); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read reads the next message from the server.
+func (c *testClient) read() map[string]json.RawMessage {
+	body, err := readBody(c.out)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var res map[string]json.RawMessage
+	if err := json.Unmarshal(body, &res); err != nil {
+		c.t.Fatal(err)
+	}
+	return res
+}
+
+func (c *testClient) open(text string) {
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": textDocumentItem{URI: testURI, Text: text},
+	})
+}
+
+// diagnostics reads the diagnostics which the server publishes for the test
+// document.
+func (c *testClient) diagnostics() []diagnostic {
+	msg := c.read()
+	var method string
+	json.Unmarshal(msg["method"], &method)
+	if method != "textDocument/publishDiagnostics" {
+		c.t.Fatal("expected diagnostics but got", method)
+	}
+	var params struct {
+		URI         string       `json:"uri"`
+		Diagnostics []diagnostic `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(msg["params"], &params); err != nil {
+		c.t.Fatal(err)
+	} else if params.URI != testURI {
+		c.t.Fatal("diagnostics for unexpected document:", params.URI)
+	}
+	return params.Diagnostics
+}
+
+func (c *testClient) position(line, character int) textDocumentPosition {
+	return textDocumentPosition{textDocumentIdentifier{testURI},
+		position{line, character}}
+}
+
+func rawJSON(val interface{}) json.RawMessage {
+	data, _ := json.Marshal(val)
+	return data
+}
+
+func rawID(id int) *json.RawMessage {
+	raw := rawJSON(id)
+	return &raw
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// HistorySize is the number of statements kept in the history file.
+const HistorySize = 1000
+
+// A lineEditor reads statements from the user. On a terminal, it supports
+// cursor movement, history, reverse search, and statements which span
+// several lines. Elsewhere, it reads plain lines.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	fd  int
+
+	// complete returns true if some text is a whole statement, so that
+	// pressing enter runs it instead of starting a new line.
+	complete func(text string) bool
+
+	// completer returns the word which ends with some text and the words
+	// which could replace it when the user presses tab.
+	completer func(text string) (string, []string)
+
+	history     []string
+	historyFile string
+
+	// These describe the statement which is being edited.
+	buf []rune
+	pos int
+
+	// row is the row of the terminal cursor, counted from the first prompt.
+	row int
+
+	// index is the index of the history entry in buf, or len(history) if
+	// the user is not looking at the history. draft saves the new statement
+	// while they are.
+	index int
+	draft []rune
+}
+
+func newLineEditor(in *os.File, out io.Writer, historyFile string,
+	complete func(string) bool,
+	completer func(string) (string, []string)) *lineEditor {
+	res := &lineEditor{
+		in:          bufio.NewReader(in),
+		out:         out,
+		fd:          int(in.Fd()),
+		complete:    complete,
+		completer:   completer,
+		historyFile: historyFile,
+	}
+	res.loadHistory()
+	return res
+}
+
+// ReadStatement reads a statement, which may span several lines. It returns
+// io.EOF if the input ends before a statement starts.
+func (e *lineEditor) ReadStatement() (string, error) {
+	if !isTerminal(e.fd) {
+		return e.readPlain()
+	}
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return e.readPlain()
+	}
+	defer restore()
+	return e.readTerminal()
+}
+
+func (e *lineEditor) readPlain() (string, error) {
+	var lines []string
+	prompt := RegularPrompt
+	for {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+		text := strings.Join(lines, "\n")
+		if e.complete(text) {
+			return text, nil
+		}
+		prompt = ContPrompt
+	}
+}
+
+func (e *lineEditor) readTerminal() (string, error) {
+	e.reset()
+	var key rune
+	for {
+		if key == 0 {
+			var err error
+			if key, _, err = e.in.ReadRune(); err != nil {
+				return "", err
+			}
+		}
+		switch key {
+		case '\t':
+			e.completeWord()
+		case '\r', '\n':
+			text := string(e.buf)
+			if e.complete(text) {
+				e.pos = len(e.buf)
+				e.render()
+				fmt.Fprint(e.out, "\r\n")
+				e.addHistory(text)
+				return text, nil
+			}
+			e.insert('\n')
+		case ctrl('A'):
+			e.pos = e.lineStart()
+		case ctrl('B'):
+			if e.pos > 0 {
+				e.pos--
+			}
+		case ctrl('C'):
+			e.pos = len(e.buf)
+			e.render()
+			fmt.Fprint(e.out, "^C\r\n")
+			e.reset()
+		case ctrl('D'):
+			if len(e.buf) == 0 {
+				return "", io.EOF
+			}
+			e.delete(e.pos, e.pos+1)
+		case ctrl('E'):
+			e.pos = e.lineEnd()
+		case ctrl('F'):
+			if e.pos < len(e.buf) {
+				e.pos++
+			}
+		case ctrl('H'), 127:
+			e.delete(e.pos-1, e.pos)
+		case ctrl('K'):
+			e.delete(e.pos, e.lineEnd())
+		case ctrl('L'):
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+			e.row = 0
+		case ctrl('N'):
+			e.down()
+		case ctrl('P'):
+			e.up()
+		case ctrl('R'):
+			var err error
+			if key, err = e.search(); err != nil {
+				return "", err
+			} else if key != 0 {
+				// The key which ended the search still needs to be handled.
+				continue
+			}
+		case ctrl('U'):
+			e.delete(e.lineStart(), e.pos)
+		case ctrl('W'):
+			start := e.pos
+			for start > 0 && unicode.IsSpace(e.buf[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(e.buf[start-1]) {
+				start--
+			}
+			e.delete(start, e.pos)
+		case 27:
+			if err := e.escape(); err != nil {
+				return "", err
+			}
+		default:
+			if unicode.IsPrint(key) {
+				e.insert(key)
+			}
+		}
+		key = 0
+		e.render()
+	}
+}
+
+// escape handles the escape sequences of the arrow, home, end, and delete
+// keys.
+func (e *lineEditor) escape() error {
+	kind, _, err := e.in.ReadRune()
+	if err != nil {
+		return err
+	} else if kind != '[' && kind != 'O' {
+		return nil
+	}
+	var sequence []rune
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return err
+		}
+		sequence = append(sequence, r)
+		if r >= '@' && r <= '~' {
+			break
+		}
+	}
+	switch string(sequence) {
+	case "A":
+		e.up()
+	case "B":
+		e.down()
+	case "C":
+		if e.pos < len(e.buf) {
+			e.pos++
+		}
+	case "D":
+		if e.pos > 0 {
+			e.pos--
+		}
+	case "H", "1~", "7~":
+		e.pos = e.lineStart()
+	case "F", "4~", "8~":
+		e.pos = e.lineEnd()
+	case "3~":
+		e.delete(e.pos, e.pos+1)
+	}
+	return nil
+}
+
+// search lets the user search the history for a statement which contains
+// what they type, like ctrl-r in a shell. It returns the key which ended the
+// search, or 0 if the key was used up.
+func (e *lineEditor) search() (rune, error) {
+	var query []rune
+	found := len(e.history)
+	failed := false
+	find := func(start int) {
+		for i := start; i >= 0 && i < len(e.history); i-- {
+			if strings.Contains(e.history[i], string(query)) {
+				found, failed = i, false
+				return
+			}
+		}
+		failed = true
+	}
+
+	for {
+		prompt := "(reverse-i-search)`" + string(query) + "': "
+		if failed {
+			prompt = "(failed " + prompt[1:]
+		}
+		var match []rune
+		pos := 0
+		if found < len(e.history) {
+			flat := strings.Replace(e.history[found], "\n", " ", -1)
+			match = []rune(flat)
+			pos = len(match)
+			if idx := strings.Index(flat, string(query)); idx >= 0 {
+				pos = utf8.RuneCountInString(flat[:idx])
+			}
+		}
+		e.draw(prompt, match, pos)
+
+		key, _, err := e.in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case key == ctrl('R'):
+			find(found - 1)
+		case key == ctrl('H') || key == 127:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.history) - 1)
+			}
+		case key == ctrl('C') || key == ctrl('G'):
+			return 0, nil
+		case unicode.IsPrint(key):
+			query = append(query, key)
+			if found == len(e.history) {
+				find(found - 1)
+			} else {
+				find(found)
+			}
+		default:
+			if found < len(e.history) {
+				e.recall(found)
+			}
+			return key, nil
+		}
+	}
+}
+
+// completeWord finishes the word before the cursor as far as it can. If the
+// word could still be finished in more than one way, it lists the options.
+func (e *lineEditor) completeWord() {
+	word, options := e.completer(string(e.buf[:e.pos]))
+	if len(options) == 0 {
+		return
+	}
+	prefix := options[0]
+	for _, option := range options[1:] {
+		for !strings.HasPrefix(option, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	if len(options) == 1 && !strings.HasSuffix(prefix, "/") {
+		prefix += " "
+	}
+	if strings.HasPrefix(prefix, word) && len(prefix) > len(word) {
+		for _, r := range prefix[len(word):] {
+			e.insert(r)
+		}
+		return
+	}
+
+	// Show the options below the statement and then draw it again.
+	names := make([]string, len(options))
+	for i, option := range options {
+		names[i] = option
+		if idx := strings.LastIndex(option[:len(option)-1], "/"); idx >= 0 {
+			names[i] = option[idx+1:]
+		}
+	}
+	pos := e.pos
+	e.pos = len(e.buf)
+	e.render()
+	fmt.Fprint(e.out, "\r\n"+strings.Join(names, "  ")+"\r\n")
+	e.pos, e.row = pos, 0
+}
+
+func (e *lineEditor) up() {
+	start := e.lineStart()
+	if start == 0 {
+		e.recall(e.index - 1)
+		return
+	}
+	column := e.pos - start
+	e.pos = start - 1
+	if prev := e.lineStart(); prev+column < e.pos {
+		e.pos = prev + column
+	}
+}
+
+func (e *lineEditor) down() {
+	end := e.lineEnd()
+	if end == len(e.buf) {
+		e.recall(e.index + 1)
+		return
+	}
+	column := e.pos - e.lineStart()
+	e.pos = end + 1
+	if next := e.lineEnd(); e.pos+column < next {
+		e.pos += column
+	} else {
+		e.pos = next
+	}
+}
+
+// recall shows a history entry, or the new statement if the index is the
+// length of the history.
+func (e *lineEditor) recall(index int) {
+	if index < 0 || index > len(e.history) || index == e.index {
+		return
+	}
+	if e.index == len(e.history) {
+		e.draft = e.buf
+	}
+	e.index = index
+	if index == len(e.history) {
+		e.buf = e.draft
+	} else {
+		e.buf = []rune(e.history[index])
+	}
+	e.pos = len(e.buf)
+}
+
+func (e *lineEditor) reset() {
+	e.buf, e.pos, e.row = nil, 0, 0
+	e.index, e.draft = len(e.history), nil
+	e.render()
+}
+
+func (e *lineEditor) insert(r rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = r
+	e.pos++
+}
+
+// delete removes the runes from start up to end, if they exist.
+func (e *lineEditor) delete(start, end int) {
+	if start < 0 || end > len(e.buf) || start >= end {
+		return
+	}
+	e.buf = append(e.buf[:start:start], e.buf[end:]...)
+	e.pos = start
+}
+
+// lineStart returns the start of the line with the cursor.
+func (e *lineEditor) lineStart() int {
+	start := e.pos
+	for start > 0 && e.buf[start-1] != '\n' {
+		start--
+	}
+	return start
+}
+
+// lineEnd returns the end of the line with the cursor.
+func (e *lineEditor) lineEnd() int {
+	end := e.pos
+	for end < len(e.buf) && e.buf[end] != '\n' {
+		end++
+	}
+	return end
+}
+
+func (e *lineEditor) render() {
+	e.draw(RegularPrompt, e.buf, e.pos)
+}
+
+// draw redraws the statement below the first prompt, starting every line
+// after the first with ContPrompt, and then moves the cursor to pos.
+func (e *lineEditor) draw(prompt string, text []rune, pos int) {
+	var out bytes.Buffer
+	if e.row > 0 {
+		fmt.Fprintf(&out, "\x1b[%dA", e.row)
+	}
+	out.WriteString("\r\x1b[J" + prompt)
+
+	row, column := 0, utf8.RuneCountInString(prompt)
+	cursorRow, cursorColumn := row, column
+	for i, r := range text {
+		if i == pos {
+			cursorRow, cursorColumn = row, column
+		}
+		if r == '\n' {
+			out.WriteString("\r\n" + ContPrompt)
+			row, column = row+1, len(ContPrompt)
+		} else {
+			out.WriteRune(r)
+			column++
+		}
+	}
+	if pos == len(text) {
+		cursorRow, cursorColumn = row, column
+	}
+
+	if row > cursorRow {
+		fmt.Fprintf(&out, "\x1b[%dA", row-cursorRow)
+	}
+	out.WriteString("\r")
+	if cursorColumn > 0 {
+		fmt.Fprintf(&out, "\x1b[%dC", cursorColumn)
+	}
+	e.row = cursorRow
+	e.out.Write(out.Bytes())
+}
+
+// loadHistory reads the history file. If the file has grown too long, the
+// oldest entries are removed from it.
+func (e *lineEditor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, unescapeHistory(line))
+		}
+	}
+	if len(e.history) > HistorySize {
+		e.history = e.history[len(e.history)-HistorySize:]
+		var buf bytes.Buffer
+		for _, entry := range e.history {
+			buf.WriteString(escapeHistory(entry) + "\n")
+		}
+		ioutil.WriteFile(e.historyFile, buf.Bytes(), 0600)
+	}
+}
+
+// addHistory adds a statement to the history and appends it to the history
+// file. Blank statements and repeats of the last statement are skipped.
+func (e *lineEditor) addHistory(text string) {
+	if strings.TrimSpace(text) == "" ||
+		(len(e.history) > 0 && e.history[len(e.history)-1] == text) {
+		return
+	}
+	e.history = append(e.history, text)
+	if e.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyFile,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(escapeHistory(text) + "\n")
+}
+
+// escapeHistory puts a statement on one line of the history file.
+func escapeHistory(text string) string {
+	res := escapeResult(text)
+	return res[1 : len(res)-1]
+}
+
+func unescapeHistory(line string) string {
+	var buf bytes.Buffer
+	escaped := false
+	for _, r := range line {
+		if escaped {
+			if r == 'n' {
+				buf.WriteRune('\n')
+			} else {
+				buf.WriteRune(r)
+			}
+			escaped = false
+		} else if r == '\\' {
+			escaped = true
+		} else {
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+func ctrl(r rune) rune {
+	return r & 0x1f
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHistoryEscaping(t *testing.T) {
+	statements := []string{
+		"puts hi",
+		"if 1 {\n  puts \"a\\nb\"\n}",
+		`puts C:\dir\new`,
+		"trailing \\",
+		"\\\\n\n\\n",
+	}
+	for _, text := range statements {
+		line := escapeHistory(text)
+		if strings.Contains(line, "\n") {
+			t.Errorf("escaped %q has a newline: %q", text, line)
+		} else if res := unescapeHistory(line); res != text {
+			t.Errorf("expected %q but got %q", text, res)
+		}
+	}
+}
+
+func TestHistoryTrimming(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pragmash-repl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+
+	var buf bytes.Buffer
+	for i := 0; i < HistorySize+5; i++ {
+		buf.WriteString(escapeHistory("puts "+strconv.Itoa(i)+"\nx") + "\n")
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &lineEditor{historyFile: path}
+	e.loadHistory()
+	if len(e.history) != HistorySize {
+		t.Fatalf("expected %d entries but got %d", HistorySize,
+			len(e.history))
+	} else if e.history[0] != "puts 5\nx" {
+		t.Errorf("unexpected first entry: %q", e.history[0])
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if n := strings.Count(string(data), "\n"); n != HistorySize {
+		t.Errorf("expected %d lines in the file but got %d", HistorySize, n)
+	}
+
+	// Blank statements and repeats are not added.
+	e.addHistory("puts new")
+	e.addHistory("puts new")
+	e.addHistory("  \n")
+	e = &lineEditor{historyFile: path}
+	e.loadHistory()
+	if len(e.history) != HistorySize {
+		t.Fatalf("expected %d entries but got %d", HistorySize,
+			len(e.history))
+	} else if last := e.history[len(e.history)-1]; last != "puts new" {
+		t.Errorf("unexpected last entry: %q", last)
+	}
+}
+
+func TestCompleteWord(t *testing.T) {
+	tests := []struct {
+		text    string
+		options []string
+		result  string
+		listing string
+	}{
+		{"puts (le", []string{"len"}, "puts (len ", ""},
+		{"ls sr", []string{"src/"}, "ls src/", ""},
+		{"l", []string{"len", "length"}, "len", ""},
+		{"aé", []string{"aéb", "aéc"}, "aé", "aéb  aéc"},
+		{"x", []string{"xaé", "xaè"}, "xa", ""},
+		{"cat a/b", []string{"a/b/x", "a/b/y/"}, "cat a/b/", ""},
+		{"cat a/b/", []string{"a/b/x", "a/b/y/"}, "cat a/b/", "x  y/"},
+		{"nothing", nil, "nothing", ""},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		e := &lineEditor{out: &out, buf: []rune(test.text),
+			pos: len([]rune(test.text))}
+		e.completer = func(text string) (string, []string) {
+			if text != test.text {
+				t.Errorf("unexpected text: %q", text)
+			}
+			fields := strings.Fields(text)
+			word := fields[len(fields)-1]
+			word = word[strings.LastIndex(word, "(")+1:]
+			return word, test.options
+		}
+		e.completeWord()
+		if res := string(e.buf); res != test.result {
+			t.Errorf("%q: expected %q but got %q", test.text, test.result,
+				res)
+		}
+		listed := strings.Contains(out.String(), "\r\n"+test.listing+"\r\n")
+		if test.listing != "" && !listed {
+			t.Errorf("%q: expected options %q in %q", test.text,
+				test.listing, out.String())
+		} else if test.listing == "" && out.Len() > 0 {
+			t.Errorf("%q: unexpected output %q", test.text, out.String())
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"github.com/unixpickle/pragmash"
+	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -37,63 +39,70 @@ func escapeResult(res string) string {
 	return buf.String()
 }
 
+func exitCode(bo *pragmash.Breakout) (int, bool) {
+	if bo == nil {
+		return 0, false
+	}
+	return bo.ExitCode()
+}
+
 func main() {
 	rand.Seed(time.Now().UTC().UnixNano())
 
 	fmt.Println("pragmash version " + pragmash.Version())
 
-	statements := make(chan pragmash.Runnable)
-	errorChan := make(chan error)
-	conts := make(chan struct{})
-	go readInput(statements, errorChan, conts)
-
-	runner := pragmash.NewStdRunner(nil)
-	fmt.Print(RegularPrompt)
+	session := newSession(os.Stdout)
+	editor := newLineEditor(os.Stdin, os.Stdout, historyFile(),
+		session.complete, session.completions)
 	for {
-		select {
-		case statement := <-statements:
-			res, err := statement.Run(runner)
-			if err != nil {
-				fmt.Println(ErrorColor + err.Error().Error() + RegularColor)
-			} else {
-				fmt.Println(OutputColor + escapeResult(res.String()) +
-					RegularColor)
+		text, err := editor.ReadStatement()
+		if err != nil {
+			fmt.Println("")
+			if err != io.EOF {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
 			}
-			fmt.Print(RegularPrompt)
-		case err := <-errorChan:
-			fmt.Println(ErrorColor + err.Error() + RegularColor)
-			fmt.Print(RegularPrompt)
-		case <-conts:
-			fmt.Print(ContPrompt)
+			os.Exit(0)
 		}
+		session.handle(text)
 	}
 }
 
-func readInput(statements chan<- pragmash.Runnable, errorChan chan<- error,
-	conts chan<- struct{}) {
-	scanner := bufio.NewScanner(os.Stdin)
+// historyFile returns the path of the file which stores the history of
+// statements, or "" if there is no home directory.
+func historyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pragmash_history")
+}
+
+// scanStatement parses the text of a statement. The second return value is
+// false if the statement needs more lines. The statement is nil if the text
+// is blank.
+func scanStatement(text string) (pragmash.Runnable, bool, error) {
 	tokenizer := pragmash.NewTokenizer()
 	semantic := pragmash.NewSingleScanner()
-	for scanner.Scan() {
-		line, err := tokenizer.Line(scanner.Text())
+	started := false
+	for _, lineStr := range strings.Split(text, "\n") {
+		// Comments are not passed to the tokenizer.
+		if strings.HasPrefix(strings.TrimSpace(lineStr), "#") {
+			continue
+		}
+		line, err := tokenizer.Line(lineStr)
 		if err != nil {
-			errorChan <- err
-			tokenizer = pragmash.NewTokenizer()
-			semantic = pragmash.NewSingleScanner()
-		} else if line != nil {
-			stmt, err := semantic.Line(*line, "REPL")
-			if err != nil {
-				semantic = pragmash.NewSingleScanner()
-				errorChan <- err
-			} else if stmt != nil {
-				statements <- stmt
-			} else {
-				conts <- struct{}{}
-			}
-		} else {
-			conts <- struct{}{}
+			return nil, true, err
+		} else if line == nil {
+			continue
+		}
+		stmt, err := semantic.Line(*line, "REPL")
+		if err != nil {
+			return nil, true, err
+		} else if stmt != nil {
+			return stmt, true, nil
 		}
+		started = started || !line.Blank()
 	}
-	fmt.Println("")
-	os.Exit(0)
+	return nil, tokenizer.Done() && !started, nil
 }
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestScanStatement(t *testing.T) {
+	tests := []struct {
+		text     string
+		complete bool
+		blank    bool
+	}{
+		{"", true, true},
+		{"# just a comment", true, true},
+		{"puts hi", true, false},
+		{"puts \\", false, false},
+		{"puts \\\n  hi", true, false},
+		{"if 1 {", false, false},
+		{"if 1 {\n  # comment\n  puts {\n", false, false},
+		{"if 1 {\n  puts hi\n}", true, false},
+		{"try {\n  throw x\n}", true, false},
+		{"try {\n  throw x\n} catch e {", false, false},
+		{"try {\n  throw x\n} catch e {\n}", true, false},
+	}
+	for _, test := range tests {
+		statement, complete, err := scanStatement(test.text)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+		} else if complete != test.complete {
+			t.Errorf("%q: expected complete to be %v", test.text,
+				test.complete)
+		} else if complete && (statement == nil) != test.blank {
+			t.Errorf("%q: unexpected statement %v", test.text, statement)
+		}
+	}
+
+	// Strings and nested commands cannot span lines.
+	for _, text := range []string{"}", "puts \"hi", "puts (len"} {
+		if _, complete, err := scanStatement(text); err == nil || !complete {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/unixpickle/pragmash"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MetaCommands lists the commands which start with a colon. They control the
+// REPL instead of running code.
+var MetaCommands = []string{":help", ":load", ":reset", ":time", ":trace",
+	":vars"}
+
+const metaHelp = `:help           list the meta-commands and the commands
+:help command   show the documentation of a command
+:load file      run a script with the REPL's variables
+:reset          forget every variable
+:time statement run a statement and show how long it took
+:trace on|off   print every command as it runs
+:vars           show every variable`
+
+// A session holds the variables of the REPL and prints the results of
+// statements to out.
+type session struct {
+	out     io.Writer
+	std     pragmash.Runner
+	tracing bool
+}
+
+func newSession(out io.Writer) *session {
+	res := &session{out: out}
+	res.reset()
+	return res
+}
+
+// reset replaces the runner so that every variable is forgotten. Tracing
+// stays on if it was on.
+func (s *session) reset() {
+	s.std = pragmash.NewStdRunner(nil)
+}
+
+// complete returns true if some text is a whole statement or meta-command.
+func (s *session) complete(text string) bool {
+	if strings.HasPrefix(text, ":time ") {
+		text = strings.TrimPrefix(text, ":time ")
+	} else if strings.HasPrefix(text, ":") {
+		return true
+	}
+	_, complete, _ := scanStatement(text)
+	return complete
+}
+
+// handle runs a statement or a meta-command and prints the result.
+func (s *session) handle(text string) {
+	if strings.HasPrefix(text, ":") {
+		if err := s.meta(text); err != nil {
+			fmt.Fprintln(s.out, ErrorColor+err.Error()+RegularColor)
+		}
+		return
+	}
+	statement, _, err := scanStatement(text)
+	if err != nil {
+		fmt.Fprintln(s.out, ErrorColor+err.Error()+RegularColor)
+	} else if statement != nil {
+		s.run(statement)
+	}
+}
+
+// run runs a statement and prints its result. The REPL exits if the statement
+// calls exit.
+//
+// An interrupt signal stops the statement at the next command, and commands
+// like sleep and http_get stop right away. The variables are kept.
+func (s *session) run(statement pragmash.Runnable) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var runner pragmash.Runner = pragmash.NewBudgetRunner(ctx, s.std, 0)
+	var tracer *pragmash.Tracer
+	if s.tracing || pragmash.MayTrace(statement) {
+		// The tracer has to wrap the budget runner so it can see every
+		// command.
+		tracer = pragmash.NewTracer(runner, os.Stderr)
+		tracer.SetEnabled(s.tracing)
+		runner = tracer
+	}
+	res, bo := statement.Run(runner)
+	if tracer != nil {
+		s.tracing = tracer.Enabled()
+	}
+
+	if code, ok := exitCode(bo); ok {
+		os.Exit(code)
+	} else if bo != nil && ctx.Err() != nil {
+		fmt.Fprintln(s.out, ErrorColor+"interrupted"+RegularColor)
+	} else if bo != nil {
+		fmt.Fprintln(s.out, ErrorColor+bo.Error().Error()+RegularColor)
+	} else {
+		fmt.Fprintln(s.out, OutputColor+escapeResult(res.String())+
+			RegularColor)
+	}
+}
+
+func (s *session) meta(text string) error {
+	fields := strings.Fields(text)
+	name, args := fields[0], fields[1:]
+	switch name {
+	case ":help":
+		if len(args) == 0 {
+			fmt.Fprintln(s.out, metaHelp)
+			fmt.Fprintln(s.out)
+			list, err := s.std.RunCommand("help", []*pragmash.Value{})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(s.out, list.String())
+			return nil
+		} else if len(args) > 1 {
+			return errors.New("usage: :help [command]")
+		}
+		doc, ok := pragmash.StdCommandDoc(args[0])
+		if !ok {
+			return errors.New("unknown command: " + args[0])
+		}
+		fmt.Fprintln(s.out, doc.Help())
+	case ":load":
+		if len(args) != 1 {
+			return errors.New("usage: :load file")
+		}
+		// The script runs like exec, so it can set the REPL's variables.
+		s.run(pragmash.CommandRunnable{
+			Arguments: []pragmash.Runnable{pragmash.NewValueString(args[0])},
+			Context:   "REPL",
+			Name:      pragmash.NewValueString("exec"),
+		})
+	case ":reset":
+		s.reset()
+	case ":time":
+		code := strings.TrimSpace(strings.TrimPrefix(text, ":time"))
+		statement, complete, err := scanStatement(code)
+		if err != nil {
+			return err
+		} else if statement == nil || !complete {
+			return errors.New("usage: :time statement")
+		}
+		start := time.Now()
+		s.run(statement)
+		fmt.Fprintln(s.out, OutputColor+"took "+time.Since(start).String()+
+			RegularColor)
+	case ":trace":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return errors.New("usage: :trace on|off")
+		}
+		s.tracing = args[0] == "on"
+	case ":vars":
+		for _, name := range s.variableNames() {
+			value := s.std.(pragmash.Resolver).Variable(name).Value
+			fmt.Fprintln(s.out, name+" = "+escapeResult(value.String()))
+		}
+	default:
+		return errors.New("unknown meta-command: " + name +
+			" (try :help)")
+	}
+	return nil
+}
+
+func (s *session) variableNames() []string {
+	if lister, ok := s.std.(interface {
+		VariableNames() []string
+	}); ok {
+		return lister.VariableNames()
+	}
+	return nil
+}
+
+// completions returns the word at the end of some text and the words which
+// could replace it: meta-commands, commands, variables, or file paths.
+func (s *session) completions(text string) (string, []string) {
+	start := strings.LastIndexAny(text, " \t\n()\"") + 1
+	word, before := text[start:], text[:start]
+
+	var options []string
+	if strings.HasPrefix(text, ":") {
+		if !strings.ContainsAny(text, " \t") {
+			options = MetaCommands
+		} else if fields := strings.Fields(before); len(fields) > 1 {
+			// Only the first argument of a meta-command is completed.
+			return word, nil
+		} else {
+			switch fields[0] {
+			case ":help":
+				options = pragmash.StdCommands()
+			case ":time":
+				return s.completions(strings.TrimLeft(text[5:], " \t"))
+			case ":trace":
+				options = []string{"off", "on"}
+			default:
+				return word, completePath(word)
+			}
+		}
+	} else if strings.HasPrefix(word, "$") {
+		for _, name := range s.variableNames() {
+			options = append(options, "$"+name)
+		}
+	} else if trimmed := strings.TrimRight(before, " \t"); trimmed == "" ||
+		strings.HasSuffix(trimmed, "(") || strings.HasSuffix(trimmed, "\n") {
+		options = pragmash.StdCommands()
+	} else {
+		return word, completePath(word)
+	}
+
+	var res []string
+	for _, option := range options {
+		if strings.HasPrefix(option, word) {
+			res = append(res, option)
+		}
+	}
+	return word, res
+}
+
+// completePath returns the files and directories which start with a path.
+// Directories end with a slash.
+func completePath(path string) []string {
+	dir, base := filepath.Split(path)
+	listDir := dir
+	if listDir == "" {
+		listDir = "."
+	}
+	listing, err := ioutil.ReadDir(listDir)
+	if err != nil {
+		return nil
+	}
+	var res []string
+	for _, info := range listing {
+		name := info.Name()
+		if !strings.HasPrefix(name, base) ||
+			(strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if info.IsDir() {
+			name += "/"
+		}
+		res = append(res, dir+name)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSessionComplete(t *testing.T) {
+	s := newSession(ioutil.Discard)
+	complete := []string{"", "puts hi", "if 1 {\n}", ":vars", ":bogus",
+		":time puts hi"}
+	incomplete := []string{"puts \\", "if 1 {", ":time if 1 {"}
+	for _, text := range complete {
+		if !s.complete(text) {
+			t.Errorf("%q should be complete", text)
+		}
+	}
+	for _, text := range incomplete {
+		if s.complete(text) {
+			t.Errorf("%q should not be complete", text)
+		}
+	}
+}
+
+func TestMetaCommands(t *testing.T) {
+	var out bytes.Buffer
+	s := newSession(&out)
+	run := func(text string) string {
+		out.Reset()
+		s.handle(text)
+		return out.String()
+	}
+
+	expected := OutputColor + `""` + RegularColor + "\n"
+	if res := run("set x \"a\\nb\""); res != expected {
+		t.Errorf("expected %q but got %q", expected, res)
+	}
+	if res := run(":vars"); !strings.Contains(res, `x = "a\nb"`) {
+		t.Errorf("unexpected variables: %q", res)
+	}
+	if res := run(":reset"); res != "" {
+		t.Errorf("unexpected output: %q", res)
+	} else if res := run(":vars"); strings.Contains(res, "x = ") {
+		t.Errorf("variable survived reset: %q", res)
+	}
+
+	if res := run(":help"); !strings.HasPrefix(res, metaHelp+"\n\n") ||
+		!strings.Contains(res, "len") {
+		t.Errorf("unexpected help: %q", res)
+	}
+	if res := run(":help len"); !strings.Contains(res, "len <string>") {
+		t.Errorf("unexpected help: %q", res)
+	}
+
+	if res := run(":trace on"); res != "" || !s.tracing {
+		t.Errorf("tracing was not enabled: %q", res)
+	}
+	if res := run(":trace off"); res != "" || s.tracing {
+		t.Errorf("tracing was not disabled: %q", res)
+	}
+	if run("trace on"); !s.tracing {
+		t.Error("tracing was not enabled by the trace command")
+	}
+	if run("trace off"); s.tracing {
+		t.Error("tracing was not disabled by the trace command")
+	}
+
+	if res := run(":time + 1 2"); !strings.Contains(res, `"3"`) ||
+		!strings.Contains(res, "took ") {
+		t.Errorf("unexpected timing: %q", res)
+	}
+
+	dir, err := ioutil.TempDir("", "pragmash-repl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "script.pragmash")
+	if err := ioutil.WriteFile(script, []byte("set loaded yes\n"),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	run(":load " + script)
+	if res := run(":vars"); !strings.Contains(res, `loaded = "yes"`) {
+		t.Errorf("script did not set a variable: %q", res)
+	}
+
+	failures := map[string]string{
+		":help a b":   "usage: :help [command]",
+		":help bogus": "unknown command: bogus",
+		":load":       "usage: :load file",
+		":time":       "usage: :time statement",
+		":trace":      "usage: :trace on|off",
+		":bogus":      "unknown meta-command: :bogus (try :help)",
+	}
+	for text, message := range failures {
+		expected := ErrorColor + message + RegularColor + "\n"
+		if res := run(text); res != expected {
+			t.Errorf("%s: expected %q but got %q", text, expected, res)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !freebsd && !linux && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+// isTerminal always returns false, so the REPL reads plain lines.
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw mode is not supported on this platform")
+}
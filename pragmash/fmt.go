@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/unixpickle/pragmash"
+	"io/ioutil"
+	"os"
+)
+
+// runFmt implements "pragmash fmt". It returns the exit code.
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the files instead "+
+		"of standard output")
+	list := flags.Bool("l", false, "list the files whose formatting differs")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pragmash fmt [flags] [FILE ...]\n\n"+
+			"Formats scripts, or standard input if no files are given.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		contents, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		formatted, err := pragmash.Format(string(contents))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "<stdin>:", err)
+			return 1
+		}
+		os.Stdout.WriteString(formatted)
+		return 0
+	}
+
+	code := 0
+	for _, path := range flags.Args() {
+		if err := formatFile(path, *write, *list); err != nil {
+			fmt.Fprintln(os.Stderr, path+":", err)
+			code = 1
+		}
+	}
+	return code
+}
+
+func formatFile(path string, write, list bool) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	formatted, err := pragmash.Format(string(contents))
+	if err != nil {
+		return err
+	}
+	changed := formatted != string(contents)
+	if list && changed {
+		fmt.Println(path)
+	}
+	if write {
+		if changed {
+			return ioutil.WriteFile(path, []byte(formatted), 0644)
+		}
+	} else if !list {
+		os.Stdout.WriteString(formatted)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/unixpickle/pragmash"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// runLint implements "pragmash lint". It returns the exit code.
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pragmash lint FILE ...\n\n"+
+			"Reports likely mistakes in scripts without running them.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1
+	}
+
+	code := 0
+	for _, path := range flags.Args() {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			continue
+		}
+		issues, err := pragmash.Lint(string(contents))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, path+":", err)
+			code = 1
+			continue
+		}
+		for _, issue := range issues {
+			fmt.Printf("%s:%s: %s\n", path,
+				strings.TrimPrefix(issue.Context, "line "), issue.Message)
+			code = 1
+		}
+	}
+	return code
+}
@@ -2,29 +2,171 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"flag"
 	"fmt"
 	"github.com/unixpickle/pragmash"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintln(os.Stderr, "pragmash version " + pragmash.Version() +
-			"\nUsage: pragmash <script.pragmash> [ARGS]")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			os.Exit(runFmt(os.Args[2:]))
+		case "lint":
+			os.Exit(runLint(os.Args[2:]))
+		case "test":
+			os.Exit(runTests(os.Args[2:]))
+		}
+	}
+
+	output := flag.String("c", "", "compile the script to a bytecode file")
+	timeout := flag.Duration("timeout", 0, "abort the script after a duration")
+	maxCommands := flag.Int("max-commands", 0,
+		"abort the script after running this many commands and loop "+
+			"iterations")
+	sandbox := flag.Bool("sandbox", false,
+		"only allow the commands permitted by -allow, -paths, and -hosts")
+	allow := flag.String("allow", "", "comma-separated command groups to "+
+		"allow in the sandbox (fs-read,fs-write,network,process,time)")
+	paths := flag.String("paths", "",
+		"comma-separated directories the sandbox may access")
+	hosts := flag.String("hosts", "",
+		"comma-separated hosts the sandbox may access")
+	trace := flag.Bool("x", false, "print every command as it runs")
+	profile := flag.String("profile", "", "write a pprof profile to a file "+
+		"and print a report of the slowest lines and commands")
+	cover := flag.String("cover", "", "write statement and branch coverage "+
+		"to a file (lcov, or HTML if the name ends with .html)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "pragmash version "+pragmash.Version()+
+			"\nUsage: pragmash [flags] <script> [ARGS]"+
+			"\n       pragmash [flags] debug <script> [ARGS]"+
+			"\n       pragmash test [flags] [PATH ...]"+
+			"\n       pragmash fmt [flags] [FILE ...]"+
+			"\n       pragmash lint FILE ...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	debug := len(args) > 0 && args[0] == "debug"
+	if debug {
+		args = args[1:]
+	}
+	if len(args) < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
+	script := args[0]
 
 	rand.Seed(time.Now().UTC().UnixNano())
 
-	contents, err := ioutil.ReadFile(os.Args[1])
+	contents, err := ioutil.ReadFile(script)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to read file:", err)
 		os.Exit(1)
 	}
 
+	argv := make([]*pragmash.Value, len(args)-1)
+	for i, arg := range args[1:] {
+		argv[i] = pragmash.NewValueString(arg)
+	}
+
+	var runnable pragmash.Runnable
+	if pragmash.IsBytecode(contents) {
+		runnable = readBytecode(contents)
+	} else {
+		runnable = readSource(contents)
+		if *output != "" {
+			writeBytecode(runnable, *output)
+			return
+		}
+	}
+
+	variables := pragmash.CreateStandardVariables(script, argv)
+	var runner pragmash.Runner = pragmash.NewStdRunner(variables)
+	if *sandbox {
+		policy := pragmash.SandboxPolicy{Allow: map[string]bool{},
+			Paths: splitList(*paths), Hosts: splitList(*hosts)}
+		for _, group := range splitList(*allow) {
+			policy.Allow[group] = true
+		}
+		runner = pragmash.NewSandboxRunner(runner, policy)
+	}
+	if *timeout != 0 || *maxCommands != 0 {
+		ctx := context.Background()
+		if *timeout != 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		runner = pragmash.NewBudgetRunner(ctx, runner, *maxCommands)
+	}
+	var coverage *pragmash.Coverage
+	if *cover != "" {
+		coverage = pragmash.NewCoverage()
+		runner = pragmash.NewCoverageRunner(runner, coverage)
+	}
+	var profiler *pragmash.Profiler
+	if *profile != "" {
+		profiler = pragmash.NewProfiler(runner, script)
+		runner = profiler
+	}
+	if *trace || pragmash.MayTrace(runnable) {
+		// Only add a Tracer when it may be used, since it keeps Compile from
+		// evaluating commands ahead of time.
+		tracer := pragmash.NewTracer(runner, os.Stderr)
+		tracer.SetEnabled(*trace)
+		runner = tracer
+	}
+	if debug {
+		runner = pragmash.NewDebugger(runner, script, os.Stdin, os.Stderr)
+	}
+
+	if !pragmash.IsBytecode(contents) {
+		runnable = pragmash.Compile(runnable, runner)
+		if coverage != nil {
+			runnable = coverage.Instrument(runnable, script)
+		}
+	}
+
+	_, bo := runnable.Run(runner)
+	if profiler != nil {
+		writeProfile(profiler, *profile)
+	}
+	if coverage != nil {
+		writeCoverage(coverage, *cover)
+	}
+	if bo != nil {
+		if code, ok := bo.ExitCode(); ok {
+			os.Exit(code)
+		}
+		kind := "exception"
+		if bo.Type() == pragmash.BreakoutTypeAbort {
+			kind = "aborted"
+		}
+		fmt.Fprintln(os.Stderr, kind+" at "+bo.Context()+": "+
+			bo.Error().Error())
+		os.Exit(1)
+	}
+}
+
+func readBytecode(contents []byte) pragmash.Runnable {
+	program, err := pragmash.DecodeProgram(bytes.NewReader(contents))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load bytecode:", err)
+		os.Exit(1)
+	}
+	return program
+}
+
+func readSource(contents []byte) pragmash.Runnable {
 	lines, contexts, err := pragmash.TokenizeString(string(contents))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to tokenize file:", err)
@@ -36,17 +178,64 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Failed to process file:", err)
 		os.Exit(1)
 	}
+	return runnable
+}
 
-	argv := make([]*pragmash.Value, len(os.Args)-2)
-	for i := 2; i < len(os.Args); i++ {
-		argv[i-2] = pragmash.NewValueString(os.Args[i])
+func writeBytecode(runnable pragmash.Runnable, path string) {
+	program, err := pragmash.CompileBytecode(runnable)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to compile file:", err)
+		os.Exit(1)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create output:", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	if err := program.Encode(f); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write bytecode:", err)
+		os.Exit(1)
 	}
-	variables := pragmash.CreateStandardVariables(os.Args[1], argv)
-	runner := pragmash.NewStdRunner(variables)
+}
 
-	if _, bo := runnable.Run(runner); bo != nil {
-		fmt.Fprintln(os.Stderr, "exception at "+bo.Context()+": "+
-			bo.Error().Error())
+func writeCoverage(coverage *pragmash.Coverage, path string) {
+	fmt.Fprintf(os.Stderr, "coverage: %.1f%% of statements\n",
+		coverage.Percent())
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create coverage file:", err)
 		os.Exit(1)
 	}
+	defer f.Close()
+	if strings.HasSuffix(path, ".html") {
+		err = coverage.WriteHTML(f)
+	} else {
+		err = coverage.WriteLcov(f)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write coverage:", err)
+		os.Exit(1)
+	}
+}
+
+func writeProfile(profiler *pragmash.Profiler, path string) {
+	profiler.WriteReport(os.Stderr)
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create profile:", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	if err := profiler.WritePprof(f); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write profile:", err)
+		os.Exit(1)
+	}
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
 }
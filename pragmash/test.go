@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"github.com/unixpickle/pragmash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// runTests implements "pragmash test". It returns the exit code.
+func runTests(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	format := flags.String("format", "text",
+		"the format of the results (text, tap, or junit)")
+	parallel := flags.Int("parallel", runtime.NumCPU(),
+		"the number of test files to run at once")
+	run := flags.String("run", "", "only run the tests which match a regexp")
+	update := flags.Bool("update", false,
+		"write the output of each test file to its .stdout file")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pragmash test [flags] [PATH ...]\n\n"+
+			"Runs every *_test.pragmash file in the given files and "+
+			"directories.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var options pragmash.TestOptions
+	options.Update = *update
+	if *run != "" {
+		filter, err := regexp.Compile(*run)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -run pattern:", err)
+			return 1
+		}
+		options.Filter = filter
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := findTestFiles(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	results := runTestFiles(files, options, *parallel)
+	switch *format {
+	case "text":
+		writeTestText(os.Stdout, results)
+	case "tap":
+		writeTestTAP(os.Stdout, results)
+	case "junit":
+		if err := writeTestJUnit(os.Stdout, results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown format:", *format)
+		return 1
+	}
+
+	for _, x := range results {
+		if x.Err != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+// findTestFiles finds the test scripts in a list of files and directories.
+func findTestFiles(paths []string) ([]string, error) {
+	var res []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			res = append(res, path)
+			continue
+		}
+		err = filepath.Walk(path, func(p string, info os.FileInfo,
+			err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(p, "_test.pragmash") {
+				res = append(res, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// runTestFiles runs test files in parallel and returns their results in the
+// order of the files.
+func runTestFiles(files []string, options pragmash.TestOptions,
+	parallel int) []*pragmash.TestResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+	fileResults := make([][]*pragmash.TestResult, len(files))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				fileResults[idx] = pragmash.RunTestFile(files[idx], options)
+			}
+		}()
+	}
+	for i := range files {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	var res []*pragmash.TestResult
+	for _, x := range fileResults {
+		res = append(res, x...)
+	}
+	return res
+}
+
+func testName(t *pragmash.TestResult) string {
+	if t.Name == "" {
+		return t.File
+	}
+	return t.File + ": " + t.Name
+}
+
+func testMessage(t *pragmash.TestResult) string {
+	if t.Context == "" {
+		return t.Err.Error()
+	}
+	return t.Context + ": " + t.Err.Error()
+}
+
+func writeTestText(w io.Writer, results []*pragmash.TestResult) {
+	var passed, failed, skipped int
+	for _, x := range results {
+		if x.Skipped {
+			skipped++
+			continue
+		} else if x.Err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s (%.3fs)\n     %s\n", testName(x),
+				x.Duration.Seconds(), testMessage(x))
+		} else {
+			passed++
+			fmt.Fprintf(w, "ok   %s (%.3fs)\n", testName(x),
+				x.Duration.Seconds())
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n", passed, failed,
+		skipped)
+}
+
+func writeTestTAP(w io.Writer, results []*pragmash.TestResult) {
+	fmt.Fprintf(w, "TAP version 13\n1..%d\n", len(results))
+	for i, x := range results {
+		if x.Skipped {
+			fmt.Fprintf(w, "ok %d - %s # SKIP\n", i+1, testName(x))
+		} else if x.Err != nil {
+			fmt.Fprintf(w, "not ok %d - %s\n", i+1, testName(x))
+			fmt.Fprintf(w, "  ---\n  message: %q\n  ...\n", testMessage(x))
+		} else {
+			fmt.Fprintf(w, "ok %d - %s\n", i+1, testName(x))
+		}
+	}
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func writeTestJUnit(w io.Writer, results []*pragmash.TestResult) error {
+	var suites junitSuites
+	suiteIndices := map[string]int{}
+	suiteTimes := map[string]float64{}
+	for _, x := range results {
+		idx, ok := suiteIndices[x.File]
+		if !ok {
+			idx = len(suites.Suites)
+			suiteIndices[x.File] = idx
+			suites.Suites = append(suites.Suites, junitSuite{Name: x.File})
+		}
+		suite := &suites.Suites[idx]
+		suite.Tests++
+		suiteTimes[x.File] += x.Duration.Seconds()
+
+		name := x.Name
+		if name == "" {
+			name = filepath.Base(x.File)
+		}
+		c := junitCase{Name: name, ClassName: x.File,
+			Time: fmt.Sprintf("%.3f", x.Duration.Seconds())}
+		if x.Skipped {
+			suite.Skipped++
+			c.Skipped = &struct{}{}
+		} else if x.Err != nil {
+			suite.Failures++
+			c.Failure = &junitFailure{x.Err.Error(), testMessage(x)}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	for i := range suites.Suites {
+		suite := &suites.Suites[i]
+		suite.Time = fmt.Sprintf("%.3f", suiteTimes[suite.Name])
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,420 @@
+package pragmash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Profiler is a Runner which records how often each source line and each
+// command runs and how long they take.
+//
+// A Profiler must wrap every Runner other than another Observer so that it can
+// observe commands. Events are passed on to the wrapped Runner if it is an
+// Observer as well.
+type Profiler struct {
+	forwardingRunner
+	data *profileData
+}
+
+// A ProfileStat is the time spent on a source line or a command.
+//
+// For a line, Time includes every command which the line runs. For a command,
+// Time is the cumulative time spent in the command, including commands which
+// it runs itself, and Flat excludes them. The time spent evaluating arguments
+// is never included in the time of a command.
+type ProfileStat struct {
+	Name  string
+	Calls int64
+	Time  time.Duration
+	Flat  time.Duration
+}
+
+type profileData struct {
+	script   string
+	start    time.Time
+	stack    []profileFrame
+	lines    map[string]*ProfileStat
+	commands map[string]*ProfileStat
+
+	functions map[profileFunction]uint64
+	locations map[profileLocation]uint64
+	samples   map[string]*profileSample
+}
+
+type profileFrame struct {
+	context   string
+	name      string
+	called    bool
+	newLine   bool
+	start     time.Time
+	callStart time.Time
+	children  time.Duration
+	location  uint64
+}
+
+type profileFunction struct {
+	name string
+	file string
+}
+
+type profileLocation struct {
+	function uint64
+	line     int
+}
+
+type profileSample struct {
+	locations []uint64
+	calls     int64
+	time      time.Duration
+}
+
+// NewProfiler wraps a Runner with a Profiler. The script is the path of the
+// main script, which is used as the file name in pprof output. The total time
+// in reports is measured from when the Profiler is created.
+func NewProfiler(r Runner, script string) *Profiler {
+	res := &Profiler{forwardingRunner{wrappedRunner{r}}, &profileData{
+		script:    script,
+		start:     time.Now(),
+		lines:     map[string]*ProfileStat{},
+		commands:  map[string]*ProfileStat{},
+		functions: map[profileFunction]uint64{},
+		locations: map[profileLocation]uint64{},
+		samples:   map[string]*profileSample{},
+	}}
+	res.SetOuter(res)
+	return res
+}
+
+// EnterCommand starts timing a command.
+func (p *Profiler) EnterCommand(context string) {
+	if o, ok := p.inner.(Observer); ok {
+		o.EnterCommand(context)
+	}
+	stack := p.data.stack
+	newLine := len(stack) == 0 || stack[len(stack)-1].context != context
+	p.data.stack = append(stack, profileFrame{context: context,
+		newLine: newLine, start: time.Now()})
+}
+
+// CallCommand starts timing the command itself, now that its arguments have
+// been evaluated.
+func (p *Profiler) CallCommand(context, name string, args []*Value) {
+	if o, ok := p.inner.(Observer); ok {
+		o.CallCommand(context, name, args)
+	}
+	if len(p.data.stack) == 0 {
+		return
+	}
+	frame := &p.data.stack[len(p.data.stack)-1]
+	frame.name = name
+	frame.called = true
+	frame.children = 0
+	frame.location = p.data.location(name, context)
+	frame.callStart = time.Now()
+}
+
+// ExitCommand records the time spent on a command.
+func (p *Profiler) ExitCommand(context, name string, args []*Value,
+	res *Value, bo *Breakout) {
+	if o, ok := p.inner.(Observer); ok {
+		o.ExitCommand(context, name, args, res, bo)
+	}
+	if len(p.data.stack) > 0 {
+		p.data.exit(time.Now())
+	}
+}
+
+// Lines returns the statistics for each source line, sorted by time.
+func (p *Profiler) Lines() []*ProfileStat {
+	return sortProfileStats(p.data.lines, false)
+}
+
+// Commands returns the statistics for each command, sorted by flat time.
+func (p *Profiler) Commands() []*ProfileStat {
+	return sortProfileStats(p.data.commands, true)
+}
+
+// WriteReport writes tables of the lines and the commands which took the most
+// time.
+func (p *Profiler) WriteReport(w io.Writer) error {
+	total := time.Since(p.data.start)
+	commands := p.Commands()
+	var flat time.Duration
+	for _, x := range commands {
+		flat += x.Flat
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Total time: %s (commands %s, interpreter %s)\n\n",
+		roundDuration(total), roundDuration(flat),
+		roundDuration(total-flat))
+	fmt.Fprintf(&buf, "%10s %12s %7s  %s\n", "calls", "time", "%", "line")
+	for _, x := range p.Lines() {
+		fmt.Fprintf(&buf, "%10d %12s %6.2f%%  %s\n", x.Calls,
+			roundDuration(x.Time), percent(x.Time, total), x.Name)
+	}
+	fmt.Fprintf(&buf, "\n%10s %12s %7s %12s  %s\n", "calls", "flat", "%",
+		"cum", "command")
+	for _, x := range commands {
+		fmt.Fprintf(&buf, "%10d %12s %6.2f%% %12s  %s\n", x.Calls,
+			roundDuration(x.Flat), percent(x.Flat, total),
+			roundDuration(x.Time), x.Name)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WritePprof writes the profile in the gzipped protocol buffer format which is
+// read by pprof. Each command is a function in the file that contains it.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	strs := newProfileStrings()
+	var msg protoBuffer
+
+	for _, t := range [][2]string{{"calls", "count"},
+		{"time", "nanoseconds"}} {
+		var valueType protoBuffer
+		valueType.intField(1, strs.index(t[0]))
+		valueType.intField(2, strs.index(t[1]))
+		msg.bytesField(1, valueType.Bytes())
+	}
+
+	keys := make([]string, 0, len(p.data.samples))
+	for key := range p.data.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := p.data.samples[key]
+		var sample protoBuffer
+		sample.packedField(1, s.locations)
+		sample.packedField(2, []uint64{uint64(s.calls), uint64(s.time)})
+		msg.bytesField(2, sample.Bytes())
+	}
+
+	locations := make([]profileLocation, len(p.data.locations))
+	for loc, id := range p.data.locations {
+		locations[id-1] = loc
+	}
+	for i, loc := range locations {
+		var line, location protoBuffer
+		line.intField(1, int64(loc.function))
+		line.intField(2, int64(loc.line))
+		location.intField(1, int64(i+1))
+		location.bytesField(4, line.Bytes())
+		msg.bytesField(4, location.Bytes())
+	}
+
+	functions := make([]profileFunction, len(p.data.functions))
+	for fn, id := range p.data.functions {
+		functions[id-1] = fn
+	}
+	for i, fn := range functions {
+		var function protoBuffer
+		function.intField(1, int64(i+1))
+		function.intField(2, strs.index(fn.name))
+		function.intField(3, strs.index(fn.name))
+		function.intField(4, strs.index(fn.file))
+		msg.bytesField(5, function.Bytes())
+	}
+
+	timeIndex := strs.index("time")
+	for _, str := range strs.list {
+		msg.bytesField(6, []byte(str))
+	}
+	msg.intField(9, p.data.start.UnixNano())
+	msg.intField(10, int64(time.Since(p.data.start)))
+	msg.intField(14, timeIndex)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// inherit profiles a separate script started with the pragmash command.
+// Its commands are recorded as though they were run by the command.
+func (p *Profiler) inherit(r Runner) Runner {
+	res := &Profiler{forwardingRunner{wrappedRunner{r}}, p.data}
+	res.SetOuter(res)
+	return res
+}
+
+// exit pops the innermost frame and records its time.
+func (d *profileData) exit(now time.Time) {
+	frame := d.stack[len(d.stack)-1]
+	d.stack = d.stack[:len(d.stack)-1]
+	elapsed := now.Sub(frame.start)
+	if len(d.stack) > 0 {
+		d.stack[len(d.stack)-1].children += elapsed
+	}
+
+	// Time spent in recursive lines and commands is only counted once.
+	lineRecursive, commandRecursive := false, false
+	for _, x := range d.stack {
+		lineRecursive = lineRecursive || (x.newLine &&
+			x.context == frame.context)
+		commandRecursive = commandRecursive || (x.called &&
+			x.name == frame.name)
+	}
+
+	if frame.newLine {
+		stat := d.stat(d.lines, frame.context)
+		stat.Calls++
+		if !lineRecursive {
+			stat.Time += elapsed
+		}
+	}
+	if !frame.called {
+		return
+	}
+	callTime := now.Sub(frame.callStart)
+	flat := callTime - frame.children
+	stat := d.stat(d.commands, frame.name)
+	stat.Calls++
+	stat.Flat += flat
+	if !commandRecursive {
+		stat.Time += callTime
+	}
+
+	locations := []uint64{frame.location}
+	for i := len(d.stack) - 1; i >= 0; i-- {
+		if d.stack[i].called {
+			locations = append(locations, d.stack[i].location)
+		}
+	}
+	keyParts := make([]string, len(locations))
+	for i, x := range locations {
+		keyParts[i] = strconv.FormatUint(x, 10)
+	}
+	key := strings.Join(keyParts, ",")
+	sample, ok := d.samples[key]
+	if !ok {
+		sample = &profileSample{locations: locations}
+		d.samples[key] = sample
+	}
+	sample.calls++
+	sample.time += flat
+}
+
+// location returns the ID of the pprof location for a command.
+func (d *profileData) location(name, context string) uint64 {
+	file, line, _ := parseContext(context)
+	if file == "" {
+		file = d.script
+	}
+	fn := profileFunction{name, file}
+	fnID, ok := d.functions[fn]
+	if !ok {
+		fnID = uint64(len(d.functions) + 1)
+		d.functions[fn] = fnID
+	}
+	loc := profileLocation{fnID, line}
+	id, ok := d.locations[loc]
+	if !ok {
+		id = uint64(len(d.locations) + 1)
+		d.locations[loc] = id
+	}
+	return id
+}
+
+func (d *profileData) stat(m map[string]*ProfileStat,
+	name string) *ProfileStat {
+	if stat, ok := m[name]; ok {
+		return stat
+	}
+	stat := &ProfileStat{Name: name}
+	m[name] = stat
+	return stat
+}
+
+type profileStrings struct {
+	list    []string
+	indices map[string]int64
+}
+
+func newProfileStrings() *profileStrings {
+	return &profileStrings{[]string{""}, map[string]int64{"": 0}}
+}
+
+func (p *profileStrings) index(s string) int64 {
+	if idx, ok := p.indices[s]; ok {
+		return idx
+	}
+	idx := int64(len(p.list))
+	p.list = append(p.list, s)
+	p.indices[s] = idx
+	return idx
+}
+
+// A protoBuffer encodes the parts of the protocol buffer format which are
+// needed for pprof profiles.
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (p *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		p.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	p.WriteByte(byte(x))
+}
+
+func (p *protoBuffer) intField(field int, x int64) {
+	if x == 0 {
+		return
+	}
+	p.varint(uint64(field) << 3)
+	p.varint(uint64(x))
+}
+
+func (p *protoBuffer) bytesField(field int, data []byte) {
+	p.varint(uint64(field)<<3 | 2)
+	p.varint(uint64(len(data)))
+	p.Write(data)
+}
+
+func (p *protoBuffer) packedField(field int, xs []uint64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	p.bytesField(field, packed.Bytes())
+}
+
+func percent(d, total time.Duration) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return 100 * float64(d) / float64(total)
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+func sortProfileStats(m map[string]*ProfileStat, flat bool) []*ProfileStat {
+	res := make([]*ProfileStat, 0, len(m))
+	for _, x := range m {
+		stat := *x
+		res = append(res, &stat)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		ti, tj := res[i].Time, res[j].Time
+		if flat {
+			ti, tj = res[i].Flat, res[j].Flat
+		}
+		if ti != tj {
+			return ti > tj
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
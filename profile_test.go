@@ -0,0 +1,65 @@
+package pragmash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	script := "set s 0\nfor i (range 10) {\nset s (+ $s $i)\n}\n" +
+		"try {\nthrow (+ 1 1)\n} catch e {\n}\n"
+	for _, mode := range runModes {
+		profiler := NewProfiler(NewStdRunner(nil), "test.pragmash")
+		if bo, err := runSource(script, profiler, mode); err != nil {
+			t.Fatal(err)
+		} else if bo != nil {
+			t.Fatal("unexpected breakout:", bo.Error())
+		}
+
+		lineCalls := map[string]int64{}
+		for _, x := range profiler.Lines() {
+			lineCalls[x.Name] = x.Calls
+		}
+		expectedLines := map[string]int64{"line 1": 1, "line 2": 1,
+			"line 3": 10, "line 6": 1}
+		for name, calls := range expectedLines {
+			if lineCalls[name] != calls {
+				t.Errorf("%s: expected %d calls but got %d (%s)", name,
+					calls, lineCalls[name], modeNames[mode])
+			}
+		}
+
+		commandCalls := map[string]int64{}
+		for _, x := range profiler.Commands() {
+			commandCalls[x.Name] = x.Calls
+			if x.Flat > x.Time {
+				t.Errorf("%s: flat time exceeds cumulative time", x.Name)
+			}
+		}
+		expectedCommands := map[string]int64{"+": 11, "get": 20, "set": 11,
+			"range": 1, "throw": 1}
+		for name, calls := range expectedCommands {
+			if commandCalls[name] != calls {
+				t.Errorf("%s: expected %d calls but got %d (%s)", name,
+					calls, commandCalls[name], modeNames[mode])
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := profiler.WritePprof(&buf); err != nil {
+			t.Fatal(err)
+		}
+		r, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Contains(data, []byte("test.pragmash")) {
+			t.Error("profile is missing the file name")
+		}
+	}
+}
@@ -3,6 +3,7 @@ package pragmash
 import (
 	"errors"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -26,63 +27,105 @@ var (
 
 // A ReflectRunner implements a RunCommand() function that uses reflection.
 type ReflectRunner struct {
+	commands  map[string]CommandFunc
+	outer     Runner
+	pure      map[string]bool
 	rewrite   map[string]string
 	value     reflect.Value
-	variables map[string]*Value
+	variables map[string]*Variable
 }
 
 // NewReflectRunner creates a new ReflectRunner.
 func NewReflectRunner(val interface{}, rw map[string]string) *ReflectRunner {
-	return &ReflectRunner{rw, reflect.ValueOf(val), map[string]*Value{}}
+	return &ReflectRunner{map[string]CommandFunc{}, nil, map[string]bool{}, rw,
+		reflect.ValueOf(val), map[string]*Variable{}}
 }
 
-// RunCommand puts the name through the alias table if possible.
-// It then capitalizes the first letter of the name and looks for a
-// corresponding method.
-// This will execute a special subroutine for the set and get commands.
-func (r *ReflectRunner) RunCommand(name string, vals []*Value) (*Value, error) {
+// ResolveCommand looks up a command the same way RunCommand does and returns
+// a function which runs it. The result is cached, so repeated lookups of the
+// same name are cheap.
+// The second return value is true if the command's method is listed in the
+// runner's table of pure commands.
+func (r *ReflectRunner) ResolveCommand(name string) (CommandFunc, bool) {
 	if name == "get" {
-		return r.getCommand(vals)
+		return r.getCommand, false
 	} else if name == "set" {
-		return r.setCommand(vals)
+		return r.setCommand, false
+	} else if len(name) == 0 {
+		return nil, false
 	}
 
-	// Lookup the method.
 	n := r.RewriteName(name)
+	if fn, ok := r.commands[n]; ok {
+		return fn, r.pure[n]
+	}
+
+	// Lookup the method.
 	method := r.value.MethodByName(n)
 	if !method.IsValid() {
-		return nil, errors.New("unknown command: " + name)
+		return nil, false
 	}
 	t := method.Type()
+	fn := func(vals []*Value) (*Value, error) {
+		// Generate the arguments.
+		args, err := r.arguments(t, vals)
+		if err != nil {
+			return nil, err
+		}
 
-	// Generate the arguments.
-	args, err := r.arguments(t, vals)
-	if err != nil {
-		return nil, err
+		// Run the call and process the return value.
+		res := method.Call(args)
+		return reflectReturnValue(res)
 	}
+	r.commands[n] = fn
+	return fn, r.pure[n]
+}
 
-	// Run the call and process the return value.
-	res := method.Call(args)
-	return reflectReturnValue(res)
+// RunCommand puts the name through the alias table if possible.
+// It then capitalizes the first letter of the name and looks for a
+// corresponding method.
+// This will execute a special subroutine for the set and get commands.
+func (r *ReflectRunner) RunCommand(name string, vals []*Value) (*Value, error) {
+	fn, _ := r.ResolveCommand(name)
+	if fn == nil {
+		return nil, errors.New("unknown command: " + name)
+	}
+	return fn(vals)
 }
 
 // RewriteName uses the ReflectRunner's rewrite table to rewrite a given command
 // name. If no rewrite rule is found, underscores are replaced with camel case.
 func (r *ReflectRunner) RewriteName(name string) string {
-	if r.rewrite != nil {
-		if n, ok := r.rewrite[name]; ok {
-			name = n
-		}
+	return RewriteCommandName(r.rewrite, name)
+}
+
+// SetOuter sets the Runner which is passed to methods that take a Runner
+// argument. By default, the ReflectRunner passes itself.
+func (r *ReflectRunner) SetOuter(outer Runner) {
+	r.outer = outer
+}
+
+// Variable returns the slot for a named variable, creating an undefined one if
+// necessary. The slot remains valid for the lifetime of the runner.
+func (r *ReflectRunner) Variable(name string) *Variable {
+	if v, ok := r.variables[name]; ok {
+		return v
 	}
-	// Capitalize the first letter.
-	name = strings.ToUpper(name[:1]) + name[1:]
-	// Replace "a_b" with "aB"
-	for i := 1; i < len(name)-1; i++ {
-		if name[i] == '_' {
-			name = name[:i] + strings.ToUpper(name[i+1:i+2]) + name[i+2:]
+	v := &Variable{Name: name}
+	r.variables[name] = v
+	return v
+}
+
+// VariableNames returns the sorted names of every defined variable.
+func (r *ReflectRunner) VariableNames() []string {
+	res := make([]string, 0, len(r.variables))
+	for name, v := range r.variables {
+		if v.Value != nil {
+			res = append(res, name)
 		}
 	}
-	return name
+	sort.Strings(res)
+	return res
 }
 
 func (r *ReflectRunner) arguments(t reflect.Type,
@@ -107,7 +150,11 @@ func (r *ReflectRunner) arguments(t reflect.Type,
 
 		// If the argument is a Runner, no value is associated with it.
 		if argType == runnerType {
-			res = append(res, reflect.ValueOf(r))
+			if r.outer != nil {
+				res = append(res, reflect.ValueOf(r.outer))
+			} else {
+				res = append(res, reflect.ValueOf(r))
+			}
 			printArgs--
 			continue
 		} else if valIdx == len(vals) {
@@ -146,22 +193,46 @@ func (r *ReflectRunner) getCommand(vals []*Value) (*Value, error) {
 	if len(vals) != 1 {
 		return nil, errors.New("expected 1 argument")
 	}
+
+	// Reading an unknown variable should not leave a slot behind.
 	name := vals[0].String()
 	if v, ok := r.variables[name]; ok {
-		return v, nil
-	} else {
-		return nil, errors.New("variable undefined: " + name)
+		return v.Get()
 	}
+	return (&Variable{Name: name}).Get()
 }
 
 func (r *ReflectRunner) setCommand(vals []*Value) (*Value, error) {
 	if len(vals) != 2 {
 		return nil, errors.New("expected 2 arguments")
 	}
-	r.variables[vals[0].String()] = vals[1]
+	r.Variable(vals[0].String()).Value = vals[1]
 	return emptyValue, nil
 }
 
+// RewriteCommandName converts a command name into the name of the method which
+// implements it, using a rewrite table such as OperatorRewrites. If no rewrite
+// rule is found, underscores are replaced with camel case.
+func RewriteCommandName(rewrite map[string]string, name string) string {
+	if rewrite != nil {
+		if n, ok := rewrite[name]; ok {
+			name = n
+		}
+	}
+	if len(name) == 0 {
+		return name
+	}
+	// Capitalize the first letter.
+	name = strings.ToUpper(name[:1]) + name[1:]
+	// Replace "a_b" with "aB"
+	for i := 1; i < len(name)-1; i++ {
+		if name[i] == '_' {
+			name = name[:i] + strings.ToUpper(name[i+1:i+2]) + name[i+2:]
+		}
+	}
+	return name
+}
+
 func argumentsError(variadic bool, count int) error {
 	// If it's variadic, we add "at least" to the error message.
 	if variadic {
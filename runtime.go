@@ -1,5 +1,11 @@
 package pragmash
 
+import (
+	"context"
+	"io"
+	"os"
+)
+
 // CommandRunnable is a runnable which executes a command.
 type CommandRunnable struct {
 	Context   string
@@ -9,23 +15,44 @@ type CommandRunnable struct {
 
 // Run evaluates every argument, then executes the named command.
 func (c CommandRunnable) Run(r Runner) (*Value, *Breakout) {
-	name, exc := c.Name.Run(r)
-	if exc != nil {
-		return nil, exc
+	observer, _ := r.(Observer)
+	if observer != nil {
+		observer.EnterCommand(c.Context)
+	}
+	name, args, bo := c.evaluate(r)
+	if bo != nil {
+		if observer != nil {
+			observer.ExitCommand(c.Context, "", nil, nil, bo)
+		}
+		return nil, bo
+	}
+	if observer != nil {
+		observer.CallCommand(c.Context, name, args)
+	}
+	val, err := r.RunCommand(name, args)
+	if err != nil {
+		val, bo = nil, NewBreakoutException(c.Context, err)
+	}
+	if observer != nil {
+		observer.ExitCommand(c.Context, name, args, val, bo)
+	}
+	return val, bo
+}
+
+func (c CommandRunnable) evaluate(r Runner) (string, []*Value, *Breakout) {
+	name, bo := c.Name.Run(r)
+	if bo != nil {
+		return "", nil, bo
 	}
 	args := make([]*Value, len(c.Arguments))
 	for i, x := range c.Arguments {
 		val, bo := x.Run(r)
 		if bo != nil {
-			return nil, bo
+			return "", nil, bo
 		}
 		args[i] = val
 	}
-	val, err := r.RunCommand(name.String(), args)
-	if err != nil {
-		return nil, NewBreakoutException(c.Context, err)
-	}
-	return val, nil
+	return name.String(), args, nil
 }
 
 // A Runnable is a generic interface which can execute on a given Runner and
@@ -55,3 +82,97 @@ func (r RunnableList) Run(runner Runner) (*Value, *Breakout) {
 type Runner interface {
 	RunCommand(name string, args []*Value) (*Value, error)
 }
+
+// A ContextRunner is a Runner which carries a context. Long-running commands
+// and loops stop once the context is done.
+type ContextRunner interface {
+	Runner
+	Context() context.Context
+}
+
+// An Observer is a Runner which is told about every command as it runs.
+// Only the Runner which is passed to Run is checked for this interface, so an
+// Observer should wrap every other Runner.
+type Observer interface {
+	Runner
+
+	// EnterCommand is called before the name and arguments of a command are
+	// evaluated.
+	EnterCommand(context string)
+
+	// CallCommand is called once the arguments are evaluated, just before the
+	// command runs.
+	CallCommand(context, name string, args []*Value)
+
+	// ExitCommand is called once for each call to EnterCommand, after the
+	// command finishes or fails. If a breakout happened while the arguments
+	// were evaluated, name is empty and args is nil.
+	ExitCommand(context, name string, args []*Value, res *Value,
+		bo *Breakout)
+}
+
+// An OuterRunner is a Runner which can be wrapped by another Runner.
+// Commands which call back into the interpreter, such as eval and exec, are
+// given the outermost Runner so that every wrapper sees the commands they run.
+type OuterRunner interface {
+	Runner
+	SetOuter(outer Runner)
+}
+
+// A WrapperRunner is a Runner which forwards commands to another Runner.
+type WrapperRunner interface {
+	Runner
+	Unwrap() Runner
+}
+
+// An OutputRunner is a Runner which redirects the output of commands such as
+// puts and print.
+type OutputRunner interface {
+	Runner
+	Stdout() io.Writer
+}
+
+// RunnerContext returns the context of the first ContextRunner in a chain of
+// WrapperRunners, or context.Background() if there is none.
+func RunnerContext(r Runner) context.Context {
+	for r != nil {
+		if c, ok := r.(ContextRunner); ok {
+			return c.Context()
+		}
+		w, ok := r.(WrapperRunner)
+		if !ok {
+			break
+		}
+		r = w.Unwrap()
+	}
+	return context.Background()
+}
+
+// RunnerStdout returns the output of the first OutputRunner in a chain of
+// WrapperRunners, or os.Stdout if there is none.
+func RunnerStdout(r Runner) io.Writer {
+	for r != nil {
+		if o, ok := r.(OutputRunner); ok {
+			return o.Stdout()
+		}
+		w, ok := r.(WrapperRunner)
+		if !ok {
+			break
+		}
+		r = w.Unwrap()
+	}
+	return os.Stdout
+}
+
+// checkRunner is called for each iteration of a loop. It counts the iteration
+// against the budget of a Runner and returns an abort breakout if the budget
+// is spent or the Runner's context is done.
+func checkRunner(r Runner, context string) *Breakout {
+	if err := stepBudgets(r); err != nil {
+		return NewBreakoutException(context, err)
+	}
+	if err := RunnerContext(r).Err(); err != nil {
+		return NewBreakoutException(context, &AbortError{err})
+	}
+	return nil
+}
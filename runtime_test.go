@@ -2,15 +2,95 @@ package pragmash
 
 import (
 	"strconv"
+	"strings"
 	"testing"
 )
 
+func TestExit(t *testing.T) {
+	script := "try {\neval \"exit 3\"\n} catch e {\n}\nset x 1\n"
+	for _, mode := range runModes {
+		bo, err := runSource(script, NewStdRunner(nil), mode)
+		if err != nil {
+			t.Fatal(err)
+		} else if bo == nil {
+			t.Fatal("expected breakout")
+		} else if code, ok := bo.ExitCode(); !ok || code != 3 {
+			t.Error("unexpected exit code:", code, ok)
+		}
+	}
+}
+
+func TestUndefinedVariable(t *testing.T) {
+	script := "set n missing\ntry {\nget $n\n} catch e {\n}\n"
+	for _, mode := range runModes {
+		runner := NewStdRunner(nil).(*ReflectRunner)
+		if bo, err := runSource(script, runner, mode); err != nil {
+			t.Fatal(err)
+		} else if bo != nil {
+			t.Fatal("unexpected breakout:", bo.Error())
+		}
+		if _, ok := runner.variables["missing"]; ok {
+			t.Errorf("%s: reading created a variable", modeNames[mode])
+		}
+		names := strings.Join(runner.VariableNames(), " ")
+		if names != "e n" {
+			t.Errorf("%s: unexpected variables: %s", modeNames[mode], names)
+		}
+	}
+}
+
+func TestKeptArguments(t *testing.T) {
+	// Runners may keep the arguments of a command after it returns.
+	script := "set x (+ 1 2)\nset y (+ $x 4)\nset z (* $y $y)\n"
+	for _, mode := range runModes {
+		var kept [][]*Value
+		hooks := Hooks(func(name string, args []*Value) {
+			kept = append(kept, args)
+		}, nil)
+		runner := NewMiddlewareRunner(NewStdRunner(nil), hooks)
+		if bo, err := runSource(script, runner, mode); err != nil {
+			t.Fatal(err)
+		} else if bo != nil {
+			t.Fatal("unexpected breakout:", bo.Error())
+		}
+		var calls []string
+		for _, args := range kept {
+			var strs []string
+			for _, arg := range args {
+				strs = append(strs, arg.String())
+			}
+			calls = append(calls, strings.Join(strs, " "))
+		}
+		expected := "1 2,x 3,x,3 4,y 7,y,y,7 7,z 49"
+		if res := strings.Join(calls, ","); res != expected {
+			t.Errorf("unexpected %s arguments: %s", modeNames[mode], res)
+		}
+	}
+}
+
 func BenchmarkNumericLoop(b *testing.B) {
 	// Generate a script which loops b.N times.
 	nString := strconv.Itoa(b.N)
 	script := "set x 0\nwhile (< $x " + nString + ") {\n" +
 		"set x (+ $x 1)\n}"
-	runBenchmarkScript(script)
+	runBenchmarkScript(script, false)
+}
+
+func BenchmarkCompiledNumericLoop(b *testing.B) {
+	nString := strconv.Itoa(b.N)
+	script := "set x 0\nwhile (< $x " + nString + ") {\n" +
+		"set x (+ $x 1)\n}"
+	runBenchmarkScript(script, true)
+}
+
+func BenchmarkBytecodeNumericLoop(b *testing.B) {
+	nString := strconv.Itoa(b.N)
+	script := "set x 0\nwhile (< $x " + nString + ") {\n" +
+		"set x (+ $x 1)\n}"
+	lines, contexts, _ := TokenizeString(script)
+	runnable, _ := ScanAll(lines, contexts)
+	program, _ := CompileBytecode(runnable)
+	program.Run(NewStdRunner(map[string]*Value{}))
 }
 
 func BenchmarkSummation(b *testing.B) {
@@ -18,12 +98,15 @@ func BenchmarkSummation(b *testing.B) {
 	nString := strconv.Itoa(b.N)
 	script := "set x (range " + nString + ")\n" +
 		"set sum 0\nfor y $x {\nset sum (+ $sum $y)\n}"
-	runBenchmarkScript(script)
+	runBenchmarkScript(script, false)
 }
 
-func runBenchmarkScript(script string) {
+func runBenchmarkScript(script string, compile bool) {
 	lines, contexts, _ := TokenizeString(script)
 	runnable, _ := ScanAll(lines, contexts)
 	runner := NewStdRunner(map[string]*Value{})
+	if compile {
+		runnable = Compile(runnable, runner)
+	}
 	runnable.Run(runner)
 }
@@ -0,0 +1,313 @@
+package pragmash
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// These are the groups of commands which a SandboxPolicy can allow.
+const (
+	GroupFsRead  = "fs-read"
+	GroupFsWrite = "fs-write"
+	GroupNetwork = "network"
+	GroupProcess = "process"
+	GroupTime    = "time"
+)
+
+// A sandboxRule describes what a command needs from a SandboxPolicy.
+type sandboxRule struct {
+	group string
+
+	// paths lists the indices of arguments which are file paths.
+	// If allPaths is set, every argument is a path.
+	paths    []int
+	allPaths bool
+
+	// urls lists the indices of arguments which are URLs.
+	urls []int
+}
+
+// sandboxRules maps the methods of StdAll to the permissions they need.
+// Commands which are not listed here are always allowed. The hosts of HTTP
+// requests are checked as the requests are sent, once the base URL of the
+// session has been applied.
+var sandboxRules = map[string]sandboxRule{
+	"Cmd":             {group: GroupProcess},
+	"Exec":            {group: GroupFsRead, paths: []int{0}},
+	"Exists":          {group: GroupFsRead, paths: []int{0}},
+	"Exit":            {group: GroupProcess},
+	"Filetype":        {group: GroupFsRead, paths: []int{0}},
+	"Glob":            {group: GroupFsRead, allPaths: true},
+	"HttpBaseUrl":     {group: GroupNetwork},
+	"HttpCookiesOff":  {group: GroupNetwork},
+	"HttpCookiesOn":   {group: GroupNetwork, paths: []int{0}},
+	"HttpDelete":      {group: GroupNetwork},
+	"HttpDownload":    {group: GroupNetwork, paths: []int{1}},
+	"HttpGet":         {group: GroupNetwork},
+	"HttpHead":        {group: GroupNetwork},
+	"HttpHeader":      {group: GroupNetwork},
+	"HttpPatch":       {group: GroupNetwork},
+	"HttpPost":        {group: GroupNetwork},
+	"HttpProxy":       {group: GroupNetwork, urls: []int{0}},
+	"HttpPut":         {group: GroupNetwork},
+	"HttpRedirects":   {group: GroupNetwork},
+	"HttpReplyHeader": {group: GroupNetwork},
+	"HttpReplyStatus": {group: GroupNetwork},
+	"HttpRequest":     {group: GroupNetwork},
+	"HttpRoute":       {group: GroupNetwork},
+	"HttpServe":       {group: GroupNetwork},
+	"HttpStop":        {group: GroupNetwork},
+	"HttpStrict":      {group: GroupNetwork},
+	"HttpTimeout":     {group: GroupNetwork},
+	"HttpTlsCa":       {group: GroupNetwork, paths: []int{0}},
+	"HttpTlsCert":     {group: GroupNetwork, allPaths: true},
+	"HttpTlsVerify":   {group: GroupNetwork},
+	"HttpWait":        {group: GroupNetwork},
+	"Mkdir":           {group: GroupFsWrite, paths: []int{0}},
+	"Pragmash":        {group: GroupFsRead, paths: []int{0}},
+	"Rm":              {group: GroupFsWrite, paths: []int{0}},
+	"Rmall":           {group: GroupFsWrite, paths: []int{0}},
+	"Sleep":           {group: GroupTime},
+	"Time":            {group: GroupTime},
+	"Touch":           {group: GroupFsWrite, allPaths: true},
+	"Write":           {group: GroupFsWrite, paths: []int{0}},
+}
+
+// A SandboxPolicy decides which commands a SandboxRunner allows.
+type SandboxPolicy struct {
+	// Allow contains the command groups which a script may use, such as
+	// GroupFsRead or GroupNetwork.
+	Allow map[string]bool
+
+	// Paths restricts filesystem commands to files inside these directories.
+	// If it is empty, any path may be used.
+	Paths []string
+
+	// Hosts restricts network commands to these hosts. A host of the form
+	// "*.example.com" matches every subdomain of example.com. If it is empty,
+	// any host may be used, and servers may listen on any address. Otherwise,
+	// servers may only listen on loopback addresses.
+	Hosts []string
+}
+
+// CheckHost returns an error if the policy does not allow requests to the host
+// of a URL.
+func (p *SandboxPolicy) CheckHost(rawURL string) error {
+	if !p.Allow[GroupNetwork] {
+		return errors.New("sandbox: network access is not allowed")
+	}
+	if len(p.Hosts) == 0 {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range p.Hosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed {
+			return nil
+		} else if strings.HasPrefix(allowed, "*.") &&
+			strings.HasSuffix(host, allowed[1:]) {
+			return nil
+		}
+	}
+	return errors.New("sandbox: host is not allowed: " + host)
+}
+
+// CheckListen returns an error if the policy does not allow a server to
+// listen on an address such as "127.0.0.1:8080". If Hosts is set, only
+// loopback addresses are allowed, so that the server cannot be reached from
+// other machines.
+func (p *SandboxPolicy) CheckListen(addr string) error {
+	if !p.Allow[GroupNetwork] {
+		return errors.New("sandbox: network access is not allowed")
+	}
+	if len(p.Hosts) == 0 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(host) == "localhost" {
+		return nil
+	} else if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return errors.New("sandbox: may only listen on a loopback address: " +
+		addr)
+}
+
+// CheckPath returns an error if the policy does not allow access to a path.
+// Relative paths are resolved against the working directory and symbolic
+// links are followed.
+func (p *SandboxPolicy) CheckPath(path string) error {
+	if len(p.Paths) == 0 {
+		return nil
+	}
+	resolved, err := resolveSandboxPath(path)
+	if err != nil {
+		return err
+	}
+	for _, prefix := range p.Paths {
+		dir, err := resolveSandboxPath(prefix)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(dir, resolved)
+		if err == nil && rel != ".." &&
+			!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return errors.New("sandbox: path is not allowed: " + path)
+}
+
+// sandboxFileRules lists the network commands which also use files, and the
+// permissions which they need for those files. Both rules must pass.
+var sandboxFileRules = map[string]sandboxRule{
+	"HttpCookiesOn": {group: GroupFsWrite, paths: []int{0}},
+	"HttpDownload":  {group: GroupFsWrite, paths: []int{1}},
+	"HttpTlsCa":     {group: GroupFsRead, paths: []int{0}},
+	"HttpTlsCert":   {group: GroupFsRead, allPaths: true},
+}
+
+// A SandboxRunner only runs the commands which a SandboxPolicy allows.
+// Denied commands fail with an error which scripts can catch.
+type SandboxRunner struct {
+	wrappedRunner
+	policy SandboxPolicy
+}
+
+// NewSandboxRunner wraps a Runner with a SandboxPolicy.
+func NewSandboxRunner(r Runner, policy SandboxPolicy) *SandboxRunner {
+	res := &SandboxRunner{wrappedRunner{r}, policy}
+	res.SetOuter(res)
+	return res
+}
+
+// Context returns the context of the wrapped Runner, marked so that HTTP
+// requests which redirect to other hosts are checked against the policy.
+func (s *SandboxRunner) Context() context.Context {
+	return context.WithValue(RunnerContext(s.inner), sandboxKey{}, s)
+}
+
+// RunCommand runs a command with the wrapped Runner if the policy allows it.
+func (s *SandboxRunner) RunCommand(name string, args []*Value) (*Value,
+	error) {
+	if err := s.check(name, args); err != nil {
+		return nil, err
+	}
+	return s.inner.RunCommand(name, args)
+}
+
+func (s *SandboxRunner) check(name string, args []*Value) error {
+	methodName := RewriteCommandName(OperatorRewrites, name)
+
+	// The read command may read either a file or a URL.
+	if methodName == "Read" && len(args) > 0 {
+		resource := args[0].String()
+		if strings.HasPrefix(resource, "http://") ||
+			strings.HasPrefix(resource, "https://") {
+			return s.policy.CheckHost(resource)
+		}
+		return s.checkRule(name, sandboxRule{group: GroupFsRead,
+			paths: []int{0}}, args)
+	}
+
+	// A server may only listen where the policy allows.
+	if methodName == "HttpServe" && len(args) > 0 {
+		if err := s.policy.CheckListen(args[0].String()); err != nil {
+			return err
+		}
+	}
+
+	// Some network commands read or write files as well.
+	if rule, ok := sandboxFileRules[methodName]; ok {
+		if err := s.checkRule(name, rule, args); err != nil {
+			return err
+		}
+	}
+
+	rule, ok := sandboxRules[methodName]
+	if !ok {
+		return nil
+	}
+	return s.checkRule(name, rule, args)
+}
+
+func (s *SandboxRunner) checkRule(name string, rule sandboxRule,
+	args []*Value) error {
+	if !s.policy.Allow[rule.group] {
+		return errors.New("sandbox: " + name + " requires the " +
+			rule.group + " permission")
+	}
+	for i, arg := range args {
+		isPath := rule.allPaths
+		for _, idx := range rule.paths {
+			isPath = isPath || idx == i
+		}
+		if isPath {
+			if err := s.policy.CheckPath(arg.String()); err != nil {
+				return err
+			}
+		}
+		for _, idx := range rule.urls {
+			if idx == i {
+				if err := s.policy.CheckHost(arg.String()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// inherit applies the same policy to a Runner for another script.
+func (s *SandboxRunner) inherit(r Runner) Runner {
+	return NewSandboxRunner(r, s.policy)
+}
+
+// sandboxKey is the context key for the SandboxRunner which made a request.
+type sandboxKey struct{}
+
+// checkSandboxHost returns an error if a request was made by a sandboxed
+// script which may not access the host of the request.
+func checkSandboxHost(req *http.Request) error {
+	if s, ok := req.Context().Value(sandboxKey{}).(*SandboxRunner); ok {
+		return s.policy.CheckHost(req.URL.String())
+	}
+	return nil
+}
+
+// resolveSandboxPath makes a path absolute and resolves symbolic links in the
+// longest part of it which exists.
+func resolveSandboxPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return filepath.Join(abs, rest), nil
+		}
+		rest = filepath.Join(filepath.Base(abs), rest)
+		abs = parent
+	}
+}
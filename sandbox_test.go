@@ -0,0 +1,79 @@
+package pragmash
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSandboxGroups(t *testing.T) {
+	policy := SandboxPolicy{Allow: map[string]bool{GroupTime: true}}
+	runner := NewSandboxRunner(NewStdRunner(nil), policy)
+	cases := map[string]string{
+		"sleep 0\ntry {\ncmd ls\n} catch e {\nreturn $e\n}\n": "sandbox: " +
+			"cmd requires the process permission",
+
+		// Commands run through call and eval are checked as well.
+		"try {\neval \"call write (arr a b)\"\n} catch e {\n" +
+			"return $e\n}\n": "line 1 in eval: sandbox: write requires " +
+			"the fs-write permission",
+	}
+	testSourceResults(t, runner, cases)
+}
+
+func TestSandboxPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	allowed := filepath.Join(dir, "allowed")
+	if err := os.Mkdir(allowed, 0755); err != nil {
+		t.Fatal(err)
+	}
+	policy := SandboxPolicy{
+		Allow: map[string]bool{GroupFsRead: true, GroupFsWrite: true},
+		Paths: []string{allowed},
+	}
+	runner := NewSandboxRunner(NewStdRunner(map[string]*Value{
+		"DIR": NewValueString(dir),
+	}), policy)
+
+	cases := map[string]string{
+		"write (path $DIR allowed x) hey\n" +
+			"return (read (path $DIR allowed x))": "hey",
+	}
+	for _, p := range []string{"x", "allowed/../x", "allowedx"} {
+		cases["try {\nwrite (path $DIR "+p+") hey\n} catch e {\n"+
+			"return denied\n}\nreturn allowed"] = "denied"
+	}
+
+	// A symbolic link should not let a script escape the sandbox.
+	if err := os.Symlink(dir, filepath.Join(allowed, "link")); err == nil {
+		cases["try {\nread (path $DIR allowed link allowed x)\n"+
+			"write (path $DIR allowed link y) hey\n} catch e {\n"+
+			"return denied\n}\nreturn allowed"] = "denied"
+	}
+	testSourceResults(t, runner, cases)
+}
+
+func TestSandboxHosts(t *testing.T) {
+	policy := SandboxPolicy{
+		Allow: map[string]bool{GroupNetwork: true},
+		Hosts: []string{"example.com", "*.example.org"},
+	}
+	allowed := []string{"http://example.com/", "https://a.b.example.org/x"}
+	denied := []string{"http://example.net/", "http://badexample.org",
+		"http://example.com.evil.net/"}
+	for _, u := range allowed {
+		if err := policy.CheckHost(u); err != nil {
+			t.Error("host should be allowed:", u)
+		}
+	}
+	for _, u := range denied {
+		if err := policy.CheckHost(u); err == nil {
+			t.Error("host should be denied:", u)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package pragmash
 
 import (
+	"bytes"
 	"errors"
+	"flag"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -10,11 +12,18 @@ import (
 	"testing"
 )
 
+var scriptCoverProfile = flag.String("pragmash.coverprofile", "",
+	"write the lcov coverage of the test scripts to a file")
+
 func TestAllScripts(t *testing.T) {
 	listing, err := listTestDirectory()
 	if err != nil {
 		t.Fatal(err)
 	}
+	var coverage *Coverage
+	if *scriptCoverProfile != "" {
+		coverage = NewCoverage()
+	}
 	for _, path := range listing {
 		testName := filepath.Base(path)
 		s, err := readTestScript(path)
@@ -22,38 +31,64 @@ func TestAllScripts(t *testing.T) {
 			t.Error(err)
 			continue
 		}
-		if err := s.run(); err != nil {
+		if err := s.run(runTree, coverage); err != nil {
 			t.Error("error in " + testName + ": " + err.Error())
 		}
+		if err := s.run(runCompiled, nil); err != nil {
+			t.Error("error in compiled " + testName + ": " + err.Error())
+		}
+		if err := s.run(runBytecode, nil); err != nil {
+			t.Error("error in bytecode " + testName + ": " + err.Error())
+		}
+	}
+	if coverage != nil {
+		if err := writeScriptCoverage(coverage); err != nil {
+			t.Error(err)
+		}
 	}
 }
 
+// These are the ways in which a test script can be executed.
+const (
+	runTree = iota
+	runCompiled
+	runBytecode
+)
+
+// runModes lists every mode, and modeNames names them in test failures.
+var (
+	runModes  = []int{runTree, runCompiled, runBytecode}
+	modeNames = []string{"tree", "compiled", "bytecode"}
+)
+
 type testScript struct {
 	expect string
 	path   string
 }
 
-func (t *testScript) run() error {
+// run runs the script in one of the modes. If coverage is not nil, the
+// coverage of the script is recorded.
+func (t *testScript) run(mode int, coverage *Coverage) error {
 	variables := map[string]*Value{
 		"ARGV": NewValueArray([]*Value{}),
 		"DIR":  NewValueString(filepath.Dir(t.path)),
 	}
-	runner := NewStdRunner(variables)
+	var runner Runner = NewStdRunner(variables)
+	if coverage != nil {
+		runner = NewCoverageRunner(runner, coverage)
+	}
 
-	// Create the script
 	contents, err := ioutil.ReadFile(t.path)
 	if err != nil {
 		return err
 	}
-
-	// Parse the script
-	lines, contexts, err := TokenizeString(string(contents))
+	runnable, err := parseSource(string(contents), runner, mode)
 	if err != nil {
 		return err
 	}
-	runnable, err := ScanAll(lines, contexts)
-	if err != nil {
-		return err
+
+	if coverage != nil {
+		runnable = coverage.Instrument(runnable, t.path)
 	}
 
 	if _, bo := runnable.Run(runner); bo == nil {
@@ -66,6 +101,94 @@ func (t *testScript) run() error {
 	return nil
 }
 
+// parseSource parses the source of a script so that it runs in one of the
+// modes with a runner.
+func parseSource(source string, runner Runner, mode int) (Runnable, error) {
+	lines, contexts, err := TokenizeString(source)
+	if err != nil {
+		return nil, err
+	}
+	runnable, err := ScanAll(lines, contexts)
+	if err != nil {
+		return nil, err
+	}
+	if mode == runCompiled {
+		runnable = Compile(runnable, runner)
+	} else if mode == runBytecode {
+		program, err := CompileBytecode(runnable)
+		if err != nil {
+			return nil, err
+		}
+
+		// Make sure the program survives serialization.
+		var buf bytes.Buffer
+		if err := program.Encode(&buf); err != nil {
+			return nil, err
+		}
+		runnable, err = DecodeProgram(&buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return runnable, nil
+}
+
+// runSource runs the source of a script in one of the modes with a runner and
+// returns the breakout it ends with.
+func runSource(source string, runner Runner, mode int) (*Breakout, error) {
+	runnable, err := parseSource(source, runner, mode)
+	if err != nil {
+		return nil, err
+	}
+	_, bo := runnable.Run(runner)
+	return bo, nil
+}
+
+// sourceResult runs the source of a script in one of the modes with a runner
+// and returns the value it returns, or the value of its last statement if it
+// finishes without returning.
+func sourceResult(source string, runner Runner, mode int) (string, error) {
+	runnable, err := parseSource(source, runner, mode)
+	if err != nil {
+		return "", err
+	}
+	val, bo := runnable.Run(runner)
+	if bo == nil {
+		return val.String(), nil
+	} else if bo.Type() != BreakoutTypeReturn {
+		if bo.Error() != nil {
+			return "", bo.Error()
+		}
+		return "", errors.New("unexpected breakout")
+	}
+	return bo.Value().String(), nil
+}
+
+// testSourceResults runs each script in every mode with a runner and checks
+// the value which it returns.
+func testSourceResults(t *testing.T, runner Runner, cases map[string]string) {
+	for script, expected := range cases {
+		for _, mode := range runModes {
+			res, err := sourceResult(script, runner, mode)
+			if err != nil {
+				t.Errorf("%q (%s): %s", script, modeNames[mode], err)
+			} else if res != expected {
+				t.Errorf("%q (%s): expected %q but got %q", script,
+					modeNames[mode], expected, res)
+			}
+		}
+	}
+}
+
+func writeScriptCoverage(coverage *Coverage) error {
+	f, err := os.Create(*scriptCoverProfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return coverage.WriteLcov(f)
+}
+
 func listTestDirectory() ([]string, error) {
 	_, filename, _, _ := runtime.Caller(0)
 	testsPath := filepath.Join(filepath.Dir(filename), "tests")
@@ -139,6 +139,14 @@ func (g *genericScanner) Line(l Line, context string) (Runnable, error) {
 		}
 		g.subScanner = ifScanner
 		return nil, nil
+	} else if l.Tokens[0].String == "test" && len(l.Tokens) == 2 &&
+		l.Open {
+		testScanner, err := NewTestScanner(l, context)
+		if err != nil {
+			return nil, err
+		}
+		g.subScanner = testScanner
+		return nil, nil
 	} else if l.Open {
 		// The { cannot be for control; it must be an argument.
 		l.Open = false
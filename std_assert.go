@@ -0,0 +1,63 @@
+package pragmash
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// StdAssert implements commands for writing tests.
+type StdAssert struct{}
+
+// Assert fails if a condition is false. Any extra arguments are used as the
+// failure message.
+func (_ StdAssert) Assert(condition *Value, message ...string) error {
+	if condition.Bool() {
+		return nil
+	}
+	if len(message) == 0 {
+		return errors.New("assertion failed")
+	}
+	return errors.New(strings.Join(message, " "))
+}
+
+// AssertEq fails if two values are not equal. Any extra arguments are added to
+// the failure message.
+func (_ StdAssert) AssertEq(expected, actual *Value, message ...string) error {
+	if expected.String() == actual.String() {
+		return nil
+	}
+	msg := "expected " + strconv.Quote(expected.String()) + " but got " +
+		strconv.Quote(actual.String())
+	if len(message) > 0 {
+		msg = strings.Join(message, " ") + ": " + msg
+	}
+	return errors.New(msg)
+}
+
+// AssertThrows runs some code like eval and fails unless it throws an
+// exception. If a substring is given, the exception's message must contain
+// it. This returns the exception's message.
+func (_ StdAssert) AssertThrows(r Runner, code string,
+	substr ...string) (string, error) {
+	if len(substr) > 1 {
+		return "", errors.New("expected 1 or 2 arguments")
+	}
+	runnable, err := parseCode(code, " in assert_throws")
+	if err != nil {
+		return "", err
+	}
+	runnable = Compile(runnable, r)
+	_, bo := runnable.Run(r)
+	if bo == nil || bo.Type() == BreakoutTypeReturn {
+		return "", errors.New("expected an exception")
+	} else if bo.Type() != BreakoutTypeException {
+		return "", bo.Error()
+	}
+	msg := bo.Error().Error()
+	if len(substr) == 1 && !strings.Contains(msg, substr[0]) {
+		return "", errors.New("expected an exception containing " +
+			strconv.Quote(substr[0]) + " but got " + strconv.Quote(msg))
+	}
+	return msg, nil
+}
@@ -0,0 +1,184 @@
+package pragmash
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// StdCsv implements commands for reading and writing CSV documents.
+//
+// Since an array cannot hold other arrays, a document is handled as an array
+// of rows, and each row is a line of CSV which can be split into its fields.
+// An array cannot hold a string with a newline either, so commands which
+// return arrays fail on fields with newlines in them.
+type StdCsv struct{}
+
+// CsvColumn returns the values in the column with a given header, leaving out
+// the header itself.
+func (_ StdCsv) CsvColumn(doc, name string) ([]string, error) {
+	records, idx, err := csvHeaderLookup(doc, name)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		if idx < len(record) {
+			res = append(res, record[idx])
+		} else {
+			res = append(res, "")
+		}
+	}
+	return csvArray(res)
+}
+
+// CsvEncode returns a row of CSV with the given fields, quoting them where
+// necessary. Fields may be given as arrays, but an empty argument is still an
+// empty field.
+func (_ StdCsv) CsvEncode(fields ...*Value) (string, error) {
+	var record []string
+	for _, field := range fields {
+		if field.String() == "" {
+			record = append(record, "")
+			continue
+		}
+		for _, x := range field.Array() {
+			record = append(record, x.String())
+		}
+	}
+	return csvEncode([][]string{record}, ',')
+}
+
+// CsvFields splits a row of CSV into its fields.
+func (_ StdCsv) CsvFields(row string) ([]string, error) {
+	records, err := csvDecode(row, ',')
+	if err != nil {
+		return nil, err
+	} else if len(records) != 1 {
+		return nil, errors.New("csv_fields: expected 1 row but got " +
+			strconv.Itoa(len(records)))
+	}
+	return csvArray(records[0])
+}
+
+// CsvGet returns the field in a row under a given header. The first row after
+// the header is row 0.
+func (_ StdCsv) CsvGet(doc string, row int, name string) (string, error) {
+	records, idx, err := csvHeaderLookup(doc, name)
+	if err != nil {
+		return "", err
+	} else if row < 0 || row+1 >= len(records) {
+		return "", errors.New("row out of bounds: " + strconv.Itoa(row))
+	}
+	if record := records[row+1]; idx < len(record) {
+		return record[idx], nil
+	}
+	return "", nil
+}
+
+// CsvHeader returns the fields of the first row of a document.
+func (_ StdCsv) CsvHeader(doc string) ([]string, error) {
+	records, err := csvDecode(doc, ',')
+	if err != nil {
+		return nil, err
+	} else if len(records) == 0 {
+		return nil, errors.New("csv_header: document is empty")
+	}
+	return csvArray(records[0])
+}
+
+// CsvRows returns every row of a document, including the header, with each
+// row encoded as CSV on its own.
+func (_ StdCsv) CsvRows(doc string) ([]string, error) {
+	records, err := csvDecode(doc, ',')
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, len(records))
+	for i, record := range records {
+		if _, err := csvArray(record); err != nil {
+			return nil, err
+		}
+		if res[i], err = csvEncode([][]string{record}, ','); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// CsvToTsv converts a CSV document to TSV.
+func (_ StdCsv) CsvToTsv(doc string) (string, error) {
+	records, err := csvDecode(doc, ',')
+	if err != nil {
+		return "", err
+	}
+	return csvEncode(records, '\t')
+}
+
+// TsvToCsv converts a TSV document to CSV, so that it can be read with the
+// other CSV commands.
+func (_ StdCsv) TsvToCsv(doc string) (string, error) {
+	records, err := csvDecode(doc, '\t')
+	if err != nil {
+		return "", err
+	}
+	return csvEncode(records, ',')
+}
+
+// csvArray returns fields as they are, or an error if one of them cannot be
+// an element of an array because it contains a newline.
+func csvArray(fields []string) ([]string, error) {
+	for _, field := range fields {
+		if strings.ContainsAny(field, "\r\n") {
+			return nil, errors.New("field with a newline cannot be put in " +
+				"an array: " + strconv.Quote(field))
+		}
+	}
+	return fields, nil
+}
+
+// csvDecode parses a document. Rows may have different numbers of fields.
+func csvDecode(doc string, separator rune) ([][]string, error) {
+	reader := csv.NewReader(strings.NewReader(doc))
+	reader.Comma = separator
+	reader.FieldsPerRecord = -1
+	if separator == '\t' {
+		// Quotes in TSV files are usually meant literally.
+		reader.LazyQuotes = true
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.New("invalid CSV: " + err.Error())
+	}
+	return records, nil
+}
+
+// csvEncode writes records without a trailing newline.
+func csvEncode(records [][]string, separator rune) (string, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Comma = separator
+	if err := writer.WriteAll(records); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// csvHeaderLookup parses a document and finds the index of the column with a
+// given header.
+func csvHeaderLookup(doc, name string) ([][]string, int, error) {
+	records, err := csvDecode(doc, ',')
+	if err != nil {
+		return nil, 0, err
+	} else if len(records) == 0 {
+		return nil, 0, errors.New("document is empty")
+	}
+	for i, header := range records[0] {
+		if header == name {
+			return records, i, nil
+		}
+	}
+	return nil, 0, errors.New("no such column: " + name)
+}
@@ -3,7 +3,6 @@ package pragmash
 import (
 	"errors"
 	"io/ioutil"
-	"os"
 	"strings"
 )
 
@@ -26,25 +25,17 @@ func (_ StdInternal) Call(r Runner, name string,
 
 // Eval runs some pragmash code inside the current runner.
 func (_ StdInternal) Eval(r Runner, code string) (*Value, error) {
-	lines, contexts, err := TokenizeString(code)
-	if err != nil {
-		return nil, err
-	}
-
-	// Update the contexts to reflect that we're in an eval.
-	for i, x := range contexts {
-		contexts[i] = x + " in eval"
-	}
-
-	// Generate the runner and run it
-	runnable, err := ScanAll(lines, contexts)
+	runnable, err := parseCode(code, " in eval")
 	if err != nil {
 		return nil, err
 	}
+	runnable = Compile(runnable, r)
 	if val, bo := runnable.Run(r); bo == nil {
 		return val, nil
 	} else if bo.Type() == BreakoutTypeReturn {
 		return bo.Value(), nil
+	} else if bo.Type() == BreakoutTypeAbort {
+		return nil, bo.Error()
 	} else {
 		return nil, errors.New(bo.Context() + ": " + bo.Error().Error())
 	}
@@ -57,76 +48,80 @@ func (_ StdInternal) Exec(r Runner, path string) (*Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	lines, contexts, err := TokenizeString(string(contents))
-	if err != nil {
-		return nil, err
-	}
-
-	// Update the contexts to include the path
-	for i, x := range contexts {
-		contexts[i] = x + " in " + path
-	}
-
-	// Generate the runner and run it
-	runnable, err := ScanAll(lines, contexts)
+	runnable, err := parseCode(string(contents), " in "+path)
 	if err != nil {
 		return nil, err
 	}
+	runnable = Compile(runnable, r)
+	runnable = instrumentScript(r, runnable, path)
 	if val, bo := runnable.Run(r); bo == nil {
 		return val, nil
 	} else if bo.Type() == BreakoutTypeReturn {
 		return bo.Value(), nil
+	} else if bo.Type() == BreakoutTypeAbort {
+		return nil, bo.Error()
 	} else {
 		return nil, errors.New(bo.Context() + ": " + bo.Error().Error())
 	}
 }
 
-// Exit exits the current program with an optional exit code.
-func (_ StdInternal) Exit(args ...*Value) {
-	if len(args) != 1 {
-		os.Exit(0)
-	} else {
-		num, err := args[0].Number()
-		if err != nil {
-			os.Exit(1)
+// Exit stops the script with an optional exit code. It cannot be caught, and
+// the program which runs the script exits with the code once it stops.
+func (_ StdInternal) Exit(args ...*Value) error {
+	code := 0
+	if len(args) == 1 {
+		if num, err := args[0].Number(); err != nil {
+			code = 1
+		} else {
+			code = int(num.Float())
 		}
-		os.Exit(int(num.Float()))
 	}
+	return &AbortError{&ExitError{code}}
+}
+
+// Help returns the documentation of a command, or lists the commands in each
+// category if no command is given.
+func (_ StdInternal) Help(args ...string) (string, error) {
+	if len(args) == 0 {
+		return commandList(), nil
+	} else if len(args) > 1 {
+		return "", errors.New("help: expected at most one command")
+	}
+	doc, ok := StdCommandDoc(args[0])
+	if !ok {
+		return "", errors.New("unknown command: " + args[0])
+	}
+	return doc.Help(), nil
 }
 
 // Pragmash runs a script with a given set of arguments in a new, standard
 // runner. This is different from Exec because it isolates the variables of the
 // new script and it sets its $DIR and $ARGV variables.
-func (_ StdInternal) Pragmash(path string, args ...*Value) (*Value, error) {
+// Any limits placed on the current script also apply to the new one.
+func (_ StdInternal) Pragmash(r Runner, path string,
+	args ...*Value) (*Value, error) {
 	contents, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	lines, contexts, err := TokenizeString(string(contents))
-	if err != nil {
-		return nil, err
-	}
-
-	// Update the contexts to include the path
-	for i, x := range contexts {
-		contexts[i] = x + " in " + path
-	}
-
-	// Generate the runnable
-	runnable, err := ScanAll(lines, contexts)
+	runnable, err := parseCode(string(contents), " in "+path)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate the runner
 	variables := CreateStandardVariables(path, args)
-	runner := NewStdRunner(variables)
+	runner := inheritRunner(r, NewStdRunner(variables))
+	runnable = Compile(runnable, runner)
+	runnable = instrumentScript(runner, runnable, path)
 
 	// Run the file.
 	if val, bo := runnable.Run(runner); bo == nil {
 		return val, nil
 	} else if bo.Type() == BreakoutTypeReturn {
 		return bo.Value(), nil
+	} else if bo.Type() == BreakoutTypeAbort {
+		return nil, bo.Error()
 	} else {
 		return nil, errors.New(bo.Context() + ": " + bo.Error().Error())
 	}
@@ -159,3 +154,34 @@ func (_ StdInternal) Throw(args ...*Value) error {
 	}
 	return errors.New(strings.Join(strArgs, " "))
 }
+
+// Trace turns tracing on or off. It fails if the script is not being run by a
+// Tracer.
+func (_ StdInternal) Trace(r Runner, state string) error {
+	tracer, err := findTracer(r)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case "on":
+		tracer.SetEnabled(true)
+	case "off":
+		tracer.SetEnabled(false)
+	default:
+		return errors.New("trace: expected on or off")
+	}
+	return nil
+}
+
+// parseCode tokenizes and scans some code, adding a suffix to the context of
+// every line so that errors say where the code came from.
+func parseCode(code, contextSuffix string) (Runnable, error) {
+	lines, contexts, err := TokenizeString(code)
+	if err != nil {
+		return nil, err
+	}
+	for i, x := range contexts {
+		contexts[i] = x + contextSuffix
+	}
+	return ScanAll(lines, contexts)
+}
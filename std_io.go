@@ -2,24 +2,39 @@ package pragmash
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"net/http/cookiejar"
+	"net/url"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // StdIo implements the standard I/O routines.
 type StdIo struct {
-	Client http.Client
+	// Session is used by the HTTP commands. It is created when it is first
+	// needed.
+	Session *HttpSession
+
+	// server holds the routes of http_route and serves them.
+	server *httpServer
+
+	// request is the request which is being handled, if this StdIo belongs
+	// to the handlers of a server.
+	request *httpExchange
 }
 
 // Cmd executes a shell command and returns its combined output.
-func (_ StdIo) Cmd(args ...string) (string, error) {
+// The command is killed if the runner's context is done.
+func (_ StdIo) Cmd(r Runner, args ...string) (string, error) {
 	if len(args) == 0 {
 		return "", errors.New("expected at least 1 argument")
 	}
@@ -31,7 +46,7 @@ func (_ StdIo) Cmd(args ...string) (string, error) {
 	}
 
 	// Run the command.
-	cmd := exec.Command(cmdName, args[1:]...)
+	cmd := exec.CommandContext(RunnerContext(r), cmdName, args[1:]...)
 	res, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", err
@@ -53,69 +68,344 @@ func (_ StdIo) Gets() (string, error) {
 	return scanner.Text(), nil
 }
 
+// HttpBaseUrl sets the URL which relative URLs in HTTP commands are resolved
+// against. With no arguments, it removes the base URL.
+func (s *StdIo) HttpBaseUrl(args ...string) error {
+	if len(args) > 1 {
+		return errors.New("expected at most 1 argument")
+	} else if len(args) == 0 {
+		s.httpSession().BaseURL = nil
+		return nil
+	}
+	u, err := url.Parse(args[0])
+	if err != nil {
+		return err
+	} else if !u.IsAbs() {
+		return errors.New("base URL is not absolute: " + args[0])
+	}
+	s.httpSession().BaseURL = u
+	return nil
+}
+
 // HttpCookiesOff disables cookie storage.
-func (s StdIo) HttpCookiesOff() {
-	s.Client.Jar = nil
+func (s *StdIo) HttpCookiesOff() {
+	s.httpSession().DisableCookies()
 }
 
-// HttpCookiesOn enables cookie storage.
-func (s StdIo) HttpCookiesOn() {
-	// TODO: use a public suffix list
-	s.Client.Jar, _ = cookiejar.New(nil)
+// HttpCookiesOn enables cookie storage. If a file is given, cookies are
+// loaded from it and saved to it.
+func (s *StdIo) HttpCookiesOn(file ...string) error {
+	if len(file) > 1 {
+		return errors.New("expected at most 1 argument")
+	} else if len(file) == 1 {
+		return s.httpSession().EnableCookies(file[0])
+	}
+	return s.httpSession().EnableCookies("")
+}
+
+// HttpDelete runs an HTTP delete request and returns the body of the
+// response.
+func (s *StdIo) HttpDelete(r Runner, url string,
+	extraHeaders ...string) (string, error) {
+	return s.doRequest(r, url, "DELETE", nil, extraHeaders)
+}
+
+// HttpDownload runs an HTTP get request and writes the body of the response
+// to a file as it arrives.
+func (s *StdIo) HttpDownload(r Runner, url, path string,
+	extraHeaders ...string) error {
+	resp, err := s.sendRequest(r, url, "GET", nil,
+		extraHeaders)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
 }
 
 // HttpGet runs an HTTP get request. This respects the current cookie settings.
-func (s StdIo) HttpGet(url string, extraHeaders ...string) (string, error) {
-	return s.doRequest(url, "GET", nil, extraHeaders)
+func (s *StdIo) HttpGet(r Runner, url string,
+	extraHeaders ...string) (string, error) {
+	return s.doRequest(r, url, "GET", nil, extraHeaders)
+}
+
+// HttpHead runs an HTTP head request. The headers of the response can be read
+// with HttpResponseHeader.
+func (s *StdIo) HttpHead(r Runner, url string, extraHeaders ...string) error {
+	_, err := s.doRequest(r, url, "HEAD", nil, extraHeaders)
+	return err
+}
+
+// HttpHeader sets a header which is sent with every HTTP request. With no
+// value, it stops sending the header.
+func (s *StdIo) HttpHeader(name string, value ...string) error {
+	headers := s.httpSession().Headers
+	if len(value) > 1 {
+		return errors.New("expected at most 2 arguments")
+	} else if len(value) == 0 {
+		headers.Del(name)
+	} else {
+		headers.Set(name, value[0])
+	}
+	return nil
+}
+
+// HttpPatch runs an HTTP patch request and returns the body of the response.
+func (s *StdIo) HttpPatch(r Runner, url, contentType, body string,
+	extraHeaders ...string) (string, error) {
+	return s.doRequest(r, url, "PATCH",
+		strings.NewReader(body), bodyHeaders(contentType, extraHeaders))
 }
 
 // HttpPost runs an HTTP post request. This respects the current cookie
 // settings.
-func (s StdIo) HttpPost(url, contentType, body string,
+func (s *StdIo) HttpPost(r Runner, url, contentType, body string,
 	extraHeaders ...string) (string, error) {
-	bodyReader := strings.NewReader(body)
-	headers := append([]string{"Content-Type: " + contentType},
-		extraHeaders...)
-	return s.doRequest(url, "POST", bodyReader, headers)
+	return s.doRequest(r, url, "POST",
+		strings.NewReader(body), bodyHeaders(contentType, extraHeaders))
+}
+
+// HttpProxy sends HTTP requests through a proxy. With no arguments, the proxy
+// comes from the environment again.
+func (s *StdIo) HttpProxy(args ...string) error {
+	if len(args) > 1 {
+		return errors.New("expected at most 1 argument")
+	} else if len(args) == 0 {
+		s.httpSession().Proxy = nil
+		return nil
+	}
+	u, err := url.Parse(args[0])
+	if err != nil {
+		return err
+	}
+	s.httpSession().Proxy = u
+	return nil
+}
+
+// HttpPut runs an HTTP put request and returns the body of the response.
+func (s *StdIo) HttpPut(r Runner, url, contentType, body string,
+	extraHeaders ...string) (string, error) {
+	return s.doRequest(r, url, "PUT",
+		strings.NewReader(body), bodyHeaders(contentType, extraHeaders))
+}
+
+// HttpRedirects sets the number of redirects which HTTP requests follow.
+func (s *StdIo) HttpRedirects(max int) error {
+	if max < 0 {
+		return errors.New("redirect limit cannot be negative")
+	}
+	s.httpSession().MaxRedirects = max
+	return nil
+}
+
+// HttpReplyHeader adds a header to the response of the request which is being
+// handled.
+func (s *StdIo) HttpReplyHeader(name, value string) error {
+	if s.request == nil {
+		return errors.New("not handling an HTTP request")
+	}
+	s.request.header.Add(name, value)
+	return nil
+}
+
+// HttpReplyStatus sets the status code of the response to the request which
+// is being handled.
+func (s *StdIo) HttpReplyStatus(code int) error {
+	if s.request == nil {
+		return errors.New("not handling an HTTP request")
+	} else if code < 100 || code > 999 {
+		return errors.New("invalid status code: " + strconv.Itoa(code))
+	}
+	s.request.status = code
+	return nil
+}
+
+// HttpRequest returns a part of the request which is being handled: its
+// method, path, query, headers, or body. A query parameter or a header can be
+// selected by name.
+func (s *StdIo) HttpRequest(part string, name ...string) (string, error) {
+	if s.request == nil {
+		return "", errors.New("not handling an HTTP request")
+	} else if len(name) > 1 {
+		return "", errors.New("expected at most 2 arguments")
+	} else if len(name) == 1 && part != "query" && part != "header" {
+		return "", errors.New("http_request: " + part + " has no names")
+	}
+	req := s.request.req
+	switch part {
+	case "method":
+		return req.Method, nil
+	case "path":
+		return req.URL.Path, nil
+	case "query":
+		if len(name) == 1 {
+			return strings.Join(req.URL.Query()[name[0]], "\n"), nil
+		}
+		return req.URL.RawQuery, nil
+	case "header":
+		if len(name) == 1 {
+			return strings.Join(req.Header[http.CanonicalHeaderKey(name[0])],
+				"\n"), nil
+		}
+		return headerLines(req.Header), nil
+	case "body":
+		return s.request.body, nil
+	}
+	return "", errors.New("http_request: unknown part: " + part)
+}
+
+// HttpResponseHeader returns a header of the last HTTP response. If the
+// header has several values, they are returned as an array. With no name, it
+// returns an array of every header line of the response.
+func (s *StdIo) HttpResponseHeader(name ...string) (string, error) {
+	header := s.httpSession().lastHeader
+	if header == nil {
+		return "", errors.New("no HTTP response yet")
+	} else if len(name) > 1 {
+		return "", errors.New("expected at most 1 argument")
+	} else if len(name) == 1 {
+		return strings.Join(header[http.CanonicalHeaderKey(name[0])],
+			"\n"), nil
+	}
+	return headerLines(header), nil
+}
+
+// HttpRoute makes the server run some code for requests with a method and a
+// path. The method may be * to match every method, and a path which ends with
+// * matches every path with the same prefix.
+func (s *StdIo) HttpRoute(method, path, code string) error {
+	return s.httpServer().addRoute(method, path, code)
+}
+
+// HttpServe starts serving the routes on an address, such as "127.0.0.1:0",
+// and returns the address which the server is listening on. Requests are
+// handled while the script waits in HttpWait or in its own HTTP requests.
+func (s *StdIo) HttpServe(r Runner, addr string) (string, error) {
+	return s.httpServer().start(r, addr)
+}
+
+// HttpStatus returns the status code of the last HTTP response.
+func (s *StdIo) HttpStatus() (int, error) {
+	if s.httpSession().lastHeader == nil {
+		return 0, errors.New("no HTTP response yet")
+	}
+	return s.httpSession().lastStatus, nil
+}
+
+// HttpStop stops the server. A handler may stop the server which is running
+// it, and its response is still sent.
+func (s *StdIo) HttpStop() error {
+	return s.httpServer().stop()
+}
+
+// HttpStrict turns on or off exceptions for HTTP responses whose status code
+// is not 2xx.
+func (s *StdIo) HttpStrict(state string) error {
+	if state != "on" && state != "off" {
+		return errors.New("http_strict: expected on or off")
+	}
+	s.httpSession().Strict = state == "on"
+	return nil
+}
+
+// HttpTimeout limits the number of seconds which HTTP requests may take. A
+// timeout of 0 removes the limit.
+func (s *StdIo) HttpTimeout(seconds float64) error {
+	if seconds < 0 {
+		return errors.New("timeout cannot be negative")
+	}
+	s.httpSession().Timeout = time.Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// HttpTlsCa trusts the certificate authorities in a PEM file instead of the
+// system's.
+func (s *StdIo) HttpTlsCa(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return errors.New("no certificates in " + file)
+	}
+	config := s.httpSession().TLS.Clone()
+	config.RootCAs = pool
+	s.httpSession().TLS = config
+	return nil
+}
+
+// HttpTlsCert sets the client certificate for HTTPS requests from a PEM
+// certificate file and a PEM key file.
+func (s *StdIo) HttpTlsCert(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	config := s.httpSession().TLS.Clone()
+	config.Certificates = []tls.Certificate{cert}
+	s.httpSession().TLS = config
+	return nil
+}
+
+// HttpTlsVerify turns the verification of server certificates on or off.
+func (s *StdIo) HttpTlsVerify(state string) error {
+	if state != "on" && state != "off" {
+		return errors.New("http_tls_verify: expected on or off")
+	}
+	config := s.httpSession().TLS.Clone()
+	config.InsecureSkipVerify = state == "off"
+	s.httpSession().TLS = config
+	return nil
+}
+
+// HttpWait handles requests to the server until it is stopped.
+func (s *StdIo) HttpWait(r Runner) error {
+	if s.httpServer().listener == nil {
+		return errors.New("not serving HTTP")
+	}
+	return s.httpServer().wait(r)
 }
 
 // Print prints text to the console with no newline.
-func (_ StdIo) Print(vals ...string) {
+func (_ StdIo) Print(r Runner, vals ...string) {
+	w := RunnerStdout(r)
 	for i, s := range vals {
 		if i != 0 {
-			fmt.Print(" ")
+			fmt.Fprint(w, " ")
 		}
-		fmt.Print(s)
+		fmt.Fprint(w, s)
 	}
 }
 
 // Puts prints text to the console with a trailing newline.
-func (_ StdIo) Puts(vals ...string) {
+func (_ StdIo) Puts(r Runner, vals ...string) {
+	w := RunnerStdout(r)
 	for i, s := range vals {
 		if i != 0 {
-			fmt.Print(" ")
+			fmt.Fprint(w, " ")
 		}
-		fmt.Print(s)
+		fmt.Fprint(w, s)
 	}
-	fmt.Println("")
+	fmt.Fprintln(w, "")
 }
 
-// Read reads the contents of a file or a URL.
-// This will not use the cookies set by "http" commands.
-func (_ StdIo) Read(resource string) (string, error) {
+// Read reads the contents of a file or a URL. URLs are read like http_get,
+// with the cookies and settings of the HTTP session.
+func (s *StdIo) Read(r Runner, resource string) (string, error) {
 	// Read a web URL if applicable.
 	if strings.HasPrefix(resource, "http://") ||
 		strings.HasPrefix(resource, "https://") {
-		resp, err := http.Get(resource)
-		if err != nil {
-			return "", err
-		}
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return "", err
-		}
-		return string(body), nil
+		return s.doRequest(r, resource, "GET", nil, nil)
 	}
 
 	// Read a path.
@@ -131,18 +421,39 @@ func (_ StdIo) Write(path, data string) error {
 	return ioutil.WriteFile(path, []byte(data), os.FileMode(0600))
 }
 
-// doRequest performs a request given some arguments.
-func (s StdIo) doRequest(url, method string, body io.Reader,
-	headers []string) (string, error) {
+// doRequest performs a request given some arguments and returns the body of
+// the response.
+func (s *StdIo) doRequest(r Runner, url, method string,
+	body io.Reader, headers []string) (string, error) {
+	resp, err := s.sendRequest(r, url, method, body, headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	res, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(res), nil
+}
+
+// sendRequest sends a request with the session and remembers the status and
+// headers of the response. The caller must close the body of the response.
+//
+// If the script is serving HTTP, requests to the server are handled while the
+// request is being sent.
+func (s *StdIo) sendRequest(r Runner, url, method string,
+	body io.Reader, headers []string) (*http.Response, error) {
 	// Create the request.
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	req = req.WithContext(RunnerContext(r))
 	for _, headerLine := range headers {
 		idx := strings.Index(headerLine, ": ")
 		if idx < 0 {
-			return "", errors.New("Invalid header: " + headerLine)
+			return nil, errors.New("Invalid header: " + headerLine)
 		}
 		name := headerLine[:idx]
 		value := headerLine[idx+2:]
@@ -153,14 +464,50 @@ func (s StdIo) doRequest(url, method string, body io.Reader,
 	}
 
 	// Send the request.
-	resp, err := s.Client.Do(req)
+	session := s.httpSession()
+	resp, err := s.httpServer().await(r, func() (*http.Response, error) {
+		return session.Do(req)
+	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	res, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+	session.lastStatus, session.lastHeader = resp.StatusCode, resp.Header
+	if session.Strict && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		resp.Body.Close()
+		return nil, errors.New(method + " " + req.URL.String() + ": " +
+			resp.Status)
 	}
-	return string(res), nil
+	return resp, nil
+}
+
+// bodyHeaders adds a Content-Type header to the headers of a request.
+func bodyHeaders(contentType string, headers []string) []string {
+	return append([]string{"Content-Type: " + contentType}, headers...)
+}
+
+// headerLines returns a sorted array of header lines, such as "Content-Type:
+// text/html".
+func headerLines(header http.Header) string {
+	var lines []string
+	for key, values := range header {
+		for _, value := range values {
+			lines = append(lines, key+": "+value)
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func (s *StdIo) httpServer() *httpServer {
+	if s.server == nil {
+		s.server = &httpServer{}
+	}
+	return s.server
+}
+
+func (s *StdIo) httpSession() *HttpSession {
+	if s.Session == nil {
+		s.Session = NewHttpSession()
+	}
+	return s.Session
 }
@@ -0,0 +1,285 @@
+package pragmash
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StdJson implements commands for reading and building JSON documents.
+//
+// A path selects a value inside a document. It is a list of object keys and
+// array indices separated by dots, such as "users.0.name". The empty path
+// selects the whole document.
+type StdJson struct{}
+
+// JsonArray returns the elements of a JSON array as an array. Each element is
+// formatted like the result of JsonGet.
+func (_ StdJson) JsonArray(doc string, path ...string) ([]string, error) {
+	val, err := jsonLookup(doc, path)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := val.([]interface{})
+	if !ok {
+		return nil, errors.New("json_array: not an array")
+	}
+	res := make([]string, len(list))
+	for i, x := range list {
+		if res[i], err = jsonText(x); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// JsonEncode creates a JSON value of a given kind from its arguments. The
+// elements of an array or an object may be given as arrays. They become
+// strings, unless the kind is json_array or json_object, in which case they
+// must be JSON already.
+func (_ StdJson) JsonEncode(kind string, args ...*Value) (string, error) {
+	var val interface{}
+	switch kind {
+	case "string", "number", "bool":
+		if len(args) != 1 {
+			return "", errors.New("json_encode " + kind +
+				": expected 1 value")
+		}
+		str := args[0].String()
+		if kind == "string" {
+			val = str
+		} else if kind == "bool" {
+			val = str != "" && str != "false"
+		} else if num, err := ParseNumber(str); err != nil {
+			return "", err
+		} else {
+			val = json.Number(num.String())
+		}
+	case "null":
+		if len(args) != 0 {
+			return "", errors.New("json_encode null: expected no values")
+		}
+	case "array", "object", "json_array", "json_object":
+		elements := []interface{}{}
+		for _, arg := range args {
+			for _, x := range arg.Array() {
+				elements = append(elements, x.String())
+			}
+		}
+		isJSON := strings.HasPrefix(kind, "json_")
+		if kind == "array" || kind == "json_array" {
+			if isJSON {
+				for i, x := range elements {
+					var err error
+					if elements[i], err = jsonDecode(x.(string)); err != nil {
+						return "", err
+					}
+				}
+			}
+			val = elements
+			break
+		} else if len(elements)%2 != 0 {
+			return "", errors.New("json_encode " + kind + ": expected keys " +
+				"and values")
+		}
+		obj := map[string]interface{}{}
+		for i := 0; i < len(elements); i += 2 {
+			value := elements[i+1]
+			if isJSON {
+				var err error
+				if value, err = jsonDecode(value.(string)); err != nil {
+					return "", err
+				}
+			}
+			obj[elements[i].(string)] = value
+		}
+		val = obj
+	default:
+		return "", errors.New("json_encode: unknown kind: " + kind)
+	}
+	return jsonMarshal(val, "")
+}
+
+// JsonGet returns the value at a path in a JSON document. Strings are returned
+// without quotes, and other values are returned as JSON.
+func (_ StdJson) JsonGet(doc string, path ...string) (string, error) {
+	val, err := jsonLookup(doc, path)
+	if err != nil {
+		return "", err
+	}
+	return jsonText(val)
+}
+
+// JsonKeys returns the keys of a JSON object in sorted order.
+func (_ StdJson) JsonKeys(doc string, path ...string) ([]string, error) {
+	val, err := jsonLookup(doc, path)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("json_keys: not an object")
+	}
+	res := make([]string, 0, len(obj))
+	for key := range obj {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// JsonPretty formats a JSON document with an indent of two spaces.
+func (_ StdJson) JsonPretty(doc string) (string, error) {
+	val, err := jsonDecode(doc)
+	if err != nil {
+		return "", err
+	}
+	return jsonMarshal(val, "  ")
+}
+
+// JsonSet replaces the value at a path in a JSON document with a JSON value
+// and returns the new document. Missing objects along the path are created,
+// and an index one past the end of an array appends to it.
+func (_ StdJson) JsonSet(doc, path, value string) (string, error) {
+	root, err := jsonDecode(doc)
+	if err != nil {
+		return "", err
+	}
+	newVal, err := jsonDecode(value)
+	if err != nil {
+		return "", err
+	}
+	res, err := jsonReplace(root, jsonPath(path), newVal)
+	if err != nil {
+		return "", err
+	}
+	return jsonMarshal(res, "")
+}
+
+func jsonDecode(doc string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(doc))
+	decoder.UseNumber()
+	var res interface{}
+	if err := decoder.Decode(&res); err != nil {
+		return nil, errors.New("invalid JSON: " + err.Error())
+	} else if decoder.More() {
+		return nil, errors.New("invalid JSON: extra data after value")
+	}
+	return res, nil
+}
+
+// jsonMarshal encodes a value without escaping HTML characters. If indent is
+// not "", the result is indented.
+func jsonMarshal(val interface{}, indent string) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", indent)
+	if err := encoder.Encode(val); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// jsonText formats a decoded value for a script. Strings are returned without
+// quotes, and other values are returned as JSON.
+func jsonText(val interface{}) (string, error) {
+	if str, ok := val.(string); ok {
+		return str, nil
+	}
+	return jsonMarshal(val, "")
+}
+
+// jsonPath splits a path into its keys.
+func jsonPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// jsonLookup decodes a document and finds the value at an optional path.
+func jsonLookup(doc string, path []string) (interface{}, error) {
+	if len(path) > 1 {
+		return nil, errors.New("expected at most 2 arguments")
+	}
+	val, err := jsonDecode(doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return val, nil
+	}
+	for _, key := range jsonPath(path[0]) {
+		switch x := val.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if val, ok = x[key]; !ok {
+				return nil, errors.New("no such key: " + key)
+			}
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(x) {
+				return nil, errors.New("no such index: " + key)
+			}
+			val = x[idx]
+		default:
+			return nil, errors.New("cannot look up " + key + " in a " +
+				"value which is not an object or an array")
+		}
+	}
+	return val, nil
+}
+
+// jsonReplace returns a copy of a value with the value at a path replaced.
+func jsonReplace(val interface{}, path []string,
+	newVal interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return newVal, nil
+	}
+	key := path[0]
+	switch x := val.(type) {
+	case nil:
+		child, err := jsonReplace(nil, path[1:], newVal)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{key: child}, nil
+	case map[string]interface{}:
+		child, err := jsonReplace(x[key], path[1:], newVal)
+		if err != nil {
+			return nil, err
+		}
+		res := make(map[string]interface{}, len(x)+1)
+		for k, v := range x {
+			res[k] = v
+		}
+		res[key] = child
+		return res, nil
+	case []interface{}:
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 || idx > len(x) {
+			return nil, errors.New("no such index: " + key)
+		}
+		var old interface{}
+		if idx < len(x) {
+			old = x[idx]
+		}
+		child, err := jsonReplace(old, path[1:], newVal)
+		if err != nil {
+			return nil, err
+		}
+		res := append([]interface{}{}, x...)
+		if idx == len(x) {
+			res = append(res, child)
+		} else {
+			res[idx] = child
+		}
+		return res, nil
+	}
+	return nil, errors.New("cannot set " + key + " in a value which is not " +
+		"an object or an array")
+}
@@ -0,0 +1,160 @@
+package pragmash
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// regexpCacheSize is the number of compiled regular expressions which are
+// kept, so that a regular expression used in a loop is only compiled once.
+const regexpCacheSize = 128
+
+var regexpCache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: map[string]*regexp.Regexp{}}
+
+// MatchAll returns an array with the text of every match of a regular
+// expression.
+func (_ StdString) MatchAll(expr, s string, flags ...string) ([]string,
+	error) {
+	r, err := compileFlags(expr, flags)
+	if err != nil {
+		return nil, err
+	}
+	res := r.FindAllString(s, -1)
+	if res == nil {
+		return []string{}, nil
+	}
+	return res, nil
+}
+
+// MatchFirst returns an array with the text of the first match of a regular
+// expression followed by the text of each of its groups. It returns an empty
+// array if there is no match.
+func (_ StdString) MatchFirst(expr, s string, flags ...string) ([]string,
+	error) {
+	r, err := compileFlags(expr, flags)
+	if err != nil {
+		return nil, err
+	}
+	res := r.FindStringSubmatch(s)
+	if res == nil {
+		return []string{}, nil
+	}
+	return res, nil
+}
+
+// MatchGroup returns an array with the text of a group in every match of a
+// regular expression. The group may be a name or a number, where 0 is the
+// whole match.
+func (_ StdString) MatchGroup(expr, s, group string,
+	flags ...string) ([]string, error) {
+	r, err := compileFlags(expr, flags)
+	if err != nil {
+		return nil, err
+	}
+	idx := r.SubexpIndex(group)
+	if idx < 0 {
+		num, err := strconv.Atoi(group)
+		if err != nil || num < 0 || num > r.NumSubexp() {
+			return nil, errors.New("no such group: " + group)
+		}
+		idx = num
+	}
+	res := []string{}
+	for _, match := range r.FindAllStringSubmatch(s, -1) {
+		res = append(res, match[idx])
+	}
+	return res, nil
+}
+
+// MatchJson returns a JSON array with an object for every match of a regular
+// expression. Each object maps the number of every group, and the name of
+// every named group, to its text. Groups which did not take part in the match
+// are null.
+func (_ StdString) MatchJson(expr, s string, flags ...string) (string,
+	error) {
+	r, err := compileFlags(expr, flags)
+	if err != nil {
+		return "", err
+	}
+	names := r.SubexpNames()
+	res := []interface{}{}
+	for _, match := range r.FindAllStringSubmatchIndex(s, -1) {
+		obj := map[string]interface{}{}
+		for i, name := range names {
+			var text interface{}
+			if match[2*i] >= 0 {
+				text = s[match[2*i]:match[2*i+1]]
+			}
+			obj[strconv.Itoa(i)] = text
+			if name != "" {
+				obj[name] = text
+			}
+		}
+		res = append(res, obj)
+	}
+	return jsonMarshal(res, "")
+}
+
+// Matches returns true if a regular expression matches part of a string.
+func (_ StdString) Matches(expr, s string, flags ...string) (bool, error) {
+	r, err := compileFlags(expr, flags)
+	if err != nil {
+		return false, err
+	}
+	return r.MatchString(s), nil
+}
+
+// SplitRegex splits a string around each match of a regular expression.
+func (_ StdString) SplitRegex(expr, s string, flags ...string) ([]string,
+	error) {
+	r, err := compileFlags(expr, flags)
+	if err != nil {
+		return nil, err
+	} else if s == "" {
+		return []string{}, nil
+	}
+	return r.Split(s, -1), nil
+}
+
+// compileRegexp compiles a regular expression, or returns it from the cache if
+// it has been compiled before.
+func compileRegexp(expr string) (*regexp.Regexp, error) {
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+	if r, ok := regexpCache.m[expr]; ok {
+		return r, nil
+	}
+	r, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(regexpCache.m) >= regexpCacheSize {
+		regexpCache.m = map[string]*regexp.Regexp{}
+	}
+	regexpCache.m[expr] = r
+	return r, nil
+}
+
+// compileFlags compiles a regular expression with an optional string of
+// flags. The flags are i (ignore case), m (^ and $ match at line breaks), s
+// (. matches \n), and U (swap the greediness of repetitions).
+func compileFlags(expr string, flags []string) (*regexp.Regexp, error) {
+	if len(flags) > 1 {
+		return nil, errors.New("expected at most 1 string of flags")
+	} else if len(flags) == 1 && flags[0] != "" {
+		for _, c := range flags[0] {
+			if !strings.ContainsRune("imsU", c) {
+				return nil, errors.New("unknown regular expression flag: " +
+					string(c))
+			}
+		}
+		expr = "(?" + flags[0] + ")" + expr
+	}
+	return compileRegexp(expr)
+}
@@ -7,15 +7,22 @@ import (
 // StdAll implements the methods corresponding to the standard library.
 type StdAll struct {
 	StdArray
+	StdAssert
+	StdCsv
 	StdFs
 	StdInternal
 	StdIo
+	StdJson
 	StdMath
 	StdOps
 	StdString
 	StdTime
 }
 
+// PureCommands lists the methods of StdAll which are documented as pure in
+// command_docs.go. Compile may evaluate calls to these commands ahead of time.
+var PureCommands = pureCommands()
+
 // CreateStandardVariables generates the set of standard variables for a given
 // script and set of arguments.
 func CreateStandardVariables(script string, args []*Value) map[string]*Value {
@@ -33,12 +40,29 @@ func CreateStandardVariables(script string, args []*Value) map[string]*Value {
 
 // NewStdRunner returns a Runner which implements the standard library.
 func NewStdRunner(variables map[string]*Value) Runner {
-	runner := NewReflectRunner(StdAll{}, OperatorRewrites)
+	return newStdRunner(&StdAll{}, variables)
+}
+
+// pureCommands finds the methods of the commands which are documented as pure.
+func pureCommands() map[string]bool {
+	res := map[string]bool{}
+	for _, doc := range commandDocs {
+		if method, ok := stdCommandMethod(doc.Name); ok && doc.Pure {
+			res[method.Name] = true
+		}
+	}
+	return res
+}
+
+// newStdRunner returns a standard runner for an existing StdAll.
+func newStdRunner(all *StdAll, variables map[string]*Value) *ReflectRunner {
+	runner := NewReflectRunner(all, OperatorRewrites)
+	runner.pure = PureCommands
 
 	// Copy variables if necessary.
 	if variables != nil {
 		for name, value := range variables {
-			runner.variables[name] = value
+			runner.Variable(name).Value = value
 		}
 	}
 
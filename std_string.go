@@ -3,11 +3,18 @@ package pragmash
 import (
 	"bytes"
 	"errors"
-	"regexp"
+	"fmt"
+	"math/big"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
+// maxRepeatLength is the length in bytes of the longest string which Repeat
+// will create.
+const maxRepeatLength = 1 << 30
+
 // StdString implements ways of manipulating or creating strings
 type StdString struct{}
 
@@ -27,13 +34,16 @@ func (_ StdString) Chars(s string) []string {
 	return resArr
 }
 
-// Chr takes a list of numbers (representing bytes) and turns it into a string.
-func (_ StdString) Chr(list []int) string {
+// Chr takes a list of Unicode code points and turns it into a string.
+func (_ StdString) Chr(list []int) (string, error) {
 	res := bytes.Buffer{}
 	for _, x := range list {
-		res.WriteByte(byte(x))
+		if x < 0 || x > unicode.MaxRune {
+			return "", errors.New("invalid code point: " + strconv.Itoa(x))
+		}
+		res.WriteRune(rune(x))
 	}
-	return res.String()
+	return res.String(), nil
 }
 
 // Echo joins its arguments with spaces.
@@ -48,6 +58,101 @@ func (_ StdString) Escape(str string) string {
 	return s
 }
 
+// Fields splits a string around runs of whitespace.
+func (_ StdString) Fields(s string) []string {
+	return strings.Fields(s)
+}
+
+// Fmtnum formats a number with a separator between each group of three digits
+// in its integer part. The optional arguments are a number of decimal places
+// and the separator, which is "," by default.
+func (_ StdString) Fmtnum(num *Number, args ...string) (string, error) {
+	if len(args) > 2 {
+		return "", errors.New("expected at most 3 arguments")
+	}
+	str := num.String()
+	if len(args) > 0 {
+		decimals, err := strconv.Atoi(args[0])
+		if err != nil || decimals < 0 {
+			return "", errors.New("invalid number of decimals: " + args[0])
+		}
+		if !num.IsInt() {
+			str = roundNumber(num, decimals)
+		} else if decimals > 0 {
+			str += "." + strings.Repeat("0", decimals)
+		}
+	}
+	separator := ","
+	if len(args) == 2 {
+		separator = args[1]
+	}
+
+	sign, digits, fraction := "", str, ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if idx := strings.Index(digits, "."); idx >= 0 {
+		digits, fraction = digits[:idx], digits[idx:]
+	}
+	var buf bytes.Buffer
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			buf.WriteString(separator)
+		}
+		buf.WriteRune(c)
+	}
+	return sign + buf.String() + fraction, nil
+}
+
+// Format formats values according to a template, like printf in C. See
+// formatValue for the verbs which are supported.
+func (_ StdString) Format(template string, args ...string) (string, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			buf.WriteByte(template[i])
+			continue
+		}
+
+		// Find the end of the flags, width, and precision.
+		j := i + 1
+		for j < len(template) && strings.IndexByte("-+# 0", template[j]) >= 0 {
+			j++
+		}
+		for j < len(template) && template[j] >= '0' && template[j] <= '9' {
+			j++
+		}
+		if j < len(template) && template[j] == '.' {
+			j++
+			for j < len(template) && template[j] >= '0' && template[j] <= '9' {
+				j++
+			}
+		}
+		if j == len(template) {
+			return "", errors.New("format: incomplete verb: " + template[i:])
+		}
+		spec := template[i : j+1]
+		i = j
+
+		if spec == "%%" {
+			buf.WriteByte('%')
+			continue
+		} else if len(args) == 0 {
+			return "", errors.New("format: missing value for " + spec)
+		}
+		str, err := formatValue(spec, args[0])
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(str)
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		return "", errors.New("format: too many values")
+	}
+	return buf.String(), nil
+}
+
 // HasPrefix returns true if the first argument begins with the second.
 func (_ StdString) HasPrefix(s, prefix string) bool {
 	return strings.HasPrefix(s, prefix)
@@ -86,9 +191,9 @@ func (_ StdString) Join(args ...string) string {
 	return buffer.String()
 }
 
-// Len returns the length of a string in bytes.
+// Len returns the number of characters in a string.
 func (_ StdInternal) Len(val string) int {
-	return len(val)
+	return utf8.RuneCountInString(val)
 }
 
 // Lowercase joins its arguments with spaces and returns the result, converted
@@ -97,10 +202,21 @@ func (s StdString) Lowercase(args ...string) string {
 	return strings.ToLower(s.Echo(args...))
 }
 
+// Ltrim removes characters from the start of a string. If no cutset is given,
+// whitespace is removed.
+func (_ StdString) Ltrim(s string, cutset ...string) (string, error) {
+	if len(cutset) > 1 {
+		return "", errors.New("expected 1 or 2 arguments")
+	} else if len(cutset) == 1 {
+		return strings.TrimLeft(s, cutset[0]), nil
+	}
+	return strings.TrimLeftFunc(s, unicode.IsSpace), nil
+}
+
 // Match runs a regular expression on a string.
 func (_ StdString) Match(expr, haystack string) ([]string, error) {
 	// Evaluate the regular expression.
-	r, err := regexp.Compile(expr)
+	r, err := compileRegexp(expr)
 	if err != nil {
 		return nil, err
 	}
@@ -116,19 +232,19 @@ func (_ StdString) Match(expr, haystack string) ([]string, error) {
 	return list, nil
 }
 
-// Ord returns a list of numeric bytes given a string.
+// Ord returns a list of the Unicode code points in a string.
 func (_ StdString) Ord(str string) []int {
-	res := make([]int, len(str))
-	for i := 0; i < len(str); i++ {
-		res[i] = int(str[i])
+	res := make([]int, 0, len(str))
+	for _, x := range str {
+		res = append(res, int(x))
 	}
 	return res
 }
 
 // PadZero pads a string with zeroes on the left until it's a certain length.
 func (_ StdString) PadZero(length int, str string) string {
-	for len(str) < length {
-		str = "0" + str
+	if count := utf8.RuneCountInString(str); count < length {
+		return strings.Repeat("0", length-count) + str
 	}
 	return str
 }
@@ -138,11 +254,25 @@ func (_ StdString) Rep(s, old, replacement string) string {
 	return strings.Replace(s, old, replacement, -1)
 }
 
+// Repeat returns a string repeated a number of times. It fails if the result
+// would be longer than maxRepeatLength.
+func (_ StdString) Repeat(s string, count int) (string, error) {
+	if count < 0 {
+		return "", errors.New("negative repeat count: " +
+			strconv.Itoa(count))
+	} else if len(s) > 0 && count > maxRepeatLength/len(s) {
+		return "", errors.New("repeated string would be too long: " +
+			strconv.Itoa(count) + " copies of " + strconv.Itoa(len(s)) +
+			" bytes")
+	}
+	return strings.Repeat(s, count), nil
+}
+
 // Repreg replaces all occurences of a regular expression with an expandable
 // expression.
 func (_ StdString) Repreg(s, expr, replacement string) (string, error) {
 	// Evaluate the regular expression.
-	r, err := regexp.Compile(expr)
+	r, err := compileRegexp(expr)
 	if err != nil {
 		return "", err
 	}
@@ -150,8 +280,60 @@ func (_ StdString) Repreg(s, expr, replacement string) (string, error) {
 	return r.ReplaceAllString(s, replacement), nil
 }
 
-// Substr returns a substring of a large string.
-func (_ StdString) Substr(s string, start int, e ...int) (string, error) {
+// Reverse reverses the characters of a string.
+func (_ StdString) Reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// Rtrim removes characters from the end of a string. If no cutset is given,
+// whitespace is removed.
+func (_ StdString) Rtrim(s string, cutset ...string) (string, error) {
+	if len(cutset) > 1 {
+		return "", errors.New("expected 1 or 2 arguments")
+	} else if len(cutset) == 1 {
+		return strings.TrimRight(s, cutset[0]), nil
+	}
+	return strings.TrimRightFunc(s, unicode.IsSpace), nil
+}
+
+// Split splits a string around each occurrence of a separator. If the
+// separator is "", the string is split into its characters.
+func (_ StdString) Split(s, sep string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, sep)
+}
+
+// StrCount returns the number of times that a substring occurs in a string
+// without overlapping.
+func (_ StdString) StrCount(s, substr string) (int, error) {
+	if substr == "" {
+		return 0, errors.New("str_count: empty substring")
+	}
+	return strings.Count(s, substr), nil
+}
+
+// StrIndex returns the character index of the first occurrence of a substring
+// in a string, or -1 if it does not occur.
+func (_ StdString) StrIndex(s, substr string) int {
+	return runeIndex(s, strings.Index(s, substr))
+}
+
+// StrLastindex returns the character index of the last occurrence of a
+// substring in a string, or -1 if it does not occur.
+func (_ StdString) StrLastindex(s, substr string) int {
+	return runeIndex(s, strings.LastIndex(s, substr))
+}
+
+// Substr returns a substring of a large string. The indices count characters
+// rather than bytes.
+func (_ StdString) Substr(str string, start int, e ...int) (string, error) {
+	s := []rune(str)
 	if len(s) == 0 {
 		return "", nil
 	}
@@ -177,7 +359,33 @@ func (_ StdString) Substr(s string, start int, e ...int) (string, error) {
 		end = len(s)
 	}
 
-	return s[start:end], nil
+	return string(s[start:end]), nil
+}
+
+// Title joins its arguments with spaces and returns the result with the first
+// letter of each word in upper-case.
+func (s StdString) Title(args ...string) string {
+	var buf bytes.Buffer
+	last := ' '
+	for _, x := range s.Echo(args...) {
+		if !unicode.IsLetter(last) && !unicode.IsDigit(last) && last != '\'' {
+			x = unicode.ToTitle(x)
+		}
+		buf.WriteRune(x)
+		last = x
+	}
+	return buf.String()
+}
+
+// Trim removes characters from both ends of a string. If no cutset is given,
+// whitespace is removed.
+func (_ StdString) Trim(s string, cutset ...string) (string, error) {
+	if len(cutset) > 1 {
+		return "", errors.New("expected 1 or 2 arguments")
+	} else if len(cutset) == 1 {
+		return strings.Trim(s, cutset[0]), nil
+	}
+	return strings.TrimSpace(s), nil
 }
 
 // Unescape replaces "\\" with "\" and "\n" with a newline.
@@ -192,3 +400,71 @@ func (_ StdString) Unescape(arg string) string {
 func (s StdString) Uppercase(args ...string) string {
 	return strings.ToUpper(s.Echo(args...))
 }
+
+// formatValue formats a value with a verb of Format.
+//
+// The verbs s and q format strings. The verbs d, x, X, o, and b format
+// integers of any size, and e, E, f, g, and G format numbers. Integers are
+// never converted to floating points, so they are formatted exactly.
+func formatValue(spec, value string) (string, error) {
+	switch verb := spec[len(spec)-1]; verb {
+	case 's', 'q':
+		return fmt.Sprintf(spec, value), nil
+	case 'd', 'x', 'X', 'o', 'b', 'e', 'E', 'f', 'g', 'G':
+		num, err := ParseNumber(value)
+		if err != nil {
+			return "", err
+		}
+		if strings.IndexByte("dxXob", verb) >= 0 {
+			if !num.IsInt() {
+				return "", errors.New("format: " + spec + " expects an " +
+					"integer: " + value)
+			}
+			return fmt.Sprintf(spec, num.Int()), nil
+		} else if num.IsInt() {
+			f := new(big.Float).SetPrec(uint(num.Int().BitLen()) + 64)
+			return fmt.Sprintf(spec, f.SetInt(num.Int())), nil
+		}
+		return fmt.Sprintf(spec, num.Float()), nil
+	}
+	return "", errors.New("format: unknown verb: " + spec)
+}
+
+// roundNumber formats a number which is not an integer with a number of
+// decimal places, rounding half away from zero. The number is rounded as it is
+// written rather than as its binary value, so 2.675 rounds up to 2.68.
+func roundNumber(num *Number, decimals int) string {
+	r, _ := new(big.Rat).SetString(num.String())
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	r.Mul(r, new(big.Rat).SetInt(scale))
+
+	// Divide the numerator by the denominator and round the quotient away
+	// from zero if the remainder is at least half of the denominator.
+	quo, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if rem.Abs(rem).Lsh(rem, 1).Cmp(r.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(r.Sign())))
+	}
+
+	sign := ""
+	if quo.Sign() < 0 {
+		sign = "-"
+	}
+	digits := quo.Abs(quo).String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals+1-len(digits)) + digits
+	}
+	if decimals == 0 {
+		return sign + digits
+	}
+	idx := len(digits) - decimals
+	return sign + digits[:idx] + "." + digits[idx:]
+}
+
+// runeIndex converts a byte index in a string to a character index. Negative
+// indices are returned as they are.
+func runeIndex(s string, idx int) int {
+	if idx < 0 {
+		return idx
+	}
+	return utf8.RuneCountInString(s[:idx])
+}
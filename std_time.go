@@ -10,9 +10,18 @@ import (
 type StdTime struct{}
 
 // Sleep stops execution for a given number of seconds which may be fractional.
-func (_ StdTime) Sleep(f float64) {
+// It returns early with an error if the runner's context is done.
+func (_ StdTime) Sleep(r Runner, f float64) error {
 	nanos := math.Floor(f * 1000000000)
-	time.Sleep(time.Nanosecond * time.Duration(nanos))
+	timer := time.NewTimer(time.Nanosecond * time.Duration(nanos))
+	defer timer.Stop()
+	ctx := RunnerContext(r)
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // Time returns the UNIX epoch time in seconds which may be fractional.
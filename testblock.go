@@ -0,0 +1,91 @@
+package pragmash
+
+import (
+	"errors"
+)
+
+// A TestBlock is a named block of code which is run as a test.
+//
+// When a script is run by RunTestFile, each test is recorded separately and a
+// failing test does not stop the rest of the script. Otherwise, the block
+// simply runs and its exceptions are thrown as usual.
+//
+// Returning from a test ends the test. A break or continue cannot leave a
+// test, so it throws an exception instead.
+type TestBlock struct {
+	Body    Runnable
+	Context string
+	Name    Runnable
+}
+
+// Run runs the test.
+func (t TestBlock) Run(r Runner) (*Value, *Breakout) {
+	name, bo := t.Name.Run(r)
+	if bo != nil {
+		return nil, bo
+	}
+	if recorder := findTestRecorder(r); recorder != nil {
+		return recorder.runTest(name.String(), t, r)
+	}
+	_, bo = t.Body.Run(r)
+	if bo = testBreakout(bo); bo != nil {
+		return nil, bo
+	}
+	return emptyValue, nil
+}
+
+// testBreakout returns the breakout of a test whose body ended with a
+// breakout. It is nil if the body returned.
+func testBreakout(bo *Breakout) *Breakout {
+	if bo == nil || bo.Type() == BreakoutTypeReturn {
+		return nil
+	} else if bo.Type() == BreakoutTypeBreak ||
+		bo.Type() == BreakoutTypeContinue {
+		return NewBreakoutException(bo.Context(), bo.Error())
+	}
+	return bo
+}
+
+// A TestScanner reads a test block semantically.
+type TestScanner struct {
+	context string
+	name    Runnable
+	scanner SemanticScanner
+}
+
+// NewTestScanner starts a TestScanner or fails if the initiating line is
+// invalid.
+func NewTestScanner(l Line, context string) (*TestScanner, error) {
+	if !l.Open {
+		return nil, errors.New("test must open a block")
+	} else if l.Close {
+		return nil, errors.New("test must not close a block")
+	} else if len(l.Tokens) != 2 || l.Tokens[0].String != "test" {
+		return nil, errors.New("test must have the form 'test NAME {'")
+	}
+	name := l.Tokens[1].Runnable(context)
+	return &TestScanner{context, name, newGenericScanner(true)}, nil
+}
+
+// EOF returns an error with the context of the first line of the test.
+func (t *TestScanner) EOF() (Runnable, error) {
+	return nil, errors.New("test (at " + t.context +
+		") not terminated at EOF")
+}
+
+// Line adds a line to the test.
+// If the line terminates the test, this returns the test as Runnable.
+// If any kind of error is encountered, this returns the error.
+// If the test is not closed and the line is properly processed, this returns
+// nil, nil.
+func (t *TestScanner) Line(l Line, context string) (Runnable, error) {
+	if res, err := t.scanner.Line(l, context); err != nil {
+		return nil, err
+	} else if res != nil {
+		if len(l.Tokens) > 0 {
+			return nil, errors.New("unexpected tokens after test block")
+		}
+		return TestBlock{res, t.context, t.name}, nil
+	}
+	return nil, nil
+}
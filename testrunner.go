@@ -0,0 +1,201 @@
+package pragmash
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestOptions controls how RunTestFile runs a test script.
+type TestOptions struct {
+	// Filter selects the tests to run by name. If it is nil, every test runs.
+	Filter *regexp.Regexp
+
+	// Update makes RunTestFile write the output of the script to its golden
+	// file instead of comparing them.
+	Update bool
+}
+
+// A TestResult is the outcome of a test block, or of a whole test script if
+// Name is empty.
+type TestResult struct {
+	File     string
+	Name     string
+	Context  string
+	Err      error
+	Skipped  bool
+	Duration time.Duration
+}
+
+// Passed returns true if the test ran without failing.
+func (t *TestResult) Passed() bool {
+	return t.Err == nil && !t.Skipped
+}
+
+// GoldenPath returns the path of the file with the expected output of a test
+// script: the script's path with ".pragmash" replaced by ".stdout".
+func GoldenPath(script string) string {
+	return strings.TrimSuffix(script, ".pragmash") + ".stdout"
+}
+
+// RunTestFile runs a test script and returns the results of its tests.
+//
+// Each test block in the script is a separate test. A failing test does not
+// stop the rest of the script, but an exception outside of a test block does.
+// If the script has a golden file (see GoldenPath), everything that the script
+// prints must match it.
+//
+// If the script has no test blocks, the script itself is a single test.
+func RunTestFile(path string, options TestOptions) []*TestResult {
+	start := time.Now()
+	fileResult := func(context string, err error) *TestResult {
+		return &TestResult{File: path, Context: context, Err: err,
+			Duration: time.Since(start)}
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []*TestResult{fileResult("", err)}
+	}
+	runnable, err := parseCode(string(contents), "")
+	if err != nil {
+		return []*TestResult{fileResult("", err)}
+	}
+
+	variables := CreateStandardVariables(path, []*Value{})
+	recorder := &testRecorder{file: path, filter: options.Filter}
+	recorder.inner = NewStdRunner(variables)
+	recorder.SetOuter(recorder)
+	runnable = Compile(runnable, recorder)
+
+	var fileErr *TestResult
+	if _, bo := runnable.Run(recorder); bo != nil &&
+		bo.Type() != BreakoutTypeReturn && !recorder.stopped {
+		fileErr = fileResult(bo.Context(), bo.Error())
+	}
+	if fileErr == nil {
+		if err := recorder.checkGolden(path, options.Update); err != nil {
+			fileErr = fileResult("", err)
+		}
+	}
+
+	results := recorder.results
+	if fileErr != nil {
+		results = append(results, fileErr)
+	} else if len(results) == 0 {
+		results = append(results, fileResult("", nil))
+	}
+	return results
+}
+
+// testRecorder is the Runner for a test script. It records the results of
+// test blocks and captures the output of the script.
+type testRecorder struct {
+	forwardingRunner
+
+	file    string
+	filter  *regexp.Regexp
+	results []*TestResult
+	stdout  bytes.Buffer
+
+	// stopped is true if a test stopped the whole script.
+	stopped bool
+}
+
+// Stdout returns the buffer which holds the output of the script.
+func (t *testRecorder) Stdout() io.Writer {
+	return &t.stdout
+}
+
+func (t *testRecorder) checkGolden(path string, update bool) error {
+	golden := GoldenPath(path)
+	if update {
+		if t.stdout.Len() == 0 {
+			if err := os.Remove(golden); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		return ioutil.WriteFile(golden, t.stdout.Bytes(), 0644)
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if bytes.Equal(expected, t.stdout.Bytes()) {
+		return nil
+	}
+	expectedLines := strings.Split(string(expected), "\n")
+	actualLines := strings.Split(t.stdout.String(), "\n")
+	for i := 0; ; i++ {
+		var e, a string
+		if i < len(expectedLines) {
+			e = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			a = actualLines[i]
+		}
+		if e != a || i >= len(expectedLines) || i >= len(actualLines) {
+			return errors.New("output does not match " + golden +
+				" at line " + strconv.Itoa(i+1) + ": expected " +
+				quoteLine(e, i < len(expectedLines)) + " but got " +
+				quoteLine(a, i < len(actualLines)))
+		}
+	}
+}
+
+func (t *testRecorder) runTest(name string, test TestBlock,
+	r Runner) (*Value, *Breakout) {
+	result := &TestResult{File: t.file, Name: name, Context: test.Context}
+	t.results = append(t.results, result)
+	if t.filter != nil && !t.filter.MatchString(name) {
+		result.Skipped = true
+		return emptyValue, nil
+	}
+	start := time.Now()
+	_, bo := test.Body.Run(r)
+	result.Duration = time.Since(start)
+	bo = testBreakout(bo)
+	if bo == nil {
+		return emptyValue, nil
+	}
+	result.Context = bo.Context()
+	result.Err = bo.Error()
+	if bo.Type() == BreakoutTypeException {
+		return emptyValue, nil
+	}
+	// Aborts stop the whole script.
+	t.stopped = true
+	return nil, bo
+}
+
+// findTestRecorder returns the testRecorder in a chain of WrapperRunners, or
+// nil if there is none.
+func findTestRecorder(r Runner) *testRecorder {
+	for r != nil {
+		if t, ok := r.(*testRecorder); ok {
+			return t
+		}
+		w, ok := r.(WrapperRunner)
+		if !ok {
+			break
+		}
+		r = w.Unwrap()
+	}
+	return nil
+}
+
+func quoteLine(line string, exists bool) string {
+	if !exists {
+		return "end of output"
+	}
+	return strconv.Quote(line)
+}
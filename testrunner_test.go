@@ -0,0 +1,114 @@
+package pragmash
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestRunTestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pragmash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "math_test.pragmash")
+	script := "puts start\ntest add {\nassert_eq 4 (+ 2 2)\n}\n" +
+		"test fail {\nassert (= 1 2) \"not equal\"\n}\n" +
+		"test throws {\nassert_eq oops (assert_throws \"throw oops\")\n}\n" +
+		"puts end\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results := RunTestFile(path, TestOptions{Update: true})
+	if len(results) != 3 {
+		t.Fatal("unexpected number of results:", len(results))
+	}
+	for i, name := range []string{"add", "fail", "throws"} {
+		if results[i].Name != name {
+			t.Errorf("result %d: unexpected name %q", i, results[i].Name)
+		}
+		if results[i].Passed() != (name != "fail") {
+			t.Errorf("result %d: unexpected error %v", i, results[i].Err)
+		}
+	}
+	if results[1].Context != "line 6" ||
+		results[1].Err.Error() != "not equal" {
+		t.Errorf("unexpected failure: %s: %v", results[1].Context,
+			results[1].Err)
+	}
+	golden, err := ioutil.ReadFile(GoldenPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(golden) != "start\nend\n" {
+		t.Errorf("unexpected golden output: %q", golden)
+	}
+
+	options := TestOptions{Filter: regexp.MustCompile("^add$")}
+	results = RunTestFile(path, options)
+	if len(results) != 3 || !results[0].Passed() || !results[1].Skipped ||
+		!results[2].Skipped {
+		t.Error("unexpected results with a filter")
+	}
+
+	err = ioutil.WriteFile(GoldenPath(path), []byte("start\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = RunTestFile(path, options)
+	if len(results) != 4 || results[3].Name != "" ||
+		results[3].Err == nil {
+		t.Error("expected the golden file to fail")
+	}
+}
+
+func TestRunTestFileBreak(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pragmash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A break which would leave a test fails the test, and the loop around
+	// it goes on.
+	path := filepath.Join(dir, "break_test.pragmash")
+	script := "for i (range 2) {\ntest (join loop $i) {\nbreak\n}\n}\n" +
+		"test after {\nassert 1\n}\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results := RunTestFile(path, TestOptions{})
+	if len(results) != 3 {
+		t.Fatal("unexpected number of results:", len(results))
+	}
+	for i, name := range []string{"loop0", "loop1", "after"} {
+		if results[i].Name != name {
+			t.Errorf("result %d: unexpected name %q", i, results[i].Name)
+		} else if results[i].Passed() != (name == "after") {
+			t.Errorf("result %d: unexpected error %v", i, results[i].Err)
+		}
+	}
+	if results[0].Context != "line 3" {
+		t.Errorf("unexpected context: %s", results[0].Context)
+	}
+
+	// Outside of RunTestFile, the break is an exception.
+	script = "set n 0\nfor i (range 2) {\ntry {\ntest x {\ncontinue\n}\n" +
+		"} catch e {\nset n (+ $n 1)\n}\n}\nreturn $n $e"
+	for _, mode := range []int{runTree, runCompiled} {
+		res, err := sourceResult(script, NewStdRunner(nil), mode)
+		if err != nil {
+			t.Fatal(err)
+		} else if res != "2 continue without loop" {
+			t.Errorf("unexpected %s result: %q", modeNames[mode], res)
+		}
+	}
+	if _, err := parseSource(script, nil, runBytecode); err == nil {
+		t.Error("expected an error compiling the break to bytecode")
+	}
+}
@@ -9,40 +9,73 @@ import (
 // TokenizeString turns a string into a list of Lines with corresponding
 // contexts.
 func TokenizeString(str string) ([]Line, []string, error) {
-	lines := make([]Line, 0)
-	contexts := make([]string, 0)
+	source, err := TokenizeSource(str)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines := make([]Line, 0, len(source))
+	contexts := make([]string, 0, len(source))
+	for _, x := range source {
+		if x.Comment == "" {
+			lines = append(lines, x.Line)
+			contexts = append(contexts, "line "+strconv.Itoa(x.Number))
+		}
+	}
+	return lines, contexts, nil
+}
+
+// A SourceLine is a logical line in a source file, or a comment line.
+type SourceLine struct {
+	Line
+
+	// Comment is the text of a comment line without surrounding whitespace.
+	// It is empty if the line is not a comment.
+	Comment string
+
+	// Number is the number of the first raw line which makes up this line.
+	Number int
+}
+
+// TokenizeSource is like TokenizeString, but it keeps comment lines so that
+// the source can be written back out.
+//
+// A comment in the middle of a line continuation is returned before the line
+// it interrupts.
+func TokenizeSource(str string) ([]SourceLine, error) {
+	res := make([]SourceLine, 0)
 	tokenizer := NewTokenizer()
 	lineStart := -1
 
 	// Loop through each line string
 	for i, lineStr := range strings.Split(str, "\n") {
-		// If the line is a comment, we should skip it.
+		// Comments are not passed to the tokenizer.
 		if strings.HasPrefix(strings.TrimSpace(lineStr), "#") {
+			res = append(res, SourceLine{Comment: strings.TrimSpace(lineStr),
+				Number: i + 1})
 			continue
 		}
 
 		// Add the line to the tokenizer.
 		line, err := tokenizer.Line(lineStr)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		} else if line != nil {
-			// We read the line, so we should add it and its context.
-			lines = append(lines, *line)
+			// We read the line, so we should add it and its line number.
+			number := i + 1
 			if lineStart >= 0 {
-				contexts = append(contexts, "line "+strconv.Itoa(lineStart+1))
+				number = lineStart + 1
 				lineStart = -1
-			} else {
-				contexts = append(contexts, "line "+strconv.Itoa(i+1))
 			}
+			res = append(res, SourceLine{Line: *line, Number: number})
 		} else if lineStart < 0 {
 			// This line is being continued.
 			lineStart = i
 		}
 	}
 	if !tokenizer.Done() {
-		return nil, nil, errors.New("unexpected EOF after line continuation")
+		return nil, errors.New("unexpected EOF after line continuation")
 	}
-	return lines, contexts, nil
+	return res, nil
 }
 
 // A Line represents a logical line in a source file.
@@ -0,0 +1,184 @@
+package pragmash
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A Tracer is a Runner which prints every command it runs, along with the
+// evaluated arguments, the context, and the result or exception. Commands are
+// indented by how deeply they are nested.
+//
+// A Tracer must wrap every Runner other than another Observer so that it can
+// observe commands. Events are passed on to the wrapped Runner if it is an
+// Observer as well.
+// Scripts can turn tracing on and off with the trace command.
+//
+// Since a Tracer is an Observer, commands are not evaluated ahead of time by
+// Compile. Use MayTrace to skip the Tracer for scripts which cannot use it.
+type Tracer struct {
+	forwardingRunner
+
+	enabled bool
+	w       io.Writer
+
+	// traced has an entry for each command being evaluated, which is true if
+	// its call was traced.
+	traced []bool
+
+	// base is the depth of the command which started the script.
+	base int
+
+	// pending is the line for the innermost traced call if it has not been
+	// written yet.
+	pending string
+}
+
+// NewTracer wraps a Runner with a Tracer which writes to w.
+// Tracing starts out disabled.
+func NewTracer(r Runner, w io.Writer) *Tracer {
+	res := &Tracer{forwardingRunner: forwardingRunner{wrappedRunner{r}},
+		w: w}
+	res.SetOuter(res)
+	return res
+}
+
+// Enabled returns whether the Tracer is printing commands.
+func (t *Tracer) Enabled() bool {
+	return t.enabled
+}
+
+// SetEnabled turns tracing on or off.
+func (t *Tracer) SetEnabled(enabled bool) {
+	t.enabled = enabled
+}
+
+// EnterCommand keeps track of the nesting depth.
+func (t *Tracer) EnterCommand(context string) {
+	if o, ok := t.inner.(Observer); ok {
+		o.EnterCommand(context)
+	}
+	t.traced = append(t.traced, false)
+}
+
+// CallCommand prepares a line with a command and its arguments. The line is
+// written once the command finishes, or earlier if other commands are traced
+// while it runs.
+func (t *Tracer) CallCommand(context, name string, args []*Value) {
+	if o, ok := t.inner.(Observer); ok {
+		o.CallCommand(context, name, args)
+	}
+	if !t.enabled || len(t.traced) == 0 {
+		return
+	}
+	t.flush()
+	parts := make([]string, len(args)+1)
+	parts[0] = traceQuote(name)
+	for i, x := range args {
+		parts[i+1] = traceQuote(x.String())
+	}
+	t.pending = t.indent(len(t.traced)-1) + "+ [" + context + "] " +
+		strings.Join(parts, " ")
+	t.traced[len(t.traced)-1] = true
+}
+
+// ExitCommand prints the result of a command if its call was traced.
+func (t *Tracer) ExitCommand(context, name string, args []*Value,
+	res *Value, bo *Breakout) {
+	if o, ok := t.inner.(Observer); ok {
+		o.ExitCommand(context, name, args, res, bo)
+	}
+	if len(t.traced) == 0 {
+		return
+	}
+	traced := t.traced[len(t.traced)-1]
+	t.traced = t.traced[:len(t.traced)-1]
+	if !traced {
+		return
+	}
+	var result string
+	if bo != nil {
+		result = "exception: " + bo.Error().Error()
+	} else {
+		result = traceQuote(res.String())
+	}
+	if t.pending != "" {
+		fmt.Fprintln(t.w, t.pending+" => "+result)
+		t.pending = ""
+	} else {
+		fmt.Fprintln(t.w, t.indent(len(t.traced))+"=> "+result)
+	}
+}
+
+// flush writes the line for a call whose result is not known yet.
+func (t *Tracer) flush() {
+	if t.pending != "" {
+		fmt.Fprintln(t.w, t.pending)
+		t.pending = ""
+	}
+}
+
+func (t *Tracer) indent(depth int) string {
+	return strings.Repeat("  ", t.base+depth)
+}
+
+// inherit traces a separate script started with the pragmash command.
+// Its commands are indented below the command which started it.
+func (t *Tracer) inherit(r Runner) Runner {
+	t.flush()
+	res := NewTracer(r, t.w)
+	res.enabled = t.enabled
+	res.base = t.base + len(t.traced)
+	return res
+}
+
+// MayTrace returns true if a script might run the trace command, in which case
+// it needs to be run by a Tracer.
+//
+// This errs on the side of true: any constant which mentions trace counts, so
+// that code run by eval, call, or an HTTP handler is covered as well. Scripts
+// which run other files with exec or pragmash may always trace. Scripts which
+// have been through Compile cannot be inspected, so they may always trace too.
+func MayTrace(r Runnable) bool {
+	program, ok := r.(*Program)
+	if !ok {
+		var err error
+		program, err = CompileBytecode(r)
+		if err != nil {
+			return true
+		}
+	}
+	for _, constant := range program.Constants {
+		if strings.Contains(constant, "trace") || constant == "exec" ||
+			constant == "pragmash" {
+			return true
+		}
+	}
+	return false
+}
+
+// findTracer returns the first Tracer in a chain of WrapperRunners.
+func findTracer(r Runner) (*Tracer, error) {
+	for r != nil {
+		if t, ok := r.(*Tracer); ok {
+			return t, nil
+		}
+		w, ok := r.(WrapperRunner)
+		if !ok {
+			break
+		}
+		r = w.Unwrap()
+	}
+	return nil, errors.New("tracing is not available")
+}
+
+// traceQuote quotes a string if it would be hard to read without quotes.
+func traceQuote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\r\"\\$(){}#") {
+		return strconv.Quote(s)
+	}
+	return s
+}
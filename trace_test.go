@@ -0,0 +1,54 @@
+package pragmash
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	script := "set x (+ 1 2)\ntry {\ntrace off\nset y 1\ntrace on\nthrow $x\n" +
+		"} catch e {\n}\n"
+	expected := "  + [line 1] + 1 2 => 3\n" +
+		"+ [line 1] set x 3 => \"\"\n" +
+		"+ [line 3] trace off => \"\"\n" +
+		"  + [line 6] get x => 3\n" +
+		"+ [line 6] throw 3 => exception: 3\n"
+	for _, mode := range runModes {
+		var out bytes.Buffer
+		tracer := NewTracer(NewStdRunner(nil), &out)
+		tracer.SetEnabled(true)
+		if bo, err := runSource(script, tracer, mode); err != nil {
+			t.Fatal(err)
+		} else if bo != nil {
+			t.Fatal("unexpected breakout:", bo.Error())
+		}
+		if out.String() != expected {
+			t.Errorf("unexpected trace (%s): %q", modeNames[mode],
+				out.String())
+		}
+	}
+}
+
+func TestMayTrace(t *testing.T) {
+	cases := map[string]bool{
+		"puts hi\nset x (+ 1 2)":        false,
+		"trace on\nputs hi":             true,
+		"eval \"trace on\"":             true,
+		"set c tracer\n$c":              true,
+		"exec other.pragmash":           true,
+		"pragmash other.pragmash":       true,
+		"for x (range 3) {\nputs $x\n}": false,
+	}
+	for script, expected := range cases {
+		for _, mode := range []int{runTree, runBytecode} {
+			runnable, err := parseSource(script, NewStdRunner(nil), mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if MayTrace(runnable) != expected {
+				t.Errorf("%q (%s): expected %v", script, modeNames[mode],
+					expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package pragmash
+
+import (
+	"errors"
+	"strings"
+)
+
+// A vmHandler records where to resume execution when an exception is thrown
+// inside a try block.
+type vmHandler struct {
+	pc      int
+	stack   int
+	iters   int
+	entered int
+}
+
+// A vmIterator holds the state of a for loop.
+type vmIterator struct {
+	index    int
+	values   []*Value
+	indexVar *Value
+	variable *Value
+}
+
+// A vm holds the state of a single execution of a Program.
+type vm struct {
+	commands []CommandFunc
+	handlers []vmHandler
+	iters    []vmIterator
+	program  *Program
+	resolver Resolver
+	runner   Runner
+	stack    []*Value
+
+	// entered holds the contexts of the commands which an Observer has been
+	// told about but which have not finished yet.
+	entered  []int
+	observer Observer
+}
+
+var errStackUnderflow = errors.New("stack underflow in bytecode")
+
+// Run executes the program on a Runner.
+//
+// If the Runner is a Resolver, commands with constant names are looked up once
+// per run instead of once per call.
+func (p *Program) Run(r Runner) (*Value, *Breakout) {
+	v := &vm{program: p, runner: r, stack: make([]*Value, 0, 16)}
+	if resolver, ok := r.(Resolver); ok {
+		v.resolver = resolver
+		v.commands = make([]CommandFunc, len(p.Constants))
+	}
+	v.observer, _ = r.(Observer)
+	res, bo := v.run()
+	if bo != nil && v.observer != nil {
+		v.exitEntered(0, bo)
+	}
+	return res, bo
+}
+
+// constantValues returns a Value for each constant, creating them on the first
+// run of the program.
+func (p *Program) constantValues() []*Value {
+	if len(p.values) != len(p.Constants) {
+		p.values = make([]*Value, len(p.Constants))
+		for i, x := range p.Constants {
+			p.values[i] = NewValueString(x)
+		}
+	}
+	return p.values
+}
+
+// call runs a command with the arguments on top of the stack.
+// The arguments slice passed to the command aliases the stack, so it is only
+// valid until the command returns.
+func (v *vm) call(nameIdx int, name string, argc, context int) (*Value,
+	error) {
+	// The arguments are copied off the stack, since Runners and Observers may
+	// keep them.
+	args := make([]*Value, argc)
+	copy(args, v.stack[len(v.stack)-argc:])
+	if v.observer == nil {
+		return v.invoke(nameIdx, name, args)
+	}
+	contextStr := v.program.Contexts[context]
+	v.observer.CallCommand(contextStr, name, args)
+	res, err := v.invoke(nameIdx, name, args)
+	if len(v.entered) > 0 {
+		v.entered = v.entered[:len(v.entered)-1]
+		var bo *Breakout
+		if err != nil {
+			bo = NewBreakoutException(contextStr, err)
+		}
+		v.observer.ExitCommand(contextStr, name, args, res, bo)
+	}
+	return res, err
+}
+
+func (v *vm) invoke(nameIdx int, name string, args []*Value) (*Value,
+	error) {
+	if v.resolver == nil || nameIdx < 0 {
+		return v.runner.RunCommand(name, args)
+	}
+	fn := v.commands[nameIdx]
+	if fn == nil {
+		fn, _ = v.resolver.ResolveCommand(name)
+		if fn == nil {
+			return v.runner.RunCommand(name, args)
+		}
+		v.commands[nameIdx] = fn
+	}
+	return fn(args)
+}
+
+// exception unwinds to the innermost exception handler. It returns false if
+// there is no handler.
+func (v *vm) exception(context string, err error) (int, bool) {
+	if len(v.handlers) == 0 {
+		return 0, false
+	}
+	h := v.handlers[len(v.handlers)-1]
+	v.handlers = v.handlers[:len(v.handlers)-1]
+	if v.observer != nil {
+		v.exitEntered(h.entered, NewBreakoutException(context, err))
+	}
+	v.stack = append(v.stack[:h.stack], NewValueString(err.Error()))
+	v.iters = v.iters[:h.iters]
+	return h.pc, true
+}
+
+// exitEntered tells the Observer that every command which was entered after
+// the first n failed with a breakout.
+func (v *vm) exitEntered(n int, bo *Breakout) {
+	for len(v.entered) > n {
+		context := v.entered[len(v.entered)-1]
+		v.entered = v.entered[:len(v.entered)-1]
+		v.observer.ExitCommand(v.program.Contexts[context], "", nil, nil, bo)
+	}
+}
+
+// next assigns the variables of the innermost for loop. It returns false if
+// the loop is finished.
+func (v *vm) next() (bool, error) {
+	it := &v.iters[len(v.iters)-1]
+	if it.index >= len(it.values) {
+		v.iters = v.iters[:len(v.iters)-1]
+		return false, nil
+	}
+	if it.variable != nil {
+		args := []*Value{it.variable, it.values[it.index]}
+		if _, err := v.runner.RunCommand("set", args); err != nil {
+			return false, err
+		}
+	}
+	if it.indexVar != nil {
+		iVal := NewValueNumber(NewNumberInt(int64(it.index)))
+		args := []*Value{it.indexVar, iVal}
+		if _, err := v.runner.RunCommand("set", args); err != nil {
+			return false, err
+		}
+	}
+	it.index++
+	return true, nil
+}
+
+func (v *vm) pop() *Value {
+	res := v.stack[len(v.stack)-1]
+	v.stack = v.stack[:len(v.stack)-1]
+	return res
+}
+
+func (v *vm) run() (*Value, *Breakout) {
+	code := v.program.Code
+	constants := v.program.Constants
+	values := v.program.constantValues()
+	pc := 0
+	for pc < len(code) {
+		inst := code[pc]
+		pc++
+
+		// Make sure the stack has enough values for the instruction.
+		needed := 0
+		switch inst.Op {
+		case OpPop, OpJumpFalse, OpNot:
+			needed = 1
+		case OpCondEq, OpCatch:
+			needed = 2
+		case OpCall, OpReturn:
+			needed = inst.B
+		case OpCallDynamic:
+			needed = inst.B + 1
+		case OpIterate:
+			needed = 1
+			if inst.B&iterateVariable != 0 {
+				needed++
+			}
+			if inst.B&iterateIndex != 0 {
+				needed++
+			}
+		case OpNext, OpEndIterate:
+			if len(v.iters) == 0 {
+				return nil, NewBreakoutException("", errStackUnderflow)
+			}
+		case OpEndTry:
+			if len(v.handlers) == 0 {
+				return nil, NewBreakoutException("", errStackUnderflow)
+			}
+		}
+		if len(v.stack) < needed {
+			return nil, NewBreakoutException("", errStackUnderflow)
+		}
+
+		var err error
+		switch inst.Op {
+		case OpPush:
+			v.stack = append(v.stack, values[inst.A])
+		case OpEmpty:
+			v.stack = append(v.stack, emptyValue)
+		case OpTrue:
+			v.stack = append(v.stack, NewValueBool(true))
+		case OpPop:
+			v.pop()
+		case OpCall:
+			var res *Value
+			res, err = v.call(inst.A, constants[inst.A], inst.B,
+				inst.Context)
+			if err == nil {
+				v.stack = append(v.stack[:len(v.stack)-inst.B], res)
+			}
+		case OpCallDynamic:
+			var res *Value
+			base := len(v.stack) - inst.B - 1
+			res, err = v.call(-1, v.stack[base].String(), inst.B,
+				inst.Context)
+			if err == nil {
+				v.stack = append(v.stack[:base], res)
+			}
+		case OpJump:
+			if inst.A < pc && v.program.Code[inst.A].Op != OpNext {
+				// Jumping backwards means that a loop is starting its next
+				// iteration. A for loop is checked by OpNext instead, so
+				// that each iteration is only counted once.
+				context := v.program.Contexts[inst.Context]
+				if bo := checkRunner(v.runner, context); bo != nil {
+					return nil, bo
+				}
+			}
+			pc = inst.A
+		case OpJumpFalse:
+			if !v.pop().Bool() {
+				pc = inst.A
+			}
+		case OpCondEq:
+			val := v.pop()
+			if val.String() != v.stack[len(v.stack)-1].String() {
+				v.pop()
+				pc = inst.A
+			}
+		case OpNot:
+			top := len(v.stack) - 1
+			v.stack[top] = NewValueBool(!v.stack[top].Bool())
+		case OpReturn:
+			args := make([]string, inst.B)
+			for i, x := range v.stack[len(v.stack)-inst.B:] {
+				args[i] = x.String()
+			}
+			str := NewValueString(strings.Join(args, " "))
+			context := v.program.Contexts[inst.Context]
+			return nil, NewBreakoutReturn(context, str)
+		case OpBreakout:
+			context := v.program.Contexts[inst.Context]
+			if inst.A == BreakoutTypeBreak {
+				return nil, NewBreakoutBreak(context)
+			}
+			return nil, NewBreakoutContinue(context)
+		case OpTry:
+			v.handlers = append(v.handlers, vmHandler{inst.A, len(v.stack),
+				len(v.iters), len(v.entered)})
+		case OpEndTry:
+			v.handlers = v.handlers[:len(v.handlers)-1]
+		case OpCatch:
+			name := v.pop()
+			msg := v.pop()
+			_, err = v.runner.RunCommand("set", []*Value{name, msg})
+		case OpIterate:
+			var it vmIterator
+			if inst.B&iterateIndex != 0 {
+				it.indexVar = v.pop()
+			}
+			if inst.B&iterateVariable != 0 {
+				it.variable = v.pop()
+			}
+			it.values = v.pop().Array()
+			v.iters = append(v.iters, it)
+		case OpNext:
+			context := v.program.Contexts[inst.Context]
+			if bo := checkRunner(v.runner, context); bo != nil {
+				return nil, bo
+			}
+			var more bool
+			more, err = v.next()
+			if err == nil && !more {
+				pc = inst.A
+			}
+		case OpEndIterate:
+			v.iters = v.iters[:len(v.iters)-1]
+		case OpEnter:
+			if v.observer != nil {
+				v.entered = append(v.entered, inst.Context)
+				v.observer.EnterCommand(v.program.Contexts[inst.Context])
+			}
+		}
+
+		if err != nil {
+			// Aborts cannot be caught.
+			_, abort := err.(*AbortError)
+			var ok bool
+			context := v.program.Contexts[inst.Context]
+			if !abort {
+				pc, ok = v.exception(context, err)
+			}
+			if !ok {
+				return nil, NewBreakoutException(context, err)
+			}
+		}
+	}
+	if len(v.stack) == 0 {
+		return emptyValue, nil
+	}
+	return v.stack[len(v.stack)-1], nil
+}
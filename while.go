@@ -8,12 +8,17 @@ import (
 type While struct {
 	Body      Runnable
 	Condition Runnable
+	Context   string
 }
 
 // Run runs the while loop.
 // On success, this returns an empty string.
+// The loop is aborted if the Runner's context is done.
 func (w While) Run(r Runner) (*Value, *Breakout) {
 	for {
+		if bo := checkRunner(r, w.Context); bo != nil {
+			return nil, bo
+		}
 		val, bo := w.Condition.Run(r)
 		if bo != nil {
 			return nil, bo
@@ -76,7 +81,7 @@ func (w *WhileScanner) Line(l Line, context string) (Runnable, error) {
 		if len(l.Tokens) > 0 {
 			return nil, errors.New("unexpected tokens after while block")
 		}
-		return While{res, w.condition}, nil
+		return While{res, w.condition, w.context}, nil
 	}
 	return nil, nil
 }